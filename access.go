@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests to the status page and API are rate limited per client, so an
+// exposed instance can't be trivially hammered, and every request is logged
+// so access can be audited. A request carrying a tenant's or the operator's
+// token counts against that token, wherever it comes from, so a dashboard
+// behind NAT doesn't share its limit with everyone else behind it and a
+// token spread over many addresses doesn't get a limit at each. Other
+// requests count against their address. Clients that have been idle long
+// enough for their bucket to fill up again are forgotten
+
+const bucketSweep = time.Minute // Time between looks for idle buckets to forget
+
+type tokenBucket struct {
+	tokens float64   // Requests the client can still make right now
+	last   time.Time // Time the bucket was last refilled
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	perMin  float64                 // Requests allowed per client per minute
+	buckets map[string]*tokenBucket // Buckets by token ID, or client address
+	swept   time.Time               // Time idle buckets were last forgotten
+}
+
+// Method to take a token from the bucket of the supplied client, its token ID
+// or address, refilling it for the time since it was last used. Returns false
+// if the bucket is empty
+func (rl *rateLimiter) allow(client string, now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Forget clients whose buckets have filled up again, which takes a minute
+	// at most, so the map only holds those seen lately
+	if now.Sub(rl.swept) >= bucketSweep {
+		for c, ob := range rl.buckets {
+			if now.Sub(ob.last) > time.Minute {
+				delete(rl.buckets, c)
+			}
+		}
+		rl.swept = now
+	}
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rl.perMin, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * rl.perMin
+	if b.tokens > rl.perMin {
+		b.tokens = rl.perMin
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Wrap the supplied handler with per-client rate limiting and request logging.
+// A limit of 0 turns rate limiting off. Tokens are logged by their ID, never
+// themselves
+func accessControl(next http.Handler, perMin int) http.Handler {
+	rl := &rateLimiter{perMin: float64(perMin), buckets: make(map[string]*tokenBucket)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		id := tokenID(r)
+		key := client
+		if len(id) > 0 {
+			key = id
+		} else {
+			id = "-"
+		}
+
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if perMin > 0 && !rl.allow(key, start) {
+			sr.Header().Set("Retry-After", strconv.Itoa(int(60/rl.perMin)+1))
+			http.Error(sr, "Too many requests", http.StatusTooManyRequests)
+		} else {
+			next.ServeHTTP(sr, r)
+		}
+		aLog.Printf("method=%s path=%q status=%d latency=%v client=%s token=%s",
+			r.Method, r.URL.Path, sr.status, time.Since(start), client, id)
+	})
+}
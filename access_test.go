@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests carrying a token count against it from every address, and other
+// requests against their address
+func TestAccessControlKeys(t *testing.T) {
+	before, logger := currentSettings(), aLog
+	t.Cleanup(func() { useSettings(before); aLog = logger })
+	var logged strings.Builder
+	aLog = newLogger(&logged, "ACCESS - ")
+	s := *before
+	s.tenants, s.operatorToken = []tenant{{name: "acme", token: "acme-token"}}, "op-token"
+	useSettings(&s)
+
+	h := accessControl(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), 2)
+	status := func(addr, token string) int {
+		r := httptest.NewRequest("GET", "/api/targets", nil)
+		r.RemoteAddr = addr + ":40000"
+		if len(token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	for i, test := range []struct {
+		addr, token string
+		status      int
+	}{
+		{"192.0.2.1", "acme-token", http.StatusOK},
+		{"192.0.2.2", "acme-token", http.StatusOK},
+		{"192.0.2.3", "acme-token", http.StatusTooManyRequests}, // The token's limit is used up
+		{"192.0.2.3", "op-token", http.StatusOK},
+		{"192.0.2.3", "", http.StatusOK},
+		{"192.0.2.3", "wrong", http.StatusOK}, // Unknown tokens count against the address
+		{"192.0.2.3", "other", http.StatusTooManyRequests},
+	} {
+		if got := status(test.addr, test.token); got != test.status {
+			t.Errorf("request %d from %s with token %q: status %d, not %d", i, test.addr, test.token, got,
+				test.status)
+		}
+	}
+	if text := logged.String(); !strings.Contains(text, " client=192.0.2.1 token=tenant:acme\n") ||
+		!strings.Contains(text, " client=192.0.2.3 token=-\n") || strings.Contains(text, "acme-token") {
+		t.Errorf("tokens logged as:\n%s", text)
+	}
+}
+
+// Buckets of clients that have been idle for long enough to fill up again are
+// forgotten, however few clients there are
+func TestRateLimiterForgetsIdle(t *testing.T) {
+	rl := &rateLimiter{perMin: 60, buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+	rl.allow("192.0.2.1", now)
+	rl.allow("192.0.2.2", now.Add(30*time.Second))
+	rl.allow("192.0.2.3", now.Add(90*time.Second))
+	if _, ok := rl.buckets["192.0.2.1"]; ok || len(rl.buckets) != 2 {
+		t.Errorf("buckets of %d clients kept, the idle one included: %v", len(rl.buckets), ok)
+	}
+}
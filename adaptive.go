@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// On a chronically mediocre link, an event for every blip and period of
+// flakey latency soon teaches people to ignore them. With -adaptive, autoping
+// learns how many of each a target usually has in a day from up to 30 days of
+// its history, and only raises an event once today's count goes over that
+// norm times the supplied factor, once a day for each kind. Everything is
+// still logged. Until a target has a week of history, every event is raised
+
+const adaptiveMinDays = 7 // Days of history needed before events are held back
+
+type adaptiveState struct {
+	day    time.Time       // Day events were last raised on
+	raised map[string]bool // Kinds of event raised that day
+}
+
+// Method to decide whether to raise an event for a blip or period of flakey
+// latency, of the supplied kind, that finished at the supplied time. It must
+// already have been recorded in the target's history
+func (tg *target) notify(kind string, t time.Time) bool {
+	if *adaptiveFlag <= 0 {
+		return true
+	}
+	today := startOfDay(t)
+	first := tg.hist.firstRecord()
+	if first.IsZero() || today.Sub(first) < adaptiveMinDays*24*time.Hour {
+		return true
+	}
+	days := int(today.Sub(first).Hours() / 24)
+	if days > 30 {
+		days = 30
+	}
+	from := today.AddDate(0, 0, -days)
+
+	var past, now int
+	count := func(start time.Time) {
+		switch {
+		case !start.Before(today):
+			now++
+		case !start.Before(from):
+			past++
+		}
+	}
+	if kind == "Blip" {
+		tg.hist.mu.Lock()
+		for _, b := range tg.hist.blips {
+			count(b.start)
+		}
+		tg.hist.mu.Unlock()
+	} else {
+		for _, inc := range tg.hist.allIncidents() {
+			if strings.HasPrefix(inc.kind, "Flakey latency") {
+				count(inc.start)
+			}
+		}
+	}
+
+	norm := float64(past) / float64(days)
+	if float64(now) <= norm**adaptiveFlag {
+		tg.logf(tLog, "%d %s events today, within %v times the usual %.1f a day", now, kind,
+			*adaptiveFlag, norm)
+		return false
+	}
+	if !tg.adapt.day.Equal(today) {
+		tg.adapt = adaptiveState{day: today, raised: make(map[string]bool)}
+	}
+	if tg.adapt.raised[kind] {
+		return false
+	}
+	tg.adapt.raised[kind] = true
+	tg.logf(oLog, "Unusual day: %d %s events so far, over %v times the usual %.1f a day", now,
+		strings.ToLower(kind), *adaptiveFlag, norm)
+	return true
+}
+
+// Method to return the time of the earliest ping or blip recorded, or the
+// zero time if there are none
+func (h *pingHistory) firstRecord() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var first time.Time
+	if len(h.buckets) > 0 {
+		first = time.Unix(h.buckets[0].start, 0)
+	}
+	if len(h.blips) > 0 && (first.IsZero() || h.blips[0].start.Before(first)) {
+		first = h.blips[0].start
+	}
+	return first
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// "autoping analytics" answers the questions asked of a long history most
+// often without anyone writing SQL: availability week by week, latency
+// percentiles by hour of the day and how long each outage lasted. Each view
+// prints as a table, or as CSV for a spreadsheet or DuckDB's read_csv. -sql
+// prints the view as a DuckDB query over the files "autoping export" writes
+// instead, as a start for queries of one's own. Those files leave out pongs
+// sampled out of the log and times are in UTC there, so the figures can
+// differ a little
+
+// A view of the log file
+type analyticsView struct {
+	about  string   // What the view shows
+	header []string // Names of the columns
+	rows   func(path string, from, to time.Time, only []string) ([][]string, error)
+	sql    string // Same view as a DuckDB query over the exported files
+}
+
+var analyticsViews = map[string]analyticsView{
+	"availability": {"share of pings answered, outages and downtime of each target by week",
+		[]string{"week", "target", "pings", "lost", "availability_pct", "outages", "downtime_min"},
+		weeklyAvailability, `WITH s AS (
+  SELECT date_trunc('week', time) AS week, target, count(*) FILTER (WHERE NOT lost) AS answered,
+    count(*) FILTER (WHERE failure = 'timeout') AS lost
+  FROM 'samples.parquet' GROUP BY ALL
+), o AS (
+  SELECT date_trunc('week', "end") AS week, target, count(*) AS outages, sum(duration_s) / 60 AS downtime_min
+  FROM 'incidents.parquet' WHERE kind = 'Outage' GROUP BY ALL
+)
+SELECT s.week, s.target, answered + lost AS pings, lost, round(100 * answered / (answered + lost), 3) AS availability_pct,
+  coalesce(outages, 0) AS outages, round(coalesce(downtime_min, 0), 1) AS downtime_min
+FROM s LEFT JOIN o ON s.week = o.week AND s.target IS NOT DISTINCT FROM o.target
+ORDER BY s.week, s.target;`},
+
+	"latency": {"mean and percentile RTTs of each target by hour of the day",
+		[]string{"target", "hour", "pongs", "mean_rtt_ms", "p50_rtt_ms", "p95_rtt_ms", "p99_rtt_ms"},
+		hourlyLatency, `SELECT target, hour(time) AS hour, count(*) AS pongs, round(avg(rtt_ms), 1) AS mean_rtt_ms,
+  quantile_disc(rtt_ms, 0.5) AS p50_rtt_ms, quantile_disc(rtt_ms, 0.95) AS p95_rtt_ms,
+  quantile_disc(rtt_ms, 0.99) AS p99_rtt_ms
+FROM 'samples.parquet' WHERE NOT lost
+GROUP BY ALL ORDER BY target, hour;`},
+
+	"outages": {"start, end and duration of every outage",
+		[]string{"target", "start", "end", "duration_min"},
+		outageDurations, `SELECT target, start, "end", round(duration_s / 60, 1) AS duration_min
+FROM 'incidents.parquet' WHERE kind = 'Outage'
+ORDER BY start;`},
+}
+
+// Returns the names of the views, sorted
+func analyticsNames() []string {
+	var names []string
+	for name := range analyticsViews {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Print the view named in the supplied arguments of the log file between the
+// days given with -from and -to. If targets are given, only theirs are shown
+func analyticsCommand(args []string) error {
+	fs := subcommandFlags("analytics", "i", "log", "c", "interval", "profile", "locale", "timezone")
+	format := fs.String("format", "table", "table, or csv for a spreadsheet or DuckDB")
+	fromFlag := fs.String("from", "", "first day to cover, e.g. 2018-06-01. The start of the log if not set")
+	toFlag := fs.String("to", "", "last day to cover, e.g. 2018-06-30. Today if not set")
+	sqlFlag := fs.Bool("sql", false, "print the view as a DuckDB query over the files of autoping export instead")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Usage: autoping analytics [flags] <view>")
+		fmt.Fprintln(out, "\nViews:")
+		for _, name := range analyticsNames() {
+			fmt.Fprintf(out, "  %-14s %s\n", name, analyticsViews[name].about)
+		}
+		fmt.Fprintln(out, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: autoping analytics [flags] %s", strings.Join(analyticsNames(), "|"))
+	}
+	view, ok := analyticsViews[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown view '%s'. Use one of %s", fs.Arg(0), strings.Join(analyticsNames(), ", "))
+	}
+	if *sqlFlag {
+		fmt.Println(view.sql)
+		return nil
+	}
+	if *format != "table" && *format != "csv" {
+		return fmt.Errorf("unknown analytics format '%s'. Use table or csv", *format)
+	}
+	if err := configure(fs); err != nil {
+		return err
+	}
+	today := startOfDay(time.Now())
+	from, to, err := dayRange(*fromFlag, *toFlag, time.Time{}, today.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	rows, err := view.rows(*logFlag, from, to, currentSettings().targets)
+	if err != nil {
+		return err
+	}
+	if *format == "csv" {
+		return writeCSV(os.Stdout, view.header, rows)
+	}
+	return writeTable(os.Stdout, view.header, rows)
+}
+
+// Returns a row for every week and target of the supplied log file from start
+// up to end, with the same availability, outages and downtime as the daily
+// report. Weeks start on Monday
+func weeklyAvailability(path string, start, end time.Time, only []string) ([][]string, error) {
+	type key struct{ week, target string }
+	weeks := make(map[key]*daySummary)
+	var keys []key
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if t.Before(start) || !t.Before(end) || !inTargets(name, only) {
+			return
+		}
+		monday := t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		k := key{monday.Format("2006-01-02"), name}
+		if weeks[k] == nil {
+			weeks[k] = newDaySummary()
+			keys = append(keys, k)
+		}
+		weeks[k].add(prefix, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].week != keys[j].week {
+			return keys[i].week < keys[j].week
+		}
+		return keys[i].target < keys[j].target
+	})
+	var rows [][]string
+	for _, k := range keys {
+		// Lines such as "Stopped" don't name a target, and leave a week of
+		// nothing behind
+		w := weeks[k]
+		if w.recv+w.lost == 0 && w.outages == 0 {
+			continue
+		}
+		avail := ""
+		if w.recv+w.lost > 0 {
+			avail = fmt.Sprintf("%.3f", 100*float64(w.recv)/float64(w.recv+w.lost))
+		}
+		rows = append(rows, []string{k.week, k.target, strconv.Itoa(w.recv + w.lost), strconv.Itoa(w.lost), avail,
+			strconv.Itoa(w.outages), fmt.Sprintf("%.1f", w.downtime.Minutes())})
+	}
+	return rows, nil
+}
+
+// Returns a row for every target and hour of the day of the supplied log file
+// from start up to end in which there were pongs, with their mean RTT and
+// percentiles. Pongs sampled out of the log are left out
+func hourlyLatency(path string, start, end time.Time, only []string) ([][]string, error) {
+	type key struct {
+		target string
+		hour   int
+	}
+	hours := make(map[key][]time.Duration)
+	var keys []key
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if prefix != "PING" || t.Before(start) || !t.Before(end) || !inTargets(name, only) ||
+			!pongLine.MatchString(msg) {
+			return
+		}
+		rtt, err := time.ParseDuration(pongLine.FindStringSubmatch(msg)[1])
+		if err != nil {
+			return
+		}
+		k := key{name, t.Hour()}
+		if hours[k] == nil {
+			keys = append(keys, k)
+		}
+		hours[k] = append(hours[k], rtt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		return keys[i].hour < keys[j].hour
+	})
+	var rows [][]string
+	for _, k := range keys {
+		rtts := hours[k]
+		rows = append(rows, []string{k.target, fmt.Sprintf("%02d", k.hour), strconv.Itoa(len(rtts)),
+			msString(meanRtt(rtts)), msString(percentileRtt(rtts, 50)), msString(percentileRtt(rtts, 95)),
+			msString(percentileRtt(rtts, 99))})
+	}
+	return rows, nil
+}
+
+// Returns a row for every outage of the supplied log file that finished from
+// start up to end, oldest first
+func outageDurations(path string, start, end time.Time, only []string) ([][]string, error) {
+	var rows [][]string
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if prefix != "OUTAGE" || t.Before(start) || !t.Before(end) || !inTargets(name, only) {
+			return
+		}
+		if inc, ok := parseIncident(msg, t); ok && inc.kind == "Outage" {
+			rows = append(rows, []string{name, inc.start.Format("2006-01-02 15:04:05"),
+				inc.end.Format("2006-01-02 15:04:05"), fmt.Sprintf("%.1f", inc.end.Sub(inc.start).Minutes())})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Outages are logged as they finish, so a long one comes after shorter
+	// ones that started later
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][1] < rows[j][1] })
+	return rows, nil
+}
+
+// Write the supplied rows to w as CSV, under the supplied header
+func writeCSV(out io.Writer, header []string, rows [][]string) error {
+	w := csv.NewWriter(out)
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+// Write the supplied rows to w as a table with aligned columns, under the
+// supplied header
+func writeTable(out io.Writer, header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
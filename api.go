@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kurankat/autoping-go/client"
+)
+
+// The JSON API serves the same read-only information as the status page for
+// dashboards and scripts. Its types live in the client package, so the server
+// and the client can't drift apart
+
+// Write v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		eLog.Printf("Writing JSON response: %v", err)
+	}
+}
+
+// Method to return the current state of the target as served by the API
+func (tg *target) apiStatus() client.Status {
+	now := time.Now()
+	return client.Status{
+		Target:             tg.addr,
+		State:              tg.state(),
+		Availability24h:    tg.hist.availability(now.Add(-24 * time.Hour)),
+		Availability30d:    tg.hist.availability(now.Add(-30 * 24 * time.Hour)),
+		LastSuccessfulPing: tg.liveState().lastPong,
+		MonitoringSince:    tg.hist.start,
+		Blips24h:           tg.hist.blipCount(now.Add(-24 * time.Hour)),
+		BlipsByHour:        tg.hist.blipsByHour(now.Add(-30 * 24 * time.Hour)),
+		Failures:           tg.failures.snapshot(),
+		Labels:             currentSettings().labels[tg.addr],
+	}
+}
+
+// Serve the current state of the target named by the "target" query
+// parameter, or of the first target if there is none
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	tgs := requestTargets(r)
+	if len(tgs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	tg := tgs[0]
+	if name := r.URL.Query().Get("target"); len(name) > 0 {
+		if tg = requestTarget(r, name); tg == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	writeJSON(w, tg.apiStatus())
+}
+
+// Serve the current state of every target
+func apiTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := []client.Status{}
+	for _, tg := range requestTargets(r) {
+		statuses = append(statuses, tg.apiStatus())
+	}
+	writeJSON(w, statuses)
+}
+
+// Serve the pings of the target named by the "target" query parameter, or of
+// the first target, fired at or after the time given as "since", from its
+// ring file. Every ping in the ring is served if since isn't given
+func apiSamplesHandler(w http.ResponseWriter, r *http.Request) {
+	tgs := requestTargets(r)
+	if len(tgs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	tg := tgs[0]
+	if name := r.URL.Query().Get("target"); len(name) > 0 {
+		if tg = requestTarget(r, name); tg == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	var since time.Time
+	if s := r.URL.Query().Get("since"); len(s) > 0 {
+		var err error
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "since must be a time such as 2018-06-01T12:00:00Z", http.StatusBadRequest)
+			return
+		}
+	}
+	if tg.ring == nil {
+		http.Error(w, "No ring file is kept for "+tg.addr+". Start autoping with -ring", http.StatusNotFound)
+		return
+	}
+	samples := []client.Sample{}
+	for _, s := range tg.ring.Samples(since) {
+		samples = append(samples, client.Sample{Time: s.Time, RTT: float64(s.RTT) / float64(time.Millisecond), Lost: !s.OK})
+	}
+	writeJSON(w, samples)
+}
+
+// Serve the recorded incidents of every target, oldest first
+func apiIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	incs := []client.Incident{}
+	for _, inc := range allIncidents(requestTargets(r)) {
+		ci := client.Incident{Target: inc.target, Kind: inc.kind, Start: inc.start}
+		if inc.end.IsZero() {
+			ci.Duration = now.Sub(inc.start).Seconds()
+		} else {
+			end := inc.end
+			ci.End = &end
+			ci.Duration = end.Sub(inc.start).Seconds()
+		}
+		incs = append(incs, ci)
+	}
+	writeJSON(w, incs)
+}
+
+// Serve the OpenAPI document describing every endpoint
+func apiSpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// OpenAPI document served, which is openAPISpec with the failure categories
+// of the client package, so the document can't drift from what is counted
+var openAPIDocument = func() []byte {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(openAPISpec), &doc); err != nil {
+		panic("openAPISpec: " + err.Error())
+	}
+	status := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})["Status"]
+	failures := status.(map[string]interface{})["properties"].(map[string]interface{})["failures"].(map[string]interface{})
+	props := make(map[string]interface{})
+	var names []string
+	for _, c := range client.FailureCategories {
+		props[c.Name] = map[string]interface{}{"type": "integer", "description": c.Description}
+		names = append(names, c.Name)
+	}
+	failures["properties"], failures["required"] = props, names
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic("openAPISpec: " + err.Error())
+	}
+	return append(b, '\n')
+}()
+
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "autoping",
+    "description": "Read-only status of the targets monitored by autoping. When the instance has tenants, every endpoint but this document needs a tenant or operator token, as a bearer token or a token query parameter, and only covers the targets the token may see. The operator token can narrow any endpoint to one tenant with a tenant query parameter",
+    "version": "1.3.0"
+  },
+  "security": [{}, {"token": []}],
+  "paths": {
+    "/": {
+      "get": {
+        "summary": "Status page",
+        "responses": {"200": {"description": "HTML status page", "content": {"text/html": {}}}}
+      }
+    },
+    "/badge/{target}.svg": {
+      "get": {
+        "summary": "Uptime badge with the current state and 30 day availability",
+        "parameters": [{"name": "target", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "SVG badge", "content": {"image/svg+xml": {}}},
+          "404": {"description": "Target isn't monitored"}
+        }
+      }
+    },
+    "/calendar.ics": {
+      "get": {
+        "summary": "Incidents as iCalendar events",
+        "responses": {"200": {"description": "iCalendar file", "content": {"text/calendar": {}}}}
+      }
+    },
+    "/feed.atom": {
+      "get": {
+        "summary": "Recent incidents as an Atom feed",
+        "responses": {"200": {"description": "Atom feed", "content": {"application/atom+xml": {}}}}
+      }
+    },
+    "/api/status": {
+      "get": {
+        "summary": "Current state of a target",
+        "parameters": [{"name": "target", "in": "query", "required": false, "description": "Defaults to the first target", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {
+            "description": "Current state",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}
+          },
+          "404": {"description": "Target isn't monitored"}
+        }
+      }
+    },
+    "/api/targets": {
+      "get": {
+        "summary": "Current state of every target, in the order they were given",
+        "responses": {
+          "200": {
+            "description": "Current states",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Status"}}}}
+          }
+        }
+      }
+    },
+    "/api/incidents": {
+      "get": {
+        "summary": "Recorded incidents of every target, oldest first",
+        "responses": {
+          "200": {
+            "description": "Incidents",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Incident"}}}}
+          }
+        }
+      }
+    },
+    "/api/samples": {
+      "get": {
+        "summary": "Pings of a target in the last 24 hours, oldest first, from its ring file",
+        "parameters": [
+          {"name": "target", "in": "query", "required": false, "description": "Defaults to the first target", "schema": {"type": "string"}},
+          {"name": "since", "in": "query", "required": false, "description": "Only pings fired at or after this time", "schema": {"type": "string", "format": "date-time"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Pings",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Sample"}}}}
+          },
+          "400": {"description": "since isn't a time"},
+          "404": {"description": "Target isn't monitored, or autoping wasn't started with -ring"}
+        }
+      }
+    },
+    "/api/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "responses": {"200": {"description": "OpenAPI document", "content": {"application/json": {}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "token": {"type": "http", "scheme": "bearer"}
+    },
+    "schemas": {
+      "Status": {
+        "type": "object",
+        "properties": {
+          "target": {"type": "string"},
+          "state": {"type": "string", "enum": ["Up", "Outage", "Degraded latency", "Paused"]},
+          "availability_24h": {"type": "number", "description": "Percentage of pings answered"},
+          "availability_30d": {"type": "number", "description": "Percentage of pings answered"},
+          "last_successful_ping": {"type": "string", "format": "date-time"},
+          "monitoring_since": {"type": "string", "format": "date-time"},
+          "blips_24h": {"type": "integer", "description": "Missed pings too short to count as outages"},
+          "blips_by_hour": {"type": "array", "items": {"type": "integer"}, "minItems": 24, "maxItems": 24, "description": "Blips in the last 30 days by hour of day, starting at midnight"},
+          "failures": {
+            "type": "object",
+            "description": "Failed pings since startup by category, with every category present"
+          },
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Labels of the target set in the config file, e.g. site=office. Left out if it has none"}
+        }
+      },
+      "Incident": {
+        "type": "object",
+        "properties": {
+          "target": {"type": "string"},
+          "kind": {"type": "string", "description": "Outage, or Flakey latency with its tier"},
+          "start": {"type": "string", "format": "date-time"},
+          "end": {"type": "string", "format": "date-time", "nullable": true, "description": "Null while the incident is still going"},
+          "duration_seconds": {"type": "number"}
+        }
+      },
+      "Sample": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time", "description": "Time the ping was fired"},
+          "rtt_ms": {"type": "number", "description": "0 if the pong was lost"},
+          "lost": {"type": "boolean"}
+        }
+      }
+    }
+  }
+}
+`
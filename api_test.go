@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kurankat/autoping-go/client"
+)
+
+// Returns the JSON names of the fields of the supplied struct type, sorted
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("json"); len(tag) > 0 && tag != "-" {
+			names = append(names, strings.Split(tag, ",")[0])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the keys of the supplied JSON object, sorted
+func jsonKeys(obj map[string]interface{}) []string {
+	var keys []string
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The OpenAPI document served describes every field of the client's types and
+// every failure category that is counted, and no others
+func TestOpenAPIMatchesClient(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for name, typ := range map[string]reflect.Type{
+		"Status":   reflect.TypeOf(client.Status{}),
+		"Incident": reflect.TypeOf(client.Incident{}),
+		"Sample":   reflect.TypeOf(client.Sample{}),
+	} {
+		props := make(map[string]interface{})
+		for k, v := range doc.Components.Schemas[name].Properties {
+			props[k] = v
+		}
+		if got, want := jsonKeys(props), jsonFields(typ); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s schema has %v, client has %v", name, got, want)
+		}
+	}
+
+	failures := doc.Components.Schemas["Status"].Properties["failures"]
+	props, _ := failures["properties"].(map[string]interface{})
+	var counted []string
+	for c := range (&failureCounter{counts: map[string]int{}}).snapshot() {
+		counted = append(counted, c)
+	}
+	sort.Strings(counted)
+	if got := jsonKeys(props); !reflect.DeepEqual(got, counted) {
+		t.Errorf("failures schema has %v, %v are counted", got, counted)
+	}
+	if len(counted) != len(client.FailureCategories) {
+		t.Errorf("%d categories are counted, the client has %d", len(counted), len(client.FailureCategories))
+	}
+}
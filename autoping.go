@@ -4,231 +4,850 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"syscall"
 	"time"
 
-	ping "github.com/sparrc/go-ping"
+	ping "github.com/go-ping/ping"
 )
 
 type dLatPing struct {
 	crDod   bool          // Is the previous ping latency dodgy?
 	prDod   bool          // Did the previous ping of dodgy latency?
 	latency time.Duration // Latency of latest ping
+	tier    int           // Latency tier of latest ping, -1 if normal
 	pTime   time.Time     // Time latest ping was fired
 }
 
-type connTracker struct {
-	isOutage           bool
-	lastSuccessfulPing time.Time
-	outageDuration     time.Duration
-}
-
 // Set up flags, loggers and global variables
-var importFlag = flag.String("i", "", "IP address or hostname to be pinged")
+var importFlag targetList
 var traceFlag = flag.Bool("t", false, "turn on trace to file")
-var pLog, eLog, oLog, tLog *log.Logger
-var ipAddr string // User supplied IP address to ping to
+var profileFlag = flag.Bool("p", false, "log when the day's RTT distribution departs from previous days")
+var statFlag = flag.String("s", "min", "RTT statistic used to evaluate latency: min, avg or max")
+var webFlag = flag.String("w", "", "address to serve a read-only status page on, e.g. :8080")
+var minOutageFlag = flag.Duration("min-outage", 2*time.Minute, "time without a pong before an outage is declared, shorter gaps are logged as blips")
+var outageAfterFlag = flag.Int("outage-after", 0, "missed pings in a row before an outage is declared, instead of going by -min-outage")
+var localeFlag = flag.String("locale", "en", "locale of durations, percentages and timestamps on the status page, feed and calendar")
+var lowPowerFlag = flag.Bool("low-power", false, "ping every 5 minutes and skip the latency profile check, as on battery")
+var graceFlag = flag.Duration("g", 0, "startup grace period during which no outages are declared, e.g. 5m")
+var ipv6Flag = flag.Bool("prefer-ipv6", false, "ping the target's IPv6 address when it has both IPv4 and IPv6 addresses")
+var unprivilegedFlag = flag.Bool("unprivileged", false, "ping through unprivileged ICMP sockets, so autoping can run as a normal user, on Linux and macOS")
+var osLogFlag = flag.Bool("oslog", false, "also send log lines to the macOS unified log")
+var eventLogFlag = flag.Bool("eventlog", false, "also write outages and recoveries to the Windows Event Log")
+var rateFlag = flag.Int("r", 60, "requests per minute allowed from each status page client address or token, 0 for no limit")
+var exportFlag = flag.Bool("e", false, "same as 'autoping report -format ics'")
+var patternFlag = flag.Bool("a", false, "same as 'autoping report -format patterns'")
+var monthFlag = flag.String("m", "", "same as 'autoping report -format csv' for the supplied month, e.g. 2018-06")
+var logFlag = flag.String("log", defaultLogPath, "file to log to, or stdout or stderr")
+var sandboxFlag = flag.Bool("sandbox", true, "restrict system calls once started, with pledge/unveil on OpenBSD or seccomp on Linux")
+var ringFlag = flag.String("ring", "", "directory to keep the last 24h of each target's pings in memory-mapped ring files")
+var checkFlag = flag.Bool("check", false, "check the config, targets, log and ring files and ICMP socket without pinging, and exit")
+var configFlag = flag.String("c", "", "YAML or TOML file with targets, ping interval and timeout, log file and latency thresholds")
+var pLog, eLog, oLog, tLog, aLog *log.Logger
+
+var intervalFlag = flag.Duration("interval", defaultInterval, "time between pings, 1s or more")
+var timeoutFlag = flag.Duration("timeout", 0, "time to wait for pongs, shorter than the interval. Half the interval, up to 30s, if not set")
+var sampleFlag = flag.Int("sample", 1, "log 1 in this many pongs of each target. Failures, slow pongs and recoveries are always logged")
+var onceFlag = flag.Bool("once", false, "ping each target once, say which answered and exit, with status 3 if any didn't")
+var durationFlag = flag.Duration("duration", 0, "stop after monitoring for this long, e.g. 2h, and print a summary of each target")
+var adaptiveFlag = flag.Float64("adaptive", 0, "only raise blip and flakey latency events once a day has more than this many times a target's usual number, e.g. 2")
+var countFlag = flag.Int("count", 1, "echo requests sent to each target every interval, to log the share lost in each")
 
-var connInfo = connTracker{isOutage: false}
+const defaultInterval = 1 * time.Minute // Time between pings unless set
 
-var spl []dLatPing // List of recent pings with dodgy latency
-var latSlice queue
-var meanLat time.Duration
+func init() {
+	flag.Var(&importFlag, "i", "IP address or hostname to be pinged. Repeat, or separate with commas, to ping several")
+}
 
 func main() {
+	// Mistakes in the flags exit with exitConfig, rather than the status 2
+	// the flag package would exit with, which means a permission is missing
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+
+	// Subcommands other than run take their own arguments
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			if err := command.run(args[1:]); err != nil {
+				exitAfter(err)
+			}
+			os.Exit(exitOK)
+		}
+		if args[0] == "run" {
+			args = args[1:]
+		}
+	}
+
 	// Parse user flags
-	flag.Parse()
+	flag.Usage = usage
+	if err := parseFlags(flag.CommandLine, args); err != nil {
+		exitAfter(err)
+	}
+	if flag.NArg() > 0 {
+		fmt.Printf("Unknown command '%s'. Run 'autoping -h' for the list of commands\n", flag.Arg(0))
+		os.Exit(exitConfig)
+	}
 
-	// If the user has supplied an IP address or hostname, save it for later use.
-	// If not, exit
-	if len(*importFlag) > 0 {
-		ipAddr = *importFlag
+	// Pick the locale and apply the settings in the config file that weren't
+	// given as flags
+	if err := configure(flag.CommandLine); err != nil {
+		fmt.Println(err)
+		os.Exit(exitStatus(err))
+	}
+
+	// -e, -a and -m date from before the report command and are kept for the
+	// scripts that use them. If targets are given, only they are included
+	if *exportFlag {
+		if err := exportCalendar(os.Stdout, *logFlag, time.Time{}, time.Time{}, currentSettings().targets); err != nil {
+			fmt.Println("Exporting outages:", err)
+			os.Exit(exitStatus(err))
+		}
+		os.Exit(exitOK)
+	}
+	if *patternFlag {
+		now := time.Now()
+		if err := lossPatterns(os.Stdout, *logFlag, now.AddDate(0, 0, -patternDays), now, currentSettings().targets); err != nil {
+			fmt.Println("Analysing lost pings:", err)
+			os.Exit(exitStatus(err))
+		}
+		os.Exit(exitOK)
+	}
+	if len(*monthFlag) > 0 {
+		if err := monthlyReport(os.Stdout, *logFlag, *monthFlag, currentSettings().targets); err != nil {
+			fmt.Println("Writing monthly report:", err)
+			os.Exit(exitStatus(err))
+		}
+		os.Exit(exitOK)
+	}
+
+	// If the user has supplied IP addresses or hostnames, set up a target for
+	// each. If not, exit
+	if addrs := pingAddrs(); len(addrs) > 0 {
+		setTargets(addrs)
 	} else {
 		fmt.Println("You forgot to provide the IP address or hostname to be pinged")
 		fmt.Println("Try 'sudo pingtests -i <IP ADDRESS or HOSTNAME>'")
-		os.Exit(1)
+		os.Exit(exitConfig)
+	}
+
+	if currentSettings().outageAfter < 0 {
+		fmt.Println("-outage-after must be 1 or more missed pings")
+		os.Exit(exitConfig)
+	}
+	if *adaptiveFlag != 0 && *adaptiveFlag < 1 {
+		fmt.Println("-adaptive must be a factor of 1 or more, or 0 to raise every event")
+		os.Exit(exitConfig)
+	}
+	if *durationFlag < 0 || *onceFlag && *durationFlag > 0 {
+		fmt.Println("-duration must be more than 0, and can't be given with -once")
+		os.Exit(exitConfig)
+	}
+	if *unprivilegedFlag && runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		fmt.Println("-unprivileged only works on Linux and macOS, which have unprivileged ICMP sockets")
+		os.Exit(exitConfig)
+	}
+	if *jitterFlag < 0 {
+		fmt.Println("-jitter can't be negative")
+		os.Exit(exitConfig)
+	}
+	if len(*pushgatewayFlag) > 0 && !*onceFlag {
+		fmt.Println("-pushgateway only works with -once. A running autoping can push with remote_write in the config file")
+		os.Exit(exitConfig)
+	}
+
+	// Make sure the RTT statistic is one we know how to pick
+	switch *statFlag {
+	case "min", "avg", "max":
+	default:
+		fmt.Printf("Unknown RTT statistic '%s'. Use min, avg or max\n", *statFlag)
+		os.Exit(exitConfig)
+	}
+
+	// Check that everything is in place to start, without pinging
+	if *checkFlag {
+		if err := checkSetup(); err != nil {
+			fmt.Println(err)
+			os.Exit(exitStatus(err))
+		}
+		os.Exit(exitOK)
+	}
+
+	// Unprivileged ICMP sockets have to be allowed on Linux, so say how before
+	// any pings fail
+	if *unprivilegedFlag {
+		if err := checkUnprivilegedICMP(); err != nil {
+			fmt.Println("Opening an unprivileged ICMP socket:", err)
+			os.Exit(exitStatus(err))
+		}
 	}
 
 	// Set up log file
-	logFile, err := os.OpenFile("/var/log/goping.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	logFile, err := openLog(*logFlag)
 	if err != nil {
-		panic("I'm having trouble writing to the log file")
-		os.Exit(1)
+		fmt.Println("I'm having trouble writing to the log file:", err)
+		os.Exit(exitStatus(err))
 	}
 	defer logFile.Close() // Defer closing until the program is done
+	if !logToStream(*logFlag) {
+		if err := lockLog(logFile); err == errLocked {
+			fmt.Println("Not starting: another autoping is already logging to", *logFlag)
+			os.Exit(exitConfig)
+		} else if err != nil {
+			fmt.Println("I'm having trouble locking the log file:", err)
+			os.Exit(exitStatus(err))
+		}
+	}
 
-	// Set up loggers for ping results, errors, and outages
-	pLog = log.New(logFile, "PING - ", log.LstdFlags)
-	eLog = log.New(logFile, "ERROR - ", log.LstdFlags)
-	oLog = log.New(logFile, "OUTAGE - ", log.LstdFlags)
-	tLog = log.New(ioutil.Discard, "TRACE - ", log.LstdFlags)
+	// Set up loggers for ping results, errors, outages and status page requests
+	pLog = newLogger(logFile, "PING - ")
+	eLog = newLogger(logFile, "ERROR - ")
+	oLog = newLogger(logFile, "OUTAGE - ")
+	tLog = newLogger(ioutil.Discard, "TRACE - ")
+	aLog = newLogger(logFile, "ACCESS - ")
 
 	if *traceFlag {
-		tLog.SetOutput(logFile)
+		setLogOutput(tLog, logFile)
 	}
 
-	// Set up channel and goroutine to handle interrupts
+	// Give the classes of log lines set in the config file files of their own
+	classLogs, err := openClassLogs()
+	if err != nil {
+		fmt.Println("I'm having trouble writing to the log file:", err)
+		os.Exit(exitStatus(err))
+	}
+	for _, f := range classLogs {
+		defer f.Close()
+	}
+
+	// Write every log line to the event file too, if there is one
+	loggers := []*log.Logger{pLog, eLog, oLog, aLog}
+	if *traceFlag {
+		loggers = append(loggers, tLog)
+	}
+	events, err := openEvents(loggers...)
+	if err != nil {
+		fmt.Println("I'm having trouble writing to the event file:", err)
+		os.Exit(exitStatus(err))
+	}
+	if events != nil {
+		defer events.Close()
+	}
+
+	// Keep the last log lines for a crash report, and write one if the main
+	// loop panics. Deferred after the files are, so it runs before they close
+	for _, l := range loggers {
+		setLogOutput(l, io.MultiWriter(logOutput(l), recentLogged))
+	}
+	defer crashed("main")
+
+	// Send log lines to the unified log if the user asked for it
+	if *osLogFlag {
+		if err := useOSLog(); err != nil {
+			fmt.Println("Logging to the unified log:", err)
+			os.Exit(exitStatus(err))
+		}
+	}
+	if *unprivilegedFlag {
+		tLog.Printf("Pinging with unprivileged ICMP sockets")
+	} else if !privilegedPing() {
+		tLog.Printf("Not running as root. Pinging with unprivileged ICMP sockets")
+	}
+	logExperiments()
+
+	// Open the Windows Event Log if the user asked for it
+	if *eventLogFlag {
+		if err := openEventLog(); err != nil {
+			fmt.Println("Opening the Windows Event Log:", err)
+			os.Exit(exitStatus(err))
+		}
+	}
+
+	// Keep recent pings in ring files if the user asked for it
+	openRings()
+
+	// Ping once and say how it went if that's all the user asked for
+	if *onceFlag {
+		time.Sleep(startJitter())
+		os.Exit(pingOnce())
+	}
+
+	// Say where to find this instance if the user asked for it
+	if err := writePidFile(); err != nil {
+		fmt.Println("I'm having trouble writing the pid file:", err)
+		os.Exit(exitStatus(err))
+	}
+
+	// Start from the state recorded in the log by earlier runs. A log written
+	// to stdout or stderr can't be read back
+	if logToStream(*logFlag) {
+		tLog.Printf("Logging to %v. Starting without the state of earlier runs", *logFlag)
+	} else if err := backfill(*logFlag); err != nil {
+		eLog.Printf("Reading back the log file: %v", err)
+	}
+
+	// Set up channel and goroutine to handle interrupts. The first one shuts
+	// down cleanly, and the default handling is back for any that follow
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for sig := range c {
-			eLog.Printf("Captured %v, stopping profiler and exiting..\n", sig)
-			pprof.StopCPUProfile()
-			os.Exit(1)
-		}
+		sig := <-c
+		pLog.Printf("Captured %v, shutting down", sig)
+		signal.Stop(c)
+		cancel()
 	}()
 	tLog.Printf("Setting up channel to handle interrupts")
 
-	// Launch separate goroutine to carry out ping every minute
-	interval := time.NewTicker(1 * time.Minute)
-	for _ = range interval.C {
-		tLog.Printf("Running ping now")
-		go runPing()
+	// Stop the same way once the time to monitor for is up
+	if *durationFlag > 0 {
+		time.AfterFunc(*durationFlag, func() {
+			pLog.Printf("Monitored for %v, shutting down", *durationFlag)
+			cancel()
+		})
+	}
+
+	// Serve the status page if the user asked for it
+	if len(*webFlag) > 0 {
+		serveStatus(ctx, *webFlag, *rateFlag)
+		tLog.Printf("Serving status page on %v", *webFlag)
+	}
+
+	// Send back the synthetic voice streams of other autopings if asked to
+	if len(*rtpReflectFlag) > 0 {
+		reflectRTP(ctx, *rtpReflectFlag)
+		tLog.Printf("Reflecting RTP on %v", *rtpReflectFlag)
+	}
+
+	// Email the reports set in the config file on their schedules
+	go sendReports(ctx)
+
+	// Push ping results to the remote-write receiver set in the config file,
+	// post them to the mirrors set there, publish metrics to the clouds set
+	// there and add incidents to its spreadsheet
+	go pushSamples(ctx)
+	go mirrorResults(ctx)
+	go publishClouds(ctx)
+	go appendSheetRows(ctx)
+
+	// Watch the targets file for targets being added and taken out. The
+	// watch is set up before the sandbox, which still lets the file be read
+	targetsChanged := make(chan struct{}, 1)
+	if len(*targetsFileFlag) > 0 {
+		watchTargetsFile(ctx, targetsChanged)
+	}
+
+	// Give up what isn't needed any more
+	if *sandboxFlag {
+		if err := sandbox(); err != nil {
+			eLog.Printf("Sandboxing: %v", err)
+			fmt.Println("Sandboxing failed:", err, "- pass -sandbox=false to run without it")
+			os.Exit(exitStatus(err))
+		}
+		tLog.Printf("Sandbox in place")
+	}
+
+	// Wait a moment of this instance's own before the first ping if asked to
+	if d := startJitter(); d > 0 {
+		tLog.Printf("Waiting %v before the first ping", d.Round(time.Millisecond))
+		sleepCtx(ctx, d)
+	}
+
+	// Launch separate goroutine to ping each target every interval, unless its
+	// pings are being held back after a panic, or only every few minutes when
+	// saving power. With -spread, each goroutine waits for the target's turn in
+	// the interval first. SIGHUP reloads the config file, as does a change to
+	// the targets file, and the pause and resume signals stop and restart the
+	// pings
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	pause := make(chan os.Signal, 1)
+	if pauseSignal != nil {
+		signal.Notify(pause, pauseSignal, resumeSignal)
+	}
+	tick := pingTick()
+	interval := time.NewTicker(tick)
+	// The targets and their intervals can change on a reload
+	retick := func() {
+		if was := tick; pingTick() != was {
+			tick = pingTick()
+			interval.Stop()
+			interval = time.NewTicker(tick)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// Let the pings in flight finish before closing what is still going
+			interval.Stop()
+			pings.Wait()
+			now := time.Now()
+			status := exitOK
+			for _, tg := range currentTargets() {
+				if tg.connInfo.isOutage && !tg.connInfo.maintenance {
+					status = exitOutage
+				}
+				summary := tg.shutdown(now)
+				if *durationFlag > 0 {
+					fmt.Printf("%s: %s\n", tg.addr, summary)
+				}
+			}
+			pushRemote()
+			sendSheetRows()
+			pprof.StopCPUProfile()
+			removePidFile()
+			pLog.Printf("Stopped")
+			os.Exit(status)
+		case <-hup:
+			if len(*configFlag) == 0 {
+				eLog.Printf("Received SIGHUP, but there is no config file to reload")
+				continue
+			}
+			if err := reloadConfig(*configFlag); err != nil {
+				eLog.Printf("Reloading config file: %v. Carrying on as before", err)
+				continue
+			}
+			tLog.Printf("Reloaded config file %v", *configFlag)
+			retick()
+		case <-targetsChanged:
+			if err := reloadTargetsFile(); err != nil {
+				eLog.Printf("Reloading targets file: %v. Carrying on as before", err)
+				continue
+			}
+			tLog.Printf("Reloaded targets file %v", *targetsFileFlag)
+			retick()
+		case sig := <-pause:
+			if sig == pauseSignal {
+				pauseMonitoring(time.Now())
+			} else {
+				resumeMonitoring(time.Now())
+			}
+		case t := <-interval.C:
+			checkClock(t)
+			if !monitoringPaused().IsZero() {
+				tLog.Printf("Monitoring paused, not pinging")
+				continue
+			}
+			tgs := currentTargets()
+			for i, tg := range tgs {
+				if !tg.pingDue(t, tick) {
+					if lowPower() && tg.interval() < lowPowerInterval {
+						tg.logf(tLog, "Skipping ping to save power")
+					}
+					continue
+				}
+				if !tg.sup.ready(t) {
+					tg.logf(tLog, "Holding back ping after a panic")
+					continue
+				}
+				offset := phaseOffset(i, len(tgs), tg.interval())
+				tg.logf(tLog, "Running ping in %v", offset)
+				pings.Add(1)
+				go func(tg *target) {
+					defer pings.Done()
+					if sleepCtx(ctx, offset) {
+						tg.sup.run(tg.runPing)
+					}
+				}(tg)
+			}
+		}
+	}
+}
+
+// Returns the time between the echo requests of one ping. They are sent a
+// second apart, or closer if that's needed for all of them to go out in the
+// first half of the supplied timeout, leaving time for the last pong to come
+// back
+func echoInterval(timeout time.Duration) time.Duration {
+	if d := timeout / time.Duration(2**countFlag); d < time.Second {
+		return d
 	}
+	return time.Second
 }
 
-// Separate function to run pings
-func runPing() {
+// Method to return the number of pings sent to the target in the supplied
+// time, at least 1
+func (tg *target) pingsIn(d time.Duration) int {
+	if n := int(d / tg.interval()); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// Returns true if pings should use raw ICMP sockets, which need root. macOS
+// also lets anyone ping through unprivileged ICMP sockets, which keeps working
+// inside the App Sandbox where raw sockets need an entitlement, so autoping
+// falls back to them there when it isn't root. Linux has them too, for the
+// groups in net.ipv4.ping_group_range, and autoping uses them there with
+// -unprivileged. FreeBSD and OpenBSD have no unprivileged ICMP sockets, so
+// autoping has to run as root there
+func privilegedPing() bool {
+	if *unprivilegedFlag {
+		return false
+	}
+	return runtime.GOOS != "darwin" || os.Geteuid() == 0
+}
+
+// Separate method to ping the target
+func (tg *target) runPing() {
+	if p := proberFor(tg.addr); p != nil {
+		p.probe(tg)
+		return
+	}
+
 	// Set up pinger and handle errors
 	t := time.Now() // Keep track of the time the ping was sent
-	tLog.Printf("Setting Ping time to %v", t)
-	pinger, err := ping.NewPinger(ipAddr)
-	if err != nil {
-		switch err.(type) {
-		case *net.DNSError:
-			if connInfo.lastSuccessfulPing.Year() == t.Year() &&
-				t.Sub(connInfo.lastSuccessfulPing) > 2*time.Minute {
-				connInfo.isOutage = true
-				connInfo.outageDuration = time.Now().Sub(connInfo.lastSuccessfulPing)
-				tLog.Printf("DNS error")
-				oLog.Printf("Lost contact. Outage duration %v",
-					connInfo.outageDuration)
-			}
-		default:
-			panic(err)
+	tg.logf(tLog, "Setting Ping time to %v", t)
+	pinger, err := ping.NewPinger(tg.addr)
+	if err == nil && *ipv6Flag {
+		var ip *net.IPAddr
+		if ip, err = resolveIPv6(tg.addr); err == nil {
+			tg.logf(tLog, "Pinging %v over IPv6", ip)
+			pinger.SetIPAddr(ip)
 		}
+	}
+	if err != nil {
+		tg.pingFailed(t, classifyFailure(err), err)
 	} else {
 		// Pinger settings.
-		pinger.Count = 1
-		tLog.Printf("Setting pinger count to %v", pinger.Count)
-		pinger.Timeout = 30 * time.Second
-		tLog.Printf("Setting pinger timeout to %v", pinger.Timeout)
-		pinger.SetPrivileged(true) // Needed to process TCP pings
-		tLog.Printf("Setting pinger to privileged")
+		pinger.Count = *countFlag
+		tg.logf(tLog, "Setting pinger count to %v", pinger.Count)
+		pinger.Timeout = tg.timeout()
+		tg.logf(tLog, "Setting pinger timeout to %v", pinger.Timeout)
+		pinger.Interval = echoInterval(pinger.Timeout)
+		tg.logf(tLog, "Setting pinger interval to %v", pinger.Interval)
+		pinger.SetPrivileged(privilegedPing()) // Needed to process TCP pings
+		tg.logf(tLog, "Setting pinger to privileged: %v", pinger.Privileged())
 
 		// What to do when ping comes in: log results
-		pinger.OnRecv = func(pkt *ping.Packet) {
-			pLog.Printf("%d bytes from %s: icmp_seq=%d time=%v", pkt.Nbytes, pkt.IPAddr,
-				pkt.Seq, pkt.Rtt)
-		}
+		pinger.OnRecv = tg.pongReceived
+		finished := false
 		pinger.OnFinish = func(s *ping.Statistics) {
-			// If no packets come back after timeout, start logging outage after 2 min
-			// since last successful ping (2 missed pings in a row)
-			if s.PacketsRecv == 0 {
-				tLog.Printf("Pinger timed out")
-				// The following conditions have to be met: the ping year of the last
-				// successful ping has to be this year (at the start of the run lsPing is
-				// set to 0) AND the time difference between the last successful ping and
-				// this one has to be more than 2 minutes
-				oLog.Printf("Timeout - Missed pong")
-				if connInfo.lastSuccessfulPing.Year() == t.Year() &&
-					t.Sub(connInfo.lastSuccessfulPing) > 2*time.Minute {
-					connInfo.isOutage = true
-					connInfo.outageDuration = time.Now().Sub(connInfo.lastSuccessfulPing)
-					oLog.Printf("Lost contact. Outage duration %v minutes",
-						connInfo.outageDuration)
-				}
-			} else if s.PacketsRecv > 0 {
-				// If we get a packet back, reset last successful ping time to the time this
-				// ping was fired, and reset outage
-				if connInfo.isOutage {
-					oLog.Printf("Connection restored. Total outage duration %v",
-						connInfo.outageDuration)
+			finished = true
+			tg.cycleFinished(t, s)
+		}
+		runErr := pinger.Run() // Send the ping
+
+		// The pinger gives up without finishing if it can't open its socket
+		if !finished {
+			category := failSocket
+			if os.Geteuid() != 0 {
+				category = failPermission
+			}
+			err := errors.New("pinger couldn't open an ICMP socket")
+			if runErr != nil {
+				err = fmt.Errorf("pinger couldn't open an ICMP socket: %w", runErr)
+			}
+			if !pinger.Privileged() {
+				// Find out why, e.g. a group left out of ping_group_range
+				if serr := checkUnprivilegedICMP(); serr != nil {
+					category, err = classifyFailure(serr), serr
 				}
-				connInfo.lastSuccessfulPing = t
-				connInfo.isOutage = false
-				tLog.Printf("Packet recieved. Sending to evaluateLatency()")
-				evaluateLatency(t, s.MinRtt)
 			}
+			tg.pingFailed(t, category, err)
 		}
 	}
-	pinger.Run() // Send the ping
+}
+
+// Method to log a pong of the target, unless it is sampled out
+func (tg *target) pongReceived(pkt *ping.Packet) {
+	if !tg.samplePong(pkt.Rtt) {
+		return
+	}
+	tg.logAppend(pLog, func(b []byte) []byte {
+		b = strconv.AppendInt(b, int64(pkt.Nbytes), 10)
+		b = append(b, " bytes from "...)
+		b = appendIPAddr(b, pkt.IPAddr)
+		b = append(b, ": icmp_seq="...)
+		b = strconv.AppendInt(b, int64(pkt.Seq), 10)
+		b = append(b, " time="...)
+		return appendDuration(b, pkt.Rtt)
+	})
+}
+
+// Method to act on the statistics of a cycle of pings of the target fired at
+// the supplied time: a missed pong counts towards an outage, and a pong ends
+// the outage or blip there was and has its latency evaluated
+func (tg *target) cycleFinished(t time.Time, s *ping.Statistics) {
+	defer tg.publish()
+
+	// If no packets come back after timeout, start logging outage after 2 min
+	// since last successful ping (2 missed pings in a row)
+	if s.PacketsRecv == 0 {
+		if *traceFlag {
+			tg.logf(tLog, "Pinger timed out")
+		}
+		if inMaintenance(tg.addr, t) {
+			tg.logAppend(oLog, func(b []byte) []byte { return append(b, "Timeout - Missed pong during maintenance"...) })
+		} else {
+			tg.logAppend(oLog, func(b []byte) []byte { return append(b, "Timeout - Missed pong"...) })
+
+			// The missed pong line only says the cycle was lost, so reports
+			// learn how many packets were from this one
+			if s.PacketsSent > 1 {
+				tg.logf(pLog, "Cycle of 0/%d packets, 100%% lost", s.PacketsSent)
+			}
+		}
+		tg.pingFailed(t, failTimeout, nil)
+	} else if s.PacketsRecv > 0 {
+		// If we get a packet back, reset last successful ping time to the time this
+		// ping was fired, and reset outage
+		// and missed pings. Missed pings that didn't add up to an outage are a
+		// blip
+		ended, missed, maint := tg.connInfo.pongAnswered(t)
+		took := ended.end.Sub(ended.start)
+		switch {
+		case ended.kind == "Outage" && maint:
+			tg.logf(oLog, "Connection restored after maintenance. Total outage duration %v", took)
+		case ended.kind == "Outage":
+			tg.logf(oLog, "Connection restored. Total outage duration %v", took)
+			tg.hist.addIncident("Outage", ended.start, t)
+			tg.exportIncident("Outage", ended.start, t)
+			tg.event(eventRestored, "Connection restored. Total outage duration %v", took)
+		case ended.kind == "Blip" && inMaintenance(tg.addr, ended.start):
+			tg.logf(oLog, "Blip during maintenance. %d missed pongs, recovered after %v", missed, took)
+		case ended.kind == "Blip":
+			tg.logf(oLog, "Blip. %d missed pongs, recovered after %v", missed, took)
+			tg.hist.addBlip(ended.start, t)
+			tg.exportIncident("Blip", ended.start, t)
+			if tg.notify("Blip", t) {
+				tg.event(eventBlip, "Blip. %d missed pongs, recovered after %v", missed, took)
+			}
+		}
+		rtt := cycleRtt(s)
+		tg.record(t, true, rtt)
+		tg.mirror(t, true, rtt, "")
+		if s.PacketsSent > 1 {
+			tg.logf(pLog, "Cycle of %d/%d packets, %.0f%% lost: min/avg/max/stddev = %v/%v/%v/%v",
+				s.PacketsRecv, s.PacketsSent, s.PacketLoss, s.MinRtt, s.AvgRtt, s.MaxRtt, s.StdDevRtt)
+		}
+		if *traceFlag {
+			tg.logf(tLog, "Packet recieved. Sending %s RTT to evaluateLatency()", *statFlag)
+		}
+		tg.evaluateLatency(t, rtt)
+		if *profileFlag && !lowPower() {
+			tg.checkProfile(t, rtt)
+		} else if *profileFlag {
+			tg.profile.load(t, rtt)
+		}
+	}
+}
+
+// Record a ping fired at the supplied time that failed for the supplied reason.
+// Timeouts are already logged as missed pongs, so only other errors are logged.
+// If the failure says something about the connection, start logging an outage
+// once the minimum outage duration (2 min by default) has passed since the last
+// successful ping, or once -outage-after pings in a row have been missed
+func (tg *target) pingFailed(t time.Time, category string, err error) {
+	defer tg.publish()
+	tg.flushSampled()
+	tg.failures.add(category)
+	tg.mirror(t, false, 0, category)
+	if err != nil {
+		tg.logf(eLog, "Ping failed (%s): %v", category, err)
+	}
+	if !countsAsMissed(category) {
+		return
+	}
+	tg.record(t, false, 0)
+	tg.connInfo.pongMissed(t)
+
+	// Don't declare outages while the network may still be coming up after a
+	// reboot
+	if t.Sub(tg.hist.start) < *graceFlag {
+		tg.logf(tLog, "Missed ping within the startup grace period of %v", *graceFlag)
+		return
+	}
+
+	outage, lost := tg.connInfo.loseContact(t, tg.outageRule(), inMaintenance(tg.addr, t))
+	if lost {
+		tg.event(eventOutage, "Lost contact. No pong since %v", tg.connInfo.lastSuccessfulPing)
+	}
+	if outage {
+		// Logged for every pong missed during an outage
+		tg.logAppend(oLog, func(b []byte) []byte {
+			if tg.connInfo.maintenance {
+				b = append(b, "Lost contact during maintenance. Outage duration "...)
+			} else {
+				b = append(b, "Lost contact. Outage duration "...)
+			}
+			return appendDuration(b, tg.connInfo.outageDuration)
+		})
+	}
+}
+
+// Resolve the supplied host, picking its first IPv6 address if it has one, and
+// its first IPv4 address otherwise. On IPv6-only networks with DNS64, names
+// that only have IPv4 addresses resolve to synthesised IPv6 addresses
+func resolveIPv6(host string) (*net.IPAddr, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			return &net.IPAddr{IP: ip}, nil
+		}
+	}
+	return &net.IPAddr{IP: ips[0]}, nil
+}
+
+// Pick the RTT statistic of a ping cycle chosen by the user to evaluate latency
+func cycleRtt(s *ping.Statistics) time.Duration {
+	switch *statFlag {
+	case "avg":
+		return s.AvgRtt
+	case "max":
+		return s.MaxRtt
+	default:
+		return s.MinRtt
+	}
+}
+
+// Dodgy pings are classified in tiers by how many times longer than the mean
+// latency they took
+var latencyTiers = []struct {
+	name string // Name of the tier. The threshold of each is in the settings
+}{
+	{"mild"},
+	{"severe"},
+	{"extreme"},
+}
+
+// Method to return the latency tier of a ping of the target with the supplied
+// RTT, or -1 if the RTT is normal (or there is no mean latency to compare
+// against yet)
+func (tg *target) latencyTier(rtt, mean time.Duration) int {
+	tier := -1
+	for i, above := range tg.thresholds() {
+		if mean > 0 && float64(rtt) > above*float64(mean) {
+			tier = i
+		}
+	}
+	return tier
 }
 
 // Evaluate latency of supplied ping. If ping has a long latency, add it to the
 // queue. If ping is normal (< 100 ms) then check if previous ping was also
 // normal. If so, finalise spl and log total duration of dodgy latency pings.
 // If previous ping was dodgy, ignore single normal ping and keep logging
-func evaluateLatency(t time.Time, rtt time.Duration) {
-	tLog.Printf("Evaluating Pong sent at %v with RTT of %v", t, rtt)
-	meanLat = time.Duration(latSlice.mean()) * time.Nanosecond
-	tLog.Printf("meanLat is currently %v", meanLat)
-	cutoff := meanLat * 3
+func (tg *target) evaluateLatency(t time.Time, rtt time.Duration) {
+	tg.meanLat = time.Duration(tg.latSlice.mean()) * time.Nanosecond
+	tier := tg.latencyTier(rtt, tg.meanLat)
 	prd := false // The previous ping is never dodgy by default
 
 	// Set up the provious dodgy ping to be that of the last item in spl
-	if len(spl) > 0 {
-		prd = spl[len(spl)-1].crDod // Set prd to the RTT of the previous dodgy ping
-		tLog.Printf("Setting prd to %v", spl[len(spl)-1].crDod)
-	} else {
-		tLog.Printf("spl length is 0")
+	if len(tg.spl) > 0 {
+		prd = tg.spl[len(tg.spl)-1].crDod // Set prd to the RTT of the previous dodgy ping
 	}
 
-	// If the ping RTT is more than the cutoff, treat as a dodgy ping and append
-	// to spl
-	if rtt > cutoff && cutoff > 0 {
-		tLog.Printf("Dodgy latency of %v", rtt)
-		dPing := dLatPing{crDod: true, prDod: prd, latency: rtt, pTime: t}
-		tLog.Printf("Creating dPing of %v", dPing)
-		spl = append(spl, dPing)
-		tLog.Printf("Total spl is %v", spl)
+	// Trace lines are only made when tracing, as their arguments are allocated
+	// even when the lines are thrown away
+	if *traceFlag {
+		tg.logf(tLog, "Evaluating Pong sent at %v with RTT of %v", t, rtt)
+		tg.logf(tLog, "meanLat is currently %v", tg.meanLat)
+		if len(tg.spl) > 0 {
+			tg.logf(tLog, "Setting prd to %v", prd)
+		} else {
+			tg.logf(tLog, "spl length is 0")
+		}
+	}
+
+	// If the ping RTT falls in one of the latency tiers, treat as a dodgy ping and
+	// append to spl
+	if tier >= 0 {
+		dPing := dLatPing{crDod: true, prDod: prd, latency: rtt, tier: tier, pTime: t}
+		tg.spl = append(tg.spl, dPing)
+		if *traceFlag {
+			tg.logf(tLog, "Dodgy latency of %v, %s tier", rtt, latencyTiers[tier].name)
+			tg.logf(tLog, "Creating dPing of %v", dPing)
+			tg.logf(tLog, "Total spl is %v", tg.spl)
+		}
 	} else {
 		// Because this is a 'normal' ping RTT, append it to queue to keep a running
 		// average
-		latSlice.add(float64(rtt.Nanoseconds()))
-		tLog.Printf("RTT of %v is normal. Adding it to latency slice to keep average",
-			float64(rtt.Nanoseconds()))
+		tg.latSlice.add(float64(rtt.Nanoseconds()))
+		if *traceFlag {
+			tg.logf(tLog, "RTT of %v is normal. Adding it to latency slice to keep average",
+				float64(rtt.Nanoseconds()))
+		}
 
 		// If the latency is OK, check that of previous. If that one is dodgy,
 		// keep logging until two consecutive normal pings
 		if prd {
-			tLog.Printf("Previous ping was dodgy and had an RTT of %v",
-				spl[len(spl)-1].latency)
-			dPing := dLatPing{crDod: false, prDod: prd, latency: rtt, pTime: t}
-			tLog.Printf("Because this Ping had a normal RTT, dPing is set to %v", dPing)
-			spl = append(spl, dPing)
-			tLog.Printf("Appending to spl. Current spl = %v", spl)
+			dPing := dLatPing{crDod: false, prDod: prd, latency: rtt, tier: -1, pTime: t}
+			if *traceFlag {
+				tg.logf(tLog, "Previous ping was dodgy and had an RTT of %v",
+					tg.spl[len(tg.spl)-1].latency)
+				tg.logf(tLog, "Because this Ping had a normal RTT, dPing is set to %v", dPing)
+			}
+			tg.spl = append(tg.spl, dPing)
+			if *traceFlag {
+				tg.logf(tLog, "Appending to spl. Current spl = %v", tg.spl)
+			}
 		} else {
 			// If two decent latency pings in a row, then log total and reset spl
-			if len(spl) > 2 {
-				tLog.Printf("Previous Ping and this Ping both have normal latencies: %v and %v",
-					spl[len(spl)-1].latency, rtt)
-				tLog.Printf("Calculating bad run and resetting spl")
-				startTime := spl[0].pTime
-				tLog.Printf("Start of dodgy latency run: %v", startTime)
-				endTime := spl[len(spl)-1].pTime
-				tLog.Printf("End of dodgy latency run: %v", endTime)
-				oLog.Printf("Period of flakey latency finished. Duration = %v",
-					endTime.Sub(startTime))
-				spl = nil
-				tLog.Printf("Resetting spl: %v", spl)
-			} else {
-				tLog.Printf("Length of spl is less than 2: %v", len(spl))
-				spl = nil
-				tLog.Printf("Resetting spl: %v", spl)
+			if len(tg.spl) > 2 {
+				if *traceFlag {
+					tg.logf(tLog, "Previous Ping and this Ping both have normal latencies: %v and %v",
+						tg.spl[len(tg.spl)-1].latency, rtt)
+					tg.logf(tLog, "Calculating bad run and resetting spl")
+				}
+				tg.endFlakeyPeriod("finished")
+			} else if *traceFlag {
+				tg.logf(tLog, "Length of spl is less than 2: %v", len(tg.spl))
+			}
+			tg.spl = nil
+			if *traceFlag {
+				tg.logf(tLog, "Resetting spl: %v", tg.spl)
 			}
 		}
 	}
 }
 
+// Method to log the end of the period of flakey latency in spl, as "finished",
+// "ongoing at shutdown" or "ongoing when paused", and record it as an incident
+func (tg *target) endFlakeyPeriod(how string) {
+	startTime := tg.spl[0].pTime
+	tg.logf(tLog, "Start of dodgy latency run: %v", startTime)
+	endTime := tg.spl[len(tg.spl)-1].pTime
+	tg.logf(tLog, "End of dodgy latency run: %v", endTime)
+	// Count the dodgy pings in each tier. The period takes the name of the
+	// worst tier reached
+	counts := make([]int, len(latencyTiers))
+	worst := 0
+	for _, p := range tg.spl {
+		if p.tier >= 0 {
+			counts[p.tier]++
+			if p.tier > worst {
+				worst = p.tier
+			}
+		}
+	}
+	tg.logf(oLog, "Period of %s flakey latency %s. Duration = %v "+
+		"(mild %d, severe %d, extreme %d pings)", latencyTiers[worst].name, how,
+		endTime.Sub(startTime), counts[0], counts[1], counts[2])
+	tg.hist.addIncident("Flakey latency ("+latencyTiers[worst].name+")",
+		startTime, endTime)
+	tg.exportIncident("Flakey latency ("+latencyTiers[worst].name+")",
+		startTime, endTime)
+	if tg.notify("Flakey latency", endTime) {
+		tg.event(eventFlakey, "Period of %s flakey latency %s. Duration = %v",
+			latencyTiers[worst].name, how, endTime.Sub(startTime))
+	}
+}
+
 type queue []float64 // Queue of RTTs for normal pings to calculate what's normal
 
 // Method to add a ping RTT to the queue, keeping the queue size to a max of 10
@@ -237,8 +856,9 @@ func (q *queue) add(f float64) {
 	if len(iq) < 10 {
 		iq = append(iq, f)
 	} else {
-		iq = iq[1:]
-		iq = append(iq, f)
+		// Shift along in place, so the queue keeps the same array
+		copy(iq, iq[1:])
+		iq[len(iq)-1] = f
 	}
 	*q = queue(iq)
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Metrics go to Azure Monitor as custom metrics of the resource set in the
+// config file, usually the VM autoping runs on. The token to send them with
+// comes from the VM's managed identity, through the instance metadata service,
+// so the identity needs the Monitoring Metrics Publisher role on the resource
+
+const azureNamespace = "autoping" // Namespace unless set
+
+var (
+	azureTokenMu  sync.Mutex
+	azureToken    string    // Token of the managed identity, once fetched
+	azureTokenEnd time.Time // Time the token expires
+)
+
+// Body of a custom metric sent to Azure Monitor
+type azureMetric struct {
+	Time string `json:"time"`
+	Data struct {
+		BaseData struct {
+			Metric    string        `json:"metric"`
+			Namespace string        `json:"namespace"`
+			DimNames  []string      `json:"dimNames"`
+			Series    []azureSeries `json:"series"`
+		} `json:"baseData"`
+	} `json:"data"`
+}
+
+type azureSeries struct {
+	DimValues []string `json:"dimValues"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Sum       float64  `json:"sum"`
+	Count     int      `json:"count"`
+}
+
+// Publish the packet loss and RTT of the supplied targets over the period
+// that started at the supplied time to Azure Monitor
+func putAzureMonitor(az config.AzureMonitor, start time.Time, stats []cloudStats) error {
+	token, err := azureIdentityToken()
+	if err != nil {
+		return fmt.Errorf("getting a token of the managed identity: %v", err)
+	}
+	namespace := az.Namespace
+	if len(namespace) == 0 {
+		namespace = azureNamespace
+	}
+
+	var loss, rtt []azureSeries
+	for _, st := range stats {
+		dims := []string{st.addr}
+		loss = append(loss, azureSeries{DimValues: dims, Min: st.loss(), Max: st.loss(), Sum: st.loss(), Count: 1})
+		if st.recv > 0 {
+			rtt = append(rtt, azureSeries{DimValues: dims, Min: ms(st.min), Max: ms(st.max), Sum: ms(st.sum),
+				Count: st.recv})
+		}
+	}
+	for _, m := range []struct {
+		name   string
+		series []azureSeries
+	}{{"PacketLoss", loss}, {"RTT", rtt}} {
+		if len(m.series) == 0 {
+			continue
+		}
+		var body azureMetric
+		body.Time = start.UTC().Format(time.RFC3339)
+		body.Data.BaseData.Metric = m.name
+		body.Data.BaseData.Namespace = namespace
+		body.Data.BaseData.DimNames = []string{"Target"}
+		body.Data.BaseData.Series = m.series
+		if err := postAzureMetric(az, token, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send the supplied custom metric to the resource set in the supplied
+// settings
+func postAzureMetric(az config.AzureMonitor, token string, metric azureMetric) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+	u := "https://" + az.Region + ".monitoring.azure.com" + az.ResourceID + "/metrics"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Returns a token of the VM's managed identity for Azure Monitor, kept until
+// shortly before it expires
+func azureIdentityToken() (string, error) {
+	azureTokenMu.Lock()
+	defer azureTokenMu.Unlock()
+	if time.Until(azureTokenEnd) > credsMinTTL {
+		return azureToken, nil
+	}
+
+	req, _ := http.NewRequest("GET", imdsURL+"/metadata/identity/oauth2/token?api-version=2018-02-01"+
+		"&resource=https%3A%2F%2Fmonitoring.azure.com%2F", nil)
+	req.Header.Set("Metadata", "true")
+	data, err := imdsGet(req)
+	if err != nil {
+		return "", err
+	}
+	var answer struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"` // Unix time, in a string
+	}
+	if err := json.Unmarshal([]byte(data), &answer); err != nil {
+		return "", err
+	}
+	end, err := strconv.ParseInt(answer.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("token expires on %q", answer.ExpiresOn)
+	}
+	azureToken, azureTokenEnd = answer.AccessToken, time.Unix(end, 0)
+	return azureToken, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// On startup, the state built up by earlier runs is read back from the log
+// file, so the latency baseline, the daily latency profiles and the history
+// behind the status page are good straight away instead of starting cold.
+// The last successful ping isn't restored: the time autoping was stopped
+// would otherwise count as an outage
+
+// Read back the last 30 days of the supplied log file. Lines logged before
+// autoping could ping several targets go to the first target; lines about
+// targets that are no longer pinged are skipped
+func backfill(path string) error {
+	since := time.Now().Add(-historyLength)
+	var pongs, incs int
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		tg := currentTargets()[0]
+		if len(name) > 0 {
+			tg = findTarget(name)
+		}
+		if t.Before(since) || tg == nil {
+			return
+		}
+		switch {
+		case prefix == "PING" && pongLine.MatchString(msg):
+			rtt, err := time.ParseDuration(pongLine.FindStringSubmatch(msg)[1])
+			if err != nil {
+				return
+			}
+			pongs++
+			tg.hist.record(t, true, rtt)
+			tg.profile.load(t, rtt)
+
+			// Only normal pings go into the latency baseline, as in evaluateLatency
+			mean := time.Duration(tg.latSlice.mean())
+			if len(tg.latSlice) == 0 || tg.latencyTier(rtt, mean) < 0 {
+				tg.latSlice.add(float64(rtt.Nanoseconds()))
+			}
+		case prefix == "PING" && sampledLine.MatchString(msg):
+			// Pongs sampled out of the log go into the history at their mean RTT, but
+			// not into the baseline or profiles
+			m := sampledLine.FindStringSubmatch(msg)
+			n, _ := strconv.Atoi(m[1])
+			rtt, err := time.ParseDuration(m[2])
+			for i := 0; err == nil && i < n; i++ {
+				tg.hist.record(t, true, rtt)
+			}
+		case prefix == "OUTAGE" && msg == "Timeout - Missed pong":
+			tg.hist.record(t, false, 0)
+		case prefix == "OUTAGE" && blipLine.MatchString(msg):
+			d, err := time.ParseDuration(blipLine.FindStringSubmatch(msg)[1])
+			if err == nil {
+				tg.hist.addBlip(t.Add(-d), t)
+			}
+		case prefix == "OUTAGE":
+			if inc, ok := parseIncident(msg, t); ok {
+				incs++
+				tg.hist.addIncident(inc.kind, inc.start, inc.end)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	tLog.Printf("Read back %d pongs and %d incidents from %v", pongs, incs, path)
+	for _, tg := range currentTargets() {
+		tg.logf(tLog, "meanLat is now %v", time.Duration(tg.latSlice.mean()))
+	}
+	return nil
+}
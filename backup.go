@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// "autoping backup [flags] <archive>" saves everything autoping keeps - the
+// log file, which holds the incident history and everything the baselines are
+// rebuilt from, the files of classes of log lines that have their own, the
+// event file, the config file, the targets file and the ring files - into a
+// single gzipped tar archive. "autoping restore [flags] <archive>" puts them
+// back, so an installation can be moved to new hardware without losing its
+// history. The files are found with the same -log, -c, -targets-file and
+// -ring flags autoping runs with. Restoring puts the config file back first,
+// and the other files where it and the flags say, never where the archive
+// says they came from, so an archive can't write anywhere else
+
+type manifest struct {
+	Created time.Time         `json:"created"`
+	Log     string            `json:"log"`               // Path of the log file
+	Logs    map[string]string `json:"logs,omitempty"`    // Paths of the files of classes of log lines, by class
+	Events  string            `json:"events,omitempty"`  // Path of the event file, if any
+	Config  string            `json:"config,omitempty"`  // Path of the config file, if any
+	Targets string            `json:"targets,omitempty"` // Path of the targets file, if any
+	Ring    string            `json:"ring,omitempty"`    // Directory of the ring files, if any
+}
+
+// Parse the flags and archive path of the backup and restore commands
+func parseArchiveArgs(command string, args []string) (string, error) {
+	if err := parseFlags(flag.CommandLine, args); err != nil {
+		return "", err
+	}
+	if flag.NArg() != 1 {
+		return "", fmt.Errorf("usage: autoping %s [flags] <archive.tar.gz>", command)
+	}
+	// When restoring, the config file is still in the archive
+	if command == "backup" {
+		if len(*configFlag) == 0 {
+			*configFlag = config.File()
+		}
+		cfg, err := loadConfig(*configFlag)
+		if err != nil {
+			return "", err
+		}
+		applyConfig(cfg, flag.CommandLine)
+		if err := checkLogFile(); err != nil {
+			return "", err
+		}
+	}
+	return flag.Arg(0), nil
+}
+
+// Returns an error if the log is written to a stream rather than a file
+func checkLogFile() error {
+	if logToStream(*logFlag) {
+		return errors.New("the log is written to " + *logFlag + ". Pass the file it is saved to with -log")
+	}
+	return nil
+}
+
+// Write the log, config and ring files to the archive given in the supplied
+// arguments
+func backupCommand(args []string) error {
+	archive, err := parseArchiveArgs("backup", args)
+	if err != nil {
+		return err
+	}
+	m := manifest{Created: time.Now(), Log: abs(*logFlag), Config: abs(*configFlag), Ring: abs(*ringFlag)}
+	files := map[string]string{"log": m.Log}
+	for class, l := range logRoutes {
+		if _, err := os.Stat(l.File); err != nil {
+			continue // Nothing logged to it yet
+		}
+		if m.Logs == nil {
+			m.Logs = make(map[string]string)
+		}
+		m.Logs[class] = abs(l.File)
+		files["log-"+class] = m.Logs[class]
+	}
+	if _, err := os.Stat(eventsFile.File); len(eventsFile.File) > 0 && err == nil {
+		m.Events = abs(eventsFile.File)
+		files["events"] = m.Events
+	}
+	if len(m.Config) > 0 {
+		files["config"] = m.Config
+	}
+	if _, err := os.Stat(*targetsFileFlag); len(*targetsFileFlag) > 0 && err == nil {
+		m.Targets = abs(*targetsFileFlag)
+		files["targets"] = m.Targets
+	}
+	if len(m.Ring) > 0 {
+		rings, _ := filepath.Glob(filepath.Join(m.Ring, "*.ring"))
+		for _, r := range rings {
+			files["ring/"+filepath.Base(r)] = r
+		}
+	}
+
+	out, err := os.OpenFile(archive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	data, _ := json.MarshalIndent(m, "", "  ")
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(data)),
+		ModTime: m.Created}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for name, file := range files {
+		if err := addToArchive(tw, name, file); err != nil {
+			return err
+		}
+		fmt.Println("Backed up", file)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Add the supplied file to the archive under the supplied name
+func addToArchive(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: int64(fi.Mode().Perm()), Size: fi.Size(),
+		ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	// The log may grow while it is copied, so only copy what was there to start
+	// with
+	_, err = io.CopyN(tw, f, fi.Size())
+	return err
+}
+
+// Put back the files in the archive given in the supplied arguments. The
+// config file goes where -c or AUTOPING_CONFIG say, and is read once it is
+// back. The other files go where it and -log, -targets-file and -ring say,
+// and those that have nowhere to go are skipped. Existing files are never
+// overwritten
+func restoreCommand(args []string) error {
+	archive, err := parseArchiveArgs("restore", args)
+	if err != nil {
+		return err
+	}
+	if len(*configFlag) == 0 {
+		*configFlag = config.File()
+	}
+
+	// The config file says where the other files go, so it goes back first
+	err = eachArchived(archive, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != "config" {
+			return nil
+		}
+		if len(*configFlag) == 0 {
+			fmt.Println("Skipped the config file. Pass -c to say where it goes")
+			return nil
+		}
+		return restoreFile(abs(*configFlag), hdr, r)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(*configFlag); len(*configFlag) > 0 && err == nil {
+		cfg, err := loadConfig(*configFlag)
+		if err != nil {
+			return err
+		}
+		applyConfig(cfg, flag.CommandLine)
+	}
+	if err := checkLogFile(); err != nil {
+		return err
+	}
+
+	return eachArchived(archive, func(hdr *tar.Header, r io.Reader) error {
+		var dest, unset string
+		switch {
+		case hdr.Name == "config":
+			return nil
+		case hdr.Name == "log":
+			dest = *logFlag
+		case strings.HasPrefix(hdr.Name, "log-"):
+			class := strings.TrimPrefix(hdr.Name, "log-")
+			dest, unset = logRoutes[class].File, "the config file has no file for "+class+" lines"
+		case hdr.Name == "events":
+			dest, unset = eventsFile.File, "the config file has no event file"
+		case hdr.Name == "targets":
+			dest, unset = *targetsFileFlag, "pass -targets-file to say where it goes"
+		case strings.HasPrefix(hdr.Name, "ring/"):
+			name := strings.TrimPrefix(hdr.Name, "ring/")
+			if name != filepath.Base(name) || !strings.HasSuffix(name, ".ring") || name == ".ring" {
+				return fmt.Errorf("%s in %s isn't a ring file", hdr.Name, archive)
+			}
+			dest, unset = *ringFlag, "pass -ring to say where ring files go"
+			if len(dest) > 0 {
+				dest = filepath.Join(dest, name)
+			}
+		default:
+			return nil
+		}
+		if len(dest) == 0 {
+			fmt.Printf("Skipped %s. %s\n", hdr.Name, strings.ToUpper(unset[:1])+unset[1:])
+			return nil
+		}
+		return restoreFile(abs(dest), hdr, r)
+	})
+}
+
+// Call fn with each file in the supplied archive other than its manifest, in
+// the order they were archived. Returns an error if the archive doesn't start
+// with a manifest
+func eachArchived(archive string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	var m manifest
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if first {
+			if hdr.Name != "manifest.json" {
+				return errors.New(archive + " doesn't start with a manifest. Is it an autoping backup?")
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &m); err != nil {
+				return fmt.Errorf("reading manifest: %v", err)
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%s in %s isn't a file", hdr.Name, archive)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Write the file of the supplied archive header, read from r, to the
+// supplied path, unless a file is there already
+func restoreFile(dest string, hdr *tar.Header, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(hdr.Mode).Perm())
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists. Move it out of the way to restore it", dest)
+	} else if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Restored", dest)
+	return nil
+}
+
+// Returns the absolute form of the supplied path, or an empty path unchanged
+func abs(p string) string {
+	if len(p) == 0 {
+		return p
+	}
+	if a, err := filepath.Abs(p); err == nil {
+		return a
+	}
+	return p
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Set the supplied flags, and the settings and log files read from the config
+// file, back as they were once the test is done
+func resetFlags(t *testing.T, names ...string) {
+	s := currentSettings()
+	t.Cleanup(func() {
+		useSettings(s)
+		for _, name := range names {
+			f := flag.Lookup(name)
+			f.Value.Set(f.DefValue)
+		}
+		logRoutes, eventsFile.File = nil, ""
+	})
+}
+
+// A backup restored on another machine puts the targets file back with the
+// rest, and every file goes where the flags and restored config file say
+func TestBackupRestore(t *testing.T) {
+	resetFlags(t, "log", "c", "ring", "targets-file")
+	old, dir := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"autoping.log":  "PING - 2018/06/01 12:00:00 [192.0.2.1] 64 bytes from 192.0.2.1: icmp_seq=1 time=10ms\n",
+		"autoping.yaml": "targets: [192.0.2.1]\n",
+		"targets.txt":   "192.0.2.2 interval=5s\n",
+	}
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(old, name), []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(t.TempDir(), "autoping.tar.gz")
+	if err := backupCommand([]string{"-log", filepath.Join(old, "autoping.log"), "-c", filepath.Join(old, "autoping.yaml"),
+		"-targets-file", filepath.Join(old, "targets.txt"), archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	*logFlag, *configFlag, *targetsFileFlag = defaultLogPath, "", ""
+	if err := restoreCommand([]string{"-log", filepath.Join(dir, "autoping.log"), "-c", filepath.Join(dir, "autoping.yaml"),
+		"-targets-file", filepath.Join(dir, "targets.txt"), archive}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		if got, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("restored %s as %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+// The paths in an archive's manifest and the names of its files can't make a
+// restore write anywhere the flags don't say
+func TestRestoreDestinations(t *testing.T) {
+	resetFlags(t, "log", "c", "ring", "targets-file")
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "outside")
+	write := func(name string, entries map[string]string) string {
+		path := filepath.Join(t.TempDir(), name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		m, _ := json.Marshal(manifest{Log: outside, Config: outside, Ring: outside, Targets: outside})
+		for _, e := range append([][2]string{{"manifest.json", string(m)}}, sortedEntries(entries)...) {
+			tw.WriteHeader(&tar.Header{Name: e[0], Mode: 0600, Size: int64(len(e[1])), Typeflag: tar.TypeReg})
+			tw.Write([]byte(e[1]))
+		}
+		tw.Close()
+		gz.Close()
+		f.Close()
+		return path
+	}
+
+	archive := write("paths.tar.gz", map[string]string{"log": "log\n", "targets": "192.0.2.2\n", "ring/a.ring": "ring"})
+	if err := restoreCommand([]string{"-log", filepath.Join(dir, "autoping.log"), archive}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "autoping.log")); err != nil {
+		t.Errorf("log wasn't restored where -log says: %v", err)
+	}
+	if matches, _ := filepath.Glob(outside + "*"); len(matches) > 0 {
+		t.Errorf("restored where the manifest says: %v", matches)
+	}
+
+	archive = write("escape.tar.gz", map[string]string{"ring/../../escape.ring": "ring"})
+	*logFlag = filepath.Join(dir, "other.log")
+	err := restoreCommand([]string{"-ring", dir, archive})
+	if err == nil || !strings.Contains(err.Error(), "isn't a ring file") {
+		t.Errorf("restoring a ring file named to escape the directory gave %v", err)
+	}
+}
+
+// Returns the supplied archive entries, sorted by name
+func sortedEntries(entries map[string]string) [][2]string {
+	var sorted [][2]string
+	for name, text := range entries {
+		sorted = append(sorted, [2]string{name, text})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	return sorted
+}
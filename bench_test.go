@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ping "github.com/go-ping/ping"
+)
+
+// The benchmarks push made-up ping results through the same code as real
+// ones: each pong is logged, outages, blips and flakey latency are detected
+// and raised, the history behind the status page is kept, and the log is read
+// back as on startup and by reports. TestResultBudget fails if they take
+// longer or allocate more a result than the budgets recorded here, so a
+// change that slows the core loop down or makes garbage is caught before it
+// ships. The time is the budget of a Raspberry Pi 4, which a faster machine is
+// well inside, so it is only checked closely by running the tests on one.
+// Allocations are the same on every machine
+
+const (
+	resultBudget = time.Minute / 10000 // Time a result may take, read back included: 10000 a minute on a Raspberry Pi 4
+	resultAllocs = 1.0                 // Allocations a result may take, mostly for outages and flakey latency
+	pongAllocs   = 0.01                // Allocations a pong may take, which are none but the odd slice growing
+)
+
+// Made-up results of a number of targets. Every target has an RTT of its own
+// that wobbles, with now and then a slow pong or a missed one, and every 1000
+// pings a run of missed ones that may or may not last long enough to be an
+// outage
+type benchWalk struct {
+	r     *rand.Rand
+	tgs   []*target
+	ips   []*net.IPAddr
+	down  []int // Pongs each target has still to miss
+	start time.Time
+	n     int             // Results so far
+	stats ping.Statistics // Reused, so the walk doesn't allocate
+	pkt   ping.Packet
+	rtts  [1]time.Duration
+}
+
+// Set up the supplied number of targets to push made-up results through,
+// logging to a file of their own, so writing it costs what it does for real.
+// Returns the walk through their results and the path of the log file
+func newBenchWalk(tb testing.TB, ntargets int) (*benchWalk, string) {
+	*configFlag = filepath.Join(tb.TempDir(), "none.yaml")
+	if err := ioutil.WriteFile(*configFlag, nil, 0600); err != nil {
+		tb.Fatal(err)
+	}
+	if err := configure(flag.NewFlagSet("bench", flag.ContinueOnError)); err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(tb.TempDir(), "autoping.log")
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	loggers := []*log.Logger{pLog, eLog, oLog, tLog, aLog}
+	tb.Cleanup(func() {
+		pLog, eLog, oLog, tLog, aLog = loggers[0], loggers[1], loggers[2], loggers[3], loggers[4]
+		setTargets(nil)
+		*configFlag = ""
+		f.Close()
+	})
+	pLog = newLogger(f, "PING - ")
+	eLog = newLogger(f, "ERROR - ")
+	oLog = newLogger(f, "OUTAGE - ")
+	tLog = newLogger(ioutil.Discard, "TRACE - ")
+	aLog = newLogger(f, "ACCESS - ")
+
+	var addrs []string
+	for i := 0; i < ntargets; i++ {
+		addrs = append(addrs, fmt.Sprintf("192.0.2.%d", i+1))
+	}
+	setTargets(addrs)
+	w := &benchWalk{r: rand.New(rand.NewSource(1)), tgs: currentTargets(), start: time.Now()}
+	w.down = make([]int, len(w.tgs))
+	for _, tg := range w.tgs {
+		w.ips = append(w.ips, &net.IPAddr{IP: net.ParseIP(tg.addr)})
+	}
+	return w, path
+}
+
+// Method to push the next result through, missing pongs if miss is true.
+// Returns the time its ping was fired
+func (w *benchWalk) next(miss bool) time.Time {
+	j := w.n % len(w.tgs)
+	tg, ip := w.tgs[j], w.ips[j]
+	k := w.n / len(w.tgs)
+	w.n++
+	t := w.start.Add(time.Duration(k) * currentSettings().interval)
+	if miss && k%1000 == 500 {
+		w.down[j] = 1 + w.r.Intn(300)
+	}
+	base := time.Duration(10+j*5) * time.Millisecond
+	rtt := base + time.Duration(w.r.Int63n(int64(base/5)))
+	x := w.r.Intn(100)
+	if !miss {
+		x = 99
+	}
+	switch {
+	case w.down[j] > 0, x == 0:
+		if w.down[j] > 0 {
+			w.down[j]--
+		}
+		w.stats = ping.Statistics{PacketsSent: 1, PacketLoss: 100}
+		tg.cycleFinished(t, &w.stats)
+		return t
+	case x < 4:
+		rtt *= 4
+	}
+	w.pkt = ping.Packet{Rtt: rtt, IPAddr: ip, Nbytes: 64, Seq: k}
+	tg.pongReceived(&w.pkt)
+	w.rtts[0] = rtt
+	w.stats = ping.Statistics{PacketsSent: 1, PacketsRecv: 1, IPAddr: ip, Addr: tg.addr, Rtts: w.rtts[:],
+		MinRtt: rtt, MaxRtt: rtt, AvgRtt: rtt}
+	tg.cycleFinished(t, &w.stats)
+	return t
+}
+
+// Push the supplied number of made-up results of 10 targets through, and
+// close whatever is still going, as at shutdown
+func (w *benchWalk) run(n int) {
+	var t time.Time
+	for i := 0; i < n; i++ {
+		t = w.next(true)
+	}
+	for _, tg := range w.tgs {
+		tg.closeIncidents(t, "at shutdown")
+	}
+}
+
+// Time results of 10 targets from the ping to the history behind the status
+// page
+func BenchmarkResults(b *testing.B) {
+	w, _ := newBenchWalk(b, 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	w.run(b.N)
+}
+
+// Time reading back the log lines of results of 10 targets, as on startup and
+// by reports
+func BenchmarkReadLog(b *testing.B) {
+	w, path := newBenchWalk(b, 10)
+	w.run(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	lines := 0
+	if err := scanLog(path, func(string, string, time.Time, string) { lines++ }); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(lines)/float64(b.N), "lines/op")
+}
+
+// Time pongs of 10 targets that say nothing new, which most results are
+func BenchmarkPong(b *testing.B) {
+	w, _ := newBenchWalk(b, 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.next(false)
+	}
+}
+
+func TestResultBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the benchmarks")
+	}
+	if raceEnabled {
+		t.Skip("the race detector slows the benchmarks down and allocates")
+	}
+	results, read, pongs := testing.Benchmark(BenchmarkResults), testing.Benchmark(BenchmarkReadLog),
+		testing.Benchmark(BenchmarkPong)
+	if results.N == 0 || read.N == 0 || pongs.N == 0 {
+		t.Fatal("a benchmark failed")
+	}
+	t.Logf("results: %v %v", results, results.MemString())
+	t.Logf("read back: %v %v", read, read.MemString())
+	t.Logf("pongs: %v %v", pongs, pongs.MemString())
+	if took := time.Duration(results.NsPerOp() + read.NsPerOp()); took > resultBudget {
+		t.Errorf("%v a result, over the budget of %v", took, resultBudget)
+	}
+	if allocs := float64(results.MemAllocs) / float64(results.N); allocs > resultAllocs {
+		t.Errorf("%.2f allocations a result, over the budget of %g", allocs, resultAllocs)
+	}
+	if allocs := float64(pongs.MemAllocs) / float64(pongs.N); allocs > pongAllocs {
+		t.Errorf("%.3f allocations a pong, over the budget of %g", allocs, pongAllocs)
+	}
+}
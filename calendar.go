@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Outages and periods of flakey latency can be exported as iCalendar events,
+// either live from the status page at /calendar.ics, or from the log file with
+// the -e flag, so that downtime can be overlaid on a team calendar. Maintenance
+// windows go in as events of their own. Text is escaped and long lines folded
+// as RFC 5545 has it, so names and durations with commas or semicolons, and
+// descriptions with sparklines, read back as they were written
+
+const icsTime = "20060102T150405Z" // iCalendar UTC date-time format
+
+// Matches the line logged at the end of a period of flakey latency, or when
+// autoping stops or pauses during one. Older logs don't name the latency tier
+var flakeyLine = regexp.MustCompile(`^Period of (?:(\w+) )?flakey latency (?:finished|ongoing (?:at shutdown|when paused))\. Duration = (\S+)`)
+
+// Matches the line logged at the end of an outage, or when autoping stops or
+// pauses during one
+var outageEndLine = regexp.MustCompile(`^(?:Connection restored|Outage ongoing (?:at shutdown|when paused))\. Total outage duration (\S+)$`)
+
+type incident struct {
+	target string    // Target the incident happened to. Empty in old logs
+	kind   string    // "Outage" or "Flakey latency"
+	start  time.Time // Time the incident started
+	end    time.Time // Time the incident finished. Zero if still going
+}
+
+// Method to record a finished incident, dropping incidents that are too old
+// to be of interest
+func (h *pingHistory) addIncident(kind string, start, end time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.incidents = append(h.incidents, incident{kind: kind, start: start, end: end})
+	i := 0
+	for i < len(h.incidents) && end.Sub(h.incidents[i].end) > historyLength {
+		i++
+	}
+	h.incidents = h.incidents[i:]
+}
+
+// Method to return a copy of the recorded incidents
+func (h *pingHistory) allIncidents() []incident {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]incident(nil), h.incidents...)
+}
+
+// Escapes text for an iCalendar property value
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// Write the supplied incidents and maintenance windows as an iCalendar file.
+// Incidents that are still going finish now
+func writeCalendar(w io.Writer, incs []incident, windows []maintenanceWindow) error {
+	now := time.Now().UTC()
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//kurankat//autoping-go//EN",
+		"CALSCALE:GREGORIAN",
+	}
+	for _, inc := range incs {
+		end := inc.end
+		if end.IsZero() {
+			end = now
+		}
+		summary := fmt.Sprintf("%s (%s)", inc.kind, lc.duration(end.Sub(inc.start)))
+		if len(inc.target) > 0 {
+			summary = fmt.Sprintf("%s of %s (%s)", inc.kind, inc.target, lc.duration(end.Sub(inc.start)))
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+incidentID(inc)+"@autoping",
+			"DTSTAMP:"+now.Format(icsTime),
+			"DTSTART:"+inc.start.UTC().Format(icsTime),
+			"DTEND:"+end.UTC().Format(icsTime),
+			"SUMMARY:"+icsEscaper.Replace(summary))
+		if spark := incidentSparkline(inc); len(strings.TrimSpace(spark)) > 0 {
+			lines = append(lines, "DESCRIPTION:"+icsEscaper.Replace("RTT from an hour before to an hour after: "+spark))
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	for _, mw := range windows {
+		summary := "Maintenance"
+		if len(mw.window.Targets) > 0 {
+			summary += " of " + strings.Join(mw.window.Targets, ", ")
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+maintenanceID(mw)+"@autoping",
+			"DTSTAMP:"+now.Format(icsTime),
+			"DTSTART:"+mw.start.UTC().Format(icsTime),
+			"DTEND:"+mw.end.UTC().Format(icsTime),
+			"SUMMARY:"+icsEscaper.Replace(summary),
+			"DESCRIPTION:"+icsEscaper.Replace("Maintenance window "+mw.window.Schedule.String()+
+				" for "+lc.duration(time.Duration(mw.window.Duration))+
+				". Missed pongs, blips and outages in it aren't counted"),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	// iCalendar lines end with CRLF
+	for i := range lines {
+		lines[i] = foldLine(lines[i])
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\r\n")+"\r\n")
+	return err
+}
+
+// iCalendar lines can be at most 75 bytes long. Longer ones continue on the
+// next line after a space, without splitting a UTF-8 character
+func foldLine(line string) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if n+size > 75 {
+			sb.WriteString("\r\n ")
+			n = 1
+		}
+		sb.WriteRune(r)
+		n += size
+	}
+	return sb.String()
+}
+
+// Serve the recorded incidents and the maintenance windows from as far back
+// as the history goes to maintenanceAhead as an iCalendar file
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	now := time.Now()
+	only, _ := r.Context().Value(scopeKey{}).([]string)
+	windows := currentSettings().maintenanceWindows(now.Add(-historyLength), now.Add(maintenanceAhead), only)
+	if err := writeCalendar(w, allIncidents(requestTargets(r)), windows); err != nil {
+		eLog.Printf("Writing calendar: %v", err)
+	}
+}
+
+// Return an identifier for the incident that stays the same between runs
+func incidentID(inc incident) string {
+	id := incidentKey(inc)
+	if len(inc.target) > 0 {
+		id = inc.target + "-" + id
+	}
+	return id
+}
+
+// Return an identifier for the time the maintenance window is open that stays
+// the same between runs, as long as the window's schedule and targets do
+func maintenanceID(mw maintenanceWindow) string {
+	h := fnv.New32a()
+	io.WriteString(h, mw.window.Schedule.String()+" "+strings.Join(mw.window.Targets, ","))
+	return fmt.Sprintf("maintenance-%08x-%d", h.Sum32(), mw.start.Unix())
+}
+
+// Work out the incident finished by the supplied outage log message, logged at
+// the supplied time. The start is worked out from the logged duration. Returns
+// false if the message doesn't finish an incident
+func parseIncident(msg string, end time.Time) (incident, bool) {
+	var kind, dur string
+	switch {
+	case outageEndLine.MatchString(msg):
+		kind = "Outage"
+		dur = outageEndLine.FindStringSubmatch(msg)[1]
+	case flakeyLine.MatchString(msg):
+		m := flakeyLine.FindStringSubmatch(msg)
+		kind = "Flakey latency"
+		if len(m[1]) > 0 {
+			kind += " (" + m[1] + ")"
+		}
+		dur = m[2]
+	default:
+		return incident{}, false
+	}
+	d, err := time.ParseDuration(dur)
+	if err != nil {
+		return incident{}, false
+	}
+	return incident{kind: kind, start: end.Add(-d), end: end}, true
+}
+
+// Read the incidents logged in the supplied log file and write those between
+// the supplied times to w as an iCalendar file, with the maintenance windows
+// between them. A zero time leaves that end open, which takes windows back as
+// far as the history goes or forward to maintenanceAhead. If any targets are
+// supplied, only their incidents and windows are written
+func exportCalendar(w io.Writer, path string, from, to time.Time, only []string) error {
+	var incs []incident
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if prefix != "OUTAGE" || !inTargets(name, only) {
+			return
+		}
+		inc, ok := parseIncident(msg, t)
+		if ok && !inc.end.Before(from) && (to.IsZero() || inc.start.Before(to)) {
+			inc.target = name
+			incs = append(incs, inc)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if from.IsZero() {
+		from = now.Add(-historyLength)
+	}
+	if to.IsZero() {
+		to = now.Add(maintenanceAhead)
+	}
+	return writeCalendar(w, incs, currentSettings().maintenanceWindows(from, to, only))
+}
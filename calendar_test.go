@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Returns a maintenance window opening on the supplied cron schedule for the
+// supplied time
+func testWindow(t *testing.T, schedule string, d time.Duration, targets ...string) config.Maintenance {
+	var c config.Cron
+	if err := c.UnmarshalText([]byte(schedule)); err != nil {
+		t.Fatal(err)
+	}
+	return config.Maintenance{Schedule: c, Duration: config.Duration(d), Targets: targets}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	before := timeZone
+	t.Cleanup(func() { timeZone = before })
+	timeZone = time.UTC
+	s := &settings{maintenance: []config.Maintenance{
+		testWindow(t, "0 3 * * *", time.Hour, "192.0.2.1"),
+		testWindow(t, "* 12 * * *", 10*time.Minute), // Opens again every minute from 12:00 to 12:59
+	}}
+	from, to := time.Date(2018, 6, 2, 3, 30, 0, 0, time.UTC), time.Date(2018, 6, 4, 12, 30, 0, 0, time.UTC)
+
+	ws := s.maintenanceWindows(from, to, nil)
+	var got []string
+	for _, w := range ws {
+		got = append(got, w.start.Format("02 15:04")+"-"+w.end.Format("02 15:04"))
+	}
+	want := []string{"02 03:00-02 04:00", "03 03:00-03 04:00", "04 03:00-04 04:00",
+		"02 12:00-02 13:09", "03 12:00-03 13:09", "04 12:00-04 12:39"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("windows %v, not %v", got, want)
+	}
+	if ws := s.maintenanceWindows(from, to, []string{"192.0.2.2"}); len(ws) != 3 {
+		t.Errorf("%d windows of another target", len(ws))
+	}
+}
+
+// Every text property is escaped and every line folded, and maintenance
+// windows are events of their own
+func TestWriteCalendar(t *testing.T) {
+	start := time.Date(2018, 6, 2, 10, 0, 0, 0, time.UTC)
+	incs := []incident{{target: "sip:a,b;transport=udp@example.com", kind: "Outage", start: start,
+		end: start.Add(2 * time.Minute)}}
+	windows := []maintenanceWindow{{window: testWindow(t, "0 3 * * *", time.Hour, "192.0.2.1", "192.0.2.2"),
+		start: start, end: start.Add(time.Hour)}}
+	var b strings.Builder
+	if err := writeCalendar(&b, incs, windows); err != nil {
+		t.Fatal(err)
+	}
+	text := b.String()
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d bytes: %q", len(line), line)
+		}
+	}
+	unfolded := strings.ReplaceAll(text, "\r\n ", "")
+	for _, want := range []string{
+		`SUMMARY:Outage of sip:a\,b\;transport=udp@example.com (`,
+		"SUMMARY:Maintenance of 192.0.2.1\\, 192.0.2.2\r\n",
+		"DTSTART:20180602T100000Z\r\nDTEND:20180602T110000Z\r\n",
+		`DESCRIPTION:Maintenance window 0 3 * * * for `,
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("calendar without %q:\n%s", want, unfolded)
+		}
+	}
+	if n := strings.Count(text, "BEGIN:VEVENT"); n != 2 {
+		t.Errorf("%d events, not 2", n)
+	}
+}
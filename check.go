@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// With -check, autoping goes through everything it needs to start - the
+// config, the targets, the log, event and ring files, the key of the Google
+// service account and an ICMP socket - and exits without sending a single
+// ping, so a deployment pipeline can catch mistakes before the service is
+// enabled
+
+// Run every check, writing the outcome of each to stdout. Returns an error if
+// any of them failed
+func checkSetup() error {
+	failed, denied := 0, 0
+	report := func(what string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", what, err)
+			failed++
+			if exitStatus(err) == exitPermission {
+				denied++
+			}
+		} else {
+			fmt.Printf("ok   %s\n", what)
+		}
+	}
+
+	// The config has already been read by now, or autoping would have stopped
+	if len(*configFlag) > 0 {
+		report("config file "+*configFlag, nil)
+	}
+	for _, tg := range currentTargets() {
+		ip, err := resolveTarget(tg.addr)
+		if err == nil {
+			report(fmt.Sprintf("target %s resolves to %v", tg.addr, ip), nil)
+		} else {
+			report("target "+tg.addr, err)
+		}
+	}
+	if logToStream(*logFlag) {
+		report("log to "+*logFlag, nil)
+	} else {
+		report("log file "+*logFlag+" is writable", checkWritable(*logFlag))
+	}
+	for _, class := range config.LogClasses {
+		if l, ok := logRoutes[class]; ok {
+			report(class+" log file "+l.File+" is writable", checkWritable(l.File))
+		}
+	}
+	if len(eventsFile.File) > 0 {
+		report("event file "+eventsFile.File+" is writable", checkWritable(eventsFile.File))
+	}
+	if len(*ringFlag) > 0 {
+		report("ring directory "+*ringFlag+" is writable", checkWritableDir(*ringFlag))
+	}
+	sheetsMu.Lock()
+	gs := sheetsCfg
+	sheetsMu.Unlock()
+	if len(gs.SpreadsheetID) > 0 {
+		_, err := loadServiceAccount(gs.Credentials)
+		report("Google service account key "+gs.Credentials, err)
+	}
+	if privilegedPing() {
+		report("raw ICMP socket", checkICMP())
+	} else {
+		report("unprivileged ICMP socket", checkUnprivilegedICMP())
+	}
+
+	if denied > 0 {
+		return deniedError{fmt.Errorf("%d checks failed, %d of them for lack of permission", failed, denied)}
+	} else if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// Resolve the supplied target the way it will be pinged, or the host its
+// prober sends its probes to
+func resolveTarget(addr string) (*net.IPAddr, error) {
+	if p := proberFor(addr); p != nil {
+		host, err := p.host(currentSettings(), addr)
+		if err != nil {
+			return nil, err
+		}
+		return net.ResolveIPAddr("ip", host)
+	}
+	if *ipv6Flag {
+		return resolveIPv6(addr)
+	}
+	return net.ResolveIPAddr("ip", addr)
+}
+
+// Returns an error if the supplied file can't be appended to, or created if it
+// doesn't exist yet. Nothing is written to it
+func checkWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return checkWritableDir(filepath.Dir(path))
+}
+
+// Returns an error if files can't be created in the supplied directory
+func checkWritableDir(dir string) error {
+	f, err := ioutil.TempFile(dir, ".autoping-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Returns an error if a raw ICMP socket can't be opened, for lack of
+// privileges or otherwise
+func checkICMP() error {
+	network, addr := "ip4:icmp", "0.0.0.0"
+	if *ipv6Flag {
+		network, addr = "ip6:ipv6-icmp", "::"
+	}
+	c, err := net.ListenPacket(network, addr)
+	if err != nil {
+		if os.IsPermission(err) {
+			return errors.New("permission denied, run autoping as root")
+		}
+		return err
+	}
+	return c.Close()
+}
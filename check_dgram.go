@@ -0,0 +1,46 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Returns an error if an unprivileged ICMP socket, as used with -unprivileged
+// or when autoping isn't root on macOS, can't be opened
+func checkUnprivilegedICMP() error {
+	domain := syscall.AF_INET
+	proto := syscall.IPPROTO_ICMP
+	if *ipv6Flag {
+		domain, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return pingGroupError(err)
+	}
+	return syscall.Close(fd)
+}
+
+// Returns the supplied error of opening an unprivileged ICMP socket, saying
+// how to allow them if Linux refused it. Linux only lets processes in one of
+// the groups in net.ipv4.ping_group_range open them, and none by default
+func pingGroupError(err error) error {
+	if runtime.GOOS != "linux" || !errors.Is(err, os.ErrPermission) && !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+	const fix = `Allow them with "sysctl -w net.ipv4.ping_group_range='0 2147483647'", ` +
+		`or --sysctl net.ipv4.ping_group_range='0 2147483647' for a container`
+	data, rerr := ioutil.ReadFile("/proc/sys/net/ipv4/ping_group_range")
+	if rerr != nil {
+		return fmt.Errorf("%w. %s", err, fix)
+	}
+	return fmt.Errorf("%w. Group %d isn't in net.ipv4.ping_group_range, which is %s. %s", err, os.Getegid(),
+		strings.Join(strings.Fields(string(data)), " "), fix)
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import (
+	"errors"
+)
+
+// Unprivileged ICMP sockets are only used on Linux and macOS
+func checkUnprivilegedICMP() error {
+	return errors.New("unprivileged ICMP sockets are only used on Linux and macOS")
+}
@@ -0,0 +1,171 @@
+// Package client is a Go client for the JSON API served alongside the autoping
+// status page. The API is described by the OpenAPI document served at
+// /api/openapi.json
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Status is the current state of a monitored target
+type Status struct {
+	Target             string    `json:"target"`
+	State              string    `json:"state"` // "Up", "Outage", "Degraded latency" or "Paused"
+	Availability24h    float64   `json:"availability_24h"`
+	Availability30d    float64   `json:"availability_30d"`
+	LastSuccessfulPing time.Time `json:"last_successful_ping"`
+	MonitoringSince    time.Time `json:"monitoring_since"`
+	Blips24h           int       `json:"blips_24h"`     // Missed pings too short to be outages
+	BlipsByHour        []int     `json:"blips_by_hour"` // Blips in the last 30 days by hour of day
+
+	// Failed pings since startup by category, with every one of
+	// FailureCategories present
+	Failures map[string]int `json:"failures"`
+
+	Labels map[string]string `json:"labels,omitempty"` // Labels set in the config file, e.g. site=office
+}
+
+// Categories failed pings are counted in, the keys of Status.Failures
+const (
+	FailDNS         = "dns_failure"
+	FailSocket      = "socket_error"
+	FailPermission  = "permission_denied"
+	FailTimeout     = "timeout"
+	FailUnreachable = "unreachable"
+	FailHTTP        = "http_error"
+	FailMail        = "mail_error"
+	FailUDP         = "udp_error"
+	FailSIP         = "sip_error"
+	FailTLS         = "tls_error"
+	FailQUIC        = "quic_error"
+)
+
+// FailureCategory is a category failed pings are counted in
+type FailureCategory struct {
+	Name        string // Key in Status.Failures
+	Description string // What failed pings of the category have in common
+}
+
+// FailureCategories lists every category failed pings are counted in, in the
+// order they are reported. The server counts and the OpenAPI document
+// describes exactly these
+var FailureCategories = []FailureCategory{
+	{FailDNS, "Target's name couldn't be resolved"},
+	{FailSocket, "Something else went wrong locally"},
+	{FailPermission, "Not allowed to open an ICMP socket"},
+	{FailTimeout, "No pong came back in time"},
+	{FailUnreachable, "No route to the target's network"},
+	{FailHTTP, "Web service answered with an error or refused to connect"},
+	{FailMail, "Mail server turned a mail probe or the login down"},
+	{FailUDP, "UDP service gave the wrong answer or refused the datagram"},
+	{FailSIP, "SIP service answered with a server error or refused the request"},
+	{FailTLS, "TLS handshake failed, as with an expired certificate, or was refused"},
+	{FailQUIC, "QUIC server gave an answer that isn't version negotiation, or refused"},
+}
+
+// Incident is an outage or a period of flakey latency
+type Incident struct {
+	Target   string     `json:"target"`
+	Kind     string     `json:"kind"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end"` // Nil if the incident is still going
+	Duration float64    `json:"duration_seconds"`
+}
+
+// Sample is the result of a single recent ping, as kept in the target's ring
+// file
+type Sample struct {
+	Time time.Time `json:"time"`
+	RTT  float64   `json:"rtt_ms"` // 0 if the pong was lost
+	Lost bool      `json:"lost"`
+}
+
+// Client talks to the API of a single autoping instance
+type Client struct {
+	BaseURL    string       // Address of the status page, e.g. http://host:8080
+	HTTPClient *http.Client // Client used for requests. http.DefaultClient if nil
+	Token      string       // Tenant or operator token, if the instance has tenants
+}
+
+// New returns a client for the autoping instance serving its status page at
+// the supplied base URL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Status fetches the current state of the first target
+func (c *Client) Status() (*Status, error) {
+	var s Status
+	if err := c.get("/api/status", &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// TargetStatus fetches the current state of the supplied target
+func (c *Client) TargetStatus(target string) (*Status, error) {
+	var s Status
+	if err := c.get("/api/status?target="+url.QueryEscape(target), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Targets fetches the current state of every target
+func (c *Client) Targets() ([]Status, error) {
+	var ss []Status
+	if err := c.get("/api/targets", &ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Incidents fetches the recorded incidents of every target, oldest first
+func (c *Client) Incidents() ([]Incident, error) {
+	var incs []Incident
+	if err := c.get("/api/incidents", &incs); err != nil {
+		return nil, err
+	}
+	return incs, nil
+}
+
+// Samples fetches the pings of the supplied target fired at or after the
+// supplied time, oldest first, from the last 24 hours the instance keeps with
+// -ring
+func (c *Client) Samples(target string, since time.Time) ([]Sample, error) {
+	var samples []Sample
+	q := url.Values{"target": {target}, "since": {since.Format(time.RFC3339)}}
+	if err := c.get("/api/samples?"+q.Encode(), &samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// Fetch the supplied path and decode the JSON response into v
+func (c *Client) get(path string, v interface{}) error {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("autoping: %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// Outages, blips and flakey latency are timed between readings of
+// time.Now(), which carry the monotonic clock as well as the wall clock, so
+// their durations stay right when NTP steps the wall clock or the clocks go
+// forward for daylight saving. Times read back from the log only have the wall
+// clock, though, so a step leaves a gap or an overlap in the log that looks
+// like something happened. Each tick compares how far the two clocks moved
+// since the one before, and when they disagree by clockJump or more, the jump
+// is logged, and digests list it apart from the outages. A machine that
+// slept looks the same, since the monotonic clock stops while it sleeps
+
+const clockJump = 2 * time.Second // Smallest difference between the clocks logged as a jump
+
+var clockLine = regexp.MustCompile(`^Clock jumped (forward|back) (\S+)$`)
+
+var lastTick time.Time // Time of the last tick, with its monotonic reading
+
+// Log a jump of the wall clock since the last tick, if there was one. Called on
+// every tick of the main loop
+func checkClock(now time.Time) {
+	last := lastTick
+	lastTick = now
+	if last.IsZero() {
+		return
+	}
+
+	// Round(0) drops the monotonic reading, leaving the wall clock
+	jump := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+	switch {
+	case jump >= clockJump:
+		pLog.Printf("Clock jumped forward %v", jump.Round(time.Second))
+	case jump <= -clockJump:
+		pLog.Printf("Clock jumped back %v", -jump.Round(time.Second))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// On a cloud VM, packet loss and RTT can be published as custom metrics to
+// AWS CloudWatch or Azure Monitor, set under cloudwatch and azure_monitor in
+// the config file, so they show up in the cloud's own dashboards and alarms.
+// Every minute, each target's pings of that minute are summed up as its
+// packet loss and the minimum, maximum, sum and count of its RTTs, and
+// published with the target as a dimension. Credentials come from the VM's
+// identity, so there is nothing else to set up. A minute that can't be
+// published is logged and dropped
+
+const (
+	cloudPeriod = time.Minute              // Time each published value covers
+	credsMinTTL = 5 * time.Minute          // Time before they expire that credentials are fetched again
+	imdsURL     = "http://169.254.169.254" // Instance metadata service of AWS and Azure
+)
+
+// Pings of one target during a period
+type cloudStats struct {
+	addr          string
+	sent, recv    int
+	min, max, sum time.Duration // Of the RTTs of the pongs
+}
+
+var (
+	cloudMu      sync.Mutex
+	cloudWatch   config.CloudWatch      // Where to publish in AWS. No region if not set
+	azureMonitor config.AzureMonitor    // Where to publish in Azure. No region if not set
+	cloudTotals  map[string]*cloudStats // Pings of the period so far, by target
+)
+
+var cloudClient = &http.Client{Timeout: 30 * time.Second}
+
+// Publish metrics where the supplied settings say from now on
+func setClouds(cfg *config.Config) {
+	cloudMu.Lock()
+	defer cloudMu.Unlock()
+	cloudWatch, azureMonitor = cfg.CloudWatch, cfg.AzureMonitor
+}
+
+// Method to add the result of a ping to the target's totals of the period, if
+// metrics are published anywhere
+func (tg *target) addCloudStats(ok bool, rtt time.Duration) {
+	cloudMu.Lock()
+	defer cloudMu.Unlock()
+	if len(cloudWatch.Region) == 0 && len(azureMonitor.Region) == 0 {
+		return
+	}
+	if cloudTotals == nil {
+		cloudTotals = make(map[string]*cloudStats)
+	}
+	st := cloudTotals[tg.addr]
+	if st == nil {
+		st = &cloudStats{addr: tg.addr}
+		cloudTotals[tg.addr] = st
+	}
+	st.sent++
+	if !ok {
+		return
+	}
+	if st.recv == 0 || rtt < st.min {
+		st.min = rtt
+	}
+	if rtt > st.max {
+		st.max = rtt
+	}
+	st.recv++
+	st.sum += rtt
+}
+
+// Method to return the share of the period's pings that were lost, in percent
+func (st cloudStats) loss() float64 {
+	if st.sent == 0 {
+		return 0
+	}
+	return 100 * float64(st.sent-st.recv) / float64(st.sent)
+}
+
+// Publish each period's metrics as it ends, until the supplied context is done
+func publishClouds(ctx context.Context) {
+	defer crashed("cloud metrics")
+	start := time.Now().Truncate(cloudPeriod)
+	for {
+		if !sleepCtx(ctx, time.Until(start.Add(cloudPeriod))) {
+			return
+		}
+		publishPeriod(start)
+		start = start.Add(cloudPeriod)
+	}
+}
+
+// Publish the totals of the period that started at the supplied time, and
+// start the next period afresh
+func publishPeriod(start time.Time) {
+	cloudMu.Lock()
+	cw, az, totals := cloudWatch, azureMonitor, cloudTotals
+	cloudTotals = nil
+	cloudMu.Unlock()
+	if len(totals) == 0 {
+		return
+	}
+	var stats []cloudStats
+	for _, st := range totals {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].addr < stats[j].addr })
+
+	if len(cw.Region) > 0 {
+		if err := putCloudWatch(cw, start, stats); err != nil {
+			eLog.Printf("Publishing metrics to CloudWatch: %v", err)
+		}
+	}
+	if len(az.Region) > 0 {
+		if err := putAzureMonitor(az, start, stats); err != nil {
+			eLog.Printf("Publishing metrics to Azure Monitor: %v", err)
+		}
+	}
+}
+
+// Returns the supplied duration in milliseconds
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Returns the body of the answer to the supplied request to the instance
+// metadata service
+func imdsGet(req *http.Request) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s from %s", resp.Status, req.URL.Path)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Metrics go to CloudWatch through its query API, signed with AWS Signature
+// Version 4. Credentials come from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables if set, and otherwise from the
+// role of the EC2 instance, through the instance metadata service
+
+const (
+	cwNamespace = "autoping" // Namespace unless set
+	cwBatch     = 20         // Metrics sent in one request
+)
+
+type awsCreds struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+var (
+	awsCredsMu sync.Mutex
+	awsCached  awsCreds // Credentials of the instance's role, once fetched
+)
+
+// Publish the packet loss and RTT of the supplied targets over the period
+// that started at the supplied time to CloudWatch
+func putCloudWatch(cw config.CloudWatch, start time.Time, stats []cloudStats) error {
+	creds, err := awsCredentials()
+	if err != nil {
+		return fmt.Errorf("getting AWS credentials: %v", err)
+	}
+	namespace := cw.Namespace
+	if len(namespace) == 0 {
+		namespace = cwNamespace
+	}
+
+	// Each target has a PacketLoss and, if any pongs came back, an RTT metric
+	var metrics []url.Values
+	for _, st := range stats {
+		loss := url.Values{}
+		loss.Set("MetricName", "PacketLoss")
+		loss.Set("Unit", "Percent")
+		loss.Set("Value", strconv.FormatFloat(st.loss(), 'f', -1, 64))
+		tm := []url.Values{loss}
+		if st.recv > 0 {
+			rtt := url.Values{}
+			rtt.Set("MetricName", "RTT")
+			rtt.Set("Unit", "Milliseconds")
+			rtt.Set("StatisticValues.SampleCount", strconv.Itoa(st.recv))
+			rtt.Set("StatisticValues.Sum", cwMillis(st.sum))
+			rtt.Set("StatisticValues.Minimum", cwMillis(st.min))
+			rtt.Set("StatisticValues.Maximum", cwMillis(st.max))
+			tm = append(tm, rtt)
+		}
+		for _, m := range tm {
+			m.Set("Dimensions.member.1.Name", "Target")
+			m.Set("Dimensions.member.1.Value", st.addr)
+			m.Set("Timestamp", start.UTC().Format(time.RFC3339))
+		}
+		metrics = append(metrics, tm...)
+	}
+
+	for len(metrics) > 0 {
+		n := len(metrics)
+		if n > cwBatch {
+			n = cwBatch
+		}
+		form := url.Values{}
+		form.Set("Action", "PutMetricData")
+		form.Set("Version", "2010-08-01")
+		form.Set("Namespace", namespace)
+		for i, m := range metrics[:n] {
+			for k, v := range m {
+				form.Set(fmt.Sprintf("MetricData.member.%d.%s", i+1, k), v[0])
+			}
+		}
+		if err := postCloudWatch(cw.Region, creds, form.Encode()); err != nil {
+			return err
+		}
+		metrics = metrics[n:]
+	}
+	return nil
+}
+
+// Returns the supplied duration in milliseconds, as CloudWatch takes it
+func cwMillis(d time.Duration) string {
+	return strconv.FormatFloat(ms(d), 'f', -1, 64)
+}
+
+// Send the supplied form to the CloudWatch API of the supplied region, signed
+// with the supplied credentials
+func postCloudWatch(region string, creds awsCreds, body string) error {
+	host := "monitoring." + region + ".amazonaws.com"
+	req, err := http.NewRequest("POST", "https://"+host+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWS(req, creds, region, "monitoring", body, time.Now())
+
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Sign the supplied request, whose body is the supplied string, for the
+// supplied region and service with AWS Signature Version 4
+func signAWS(req *http.Request, creds awsCreds, region, service, body string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(creds.Token) > 0 {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	// Every header set so far is signed, along with the host
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		names = append(names, lower)
+		values[lower] = strings.TrimSpace(req.Header.Get(name))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonHeaders.String(), signed, sha256Hex(body)}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonical)
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// Returns the SHA-256 of the supplied string in hex
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the HMAC-SHA256 of the supplied data with the supplied key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Returns the AWS credentials from the environment, or from the instance's
+// role if there are none there. Those of the role are kept until shortly
+// before they expire
+func awsCredentials() (awsCreds, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); len(id) > 0 {
+		return awsCreds{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	awsCredsMu.Lock()
+	defer awsCredsMu.Unlock()
+	if time.Until(awsCached.Expiration) > credsMinTTL {
+		return awsCached, nil
+	}
+
+	// IMDSv2 wants a session token first
+	req, _ := http.NewRequest("PUT", imdsURL+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := imdsGet(req)
+	if err != nil {
+		return awsCreds{}, fmt.Errorf("no AWS_ACCESS_KEY_ID and no instance metadata service: %v", err)
+	}
+	path := imdsURL + "/latest/meta-data/iam/security-credentials/"
+	req, _ = http.NewRequest("GET", path, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := imdsGet(req)
+	if err != nil {
+		return awsCreds{}, fmt.Errorf("the instance has no role: %v", err)
+	}
+	req, _ = http.NewRequest("GET", path+strings.TrimSpace(strings.SplitN(role, "\n", 2)[0]), nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	data, err := imdsGet(req)
+	if err != nil {
+		return awsCreds{}, err
+	}
+	var creds awsCreds
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return awsCreds{}, fmt.Errorf("reading credentials of the instance's role: %v", err)
+	}
+	awsCached = creds
+	return creds, nil
+}
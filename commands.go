@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Autoping's jobs are split into subcommands, each with its own flags:
+// "autoping run" pings, "autoping report" summarises the log file,
+// "autoping validate-config" checks a config file and "autoping version"
+// says which build this is. Without a subcommand autoping runs, so the
+// command lines of existing installations keep working
+
+// Version of this build, set with -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// Subcommands other than run, which is what main does
+var commands = map[string]struct {
+	run   func([]string) error
+	usage string
+}{
+	"report":          {reportCommand, "summarise the log file as CSV, a text digest, iCalendar or an analysis of lost pings"},
+	"validate-config": {validateCommand, "check a config file and exit"},
+	"version":         {versionCommand, "print the version and exit"},
+	"service":         {serviceCommand, "install or uninstall autoping as a system service"},
+	"backup":          {backupCommand, "save the log, config and ring files to an archive"},
+	"restore":         {restoreCommand, "put the files saved by backup back"},
+	"incidents":       {incidentsCommand, "browse, acknowledge and annotate the incidents in the log file"},
+	"stop":            {stopCommand, "tell the autoping running with -pidfile to shut down"},
+	"pause":           {pauseCommand, "tell the autoping running with -pidfile to stop pinging for now"},
+	"resume":          {resumeCommand, "tell the autoping running with -pidfile to ping again"},
+	"export":          {exportCommand, "write the pings and incidents in the log file to Parquet files for data analysis"},
+	"init":            {initCommand, "ask for the targets, interval, log file and email digest and write a config file"},
+	"analytics":       {analyticsCommand, "print availability by week, latency by hour or outage durations as tables or CSV"},
+	"status":          {statusCommand, "print how each target has done lately from the ring files of -ring"},
+}
+
+// Write the usage of autoping and its subcommands to stderr
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: autoping [run] [flags]")
+	fmt.Fprintln(out, "       autoping <command> [flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	fmt.Fprintf(out, "  %-16s %s\n", "run", "ping the targets, also what autoping does without a command")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(out, "\nFlags of run:")
+	flag.PrintDefaults()
+}
+
+// Returns a flag set for the supplied subcommand holding the supplied flags of
+// run, so they can be given to the subcommand too
+func subcommandFlags(command string, shared ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet("autoping "+command, flag.ContinueOnError)
+	for _, name := range shared {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	return fs
+}
+
+// Pick the locale and profile, apply the environment and the config file and
+// check the ping timings. Flags given in the supplied flag set win over both
+func configure(fs *flag.FlagSet) error {
+	l, ok := locales[*localeFlag]
+	if !ok {
+		return fmt.Errorf("unknown locale '%s'. Use one of %s", *localeFlag, localeNames())
+	}
+	lc = l
+	if _, err := currentPreset(); err != nil {
+		return err
+	}
+
+	// AUTOPING_CONFIG names the config file when -c isn't given
+	if len(*configFlag) == 0 {
+		*configFlag = config.File()
+	}
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	if err := setTimezone(cfg); err != nil {
+		return err
+	}
+	applyConfig(cfg, fs)
+	s, err := loadTargetsFile(currentSettings())
+	if err != nil {
+		return fmt.Errorf("reading targets file: %w", err)
+	}
+	useSettings(s)
+	setReports(cfg)
+	setRemoteWrite(cfg)
+	setClouds(cfg)
+	setSheets(cfg)
+	setCrash(cfg)
+	setExperiments(cfg)
+
+	// Pings to the same target mustn't overlap
+	if err := s.check(); err != nil {
+		return fmt.Errorf("ping timing: %w", err)
+	}
+	return nil
+}
+
+// Summarise the log file between the days given in the supplied arguments, in
+// the format given with -format. If targets are given, only they are included
+func reportCommand(args []string) error {
+	fs := subcommandFlags("report", "i", "log", "c", "interval", "profile", "locale", "timezone")
+	format := fs.String("format", "csv", "csv for a row per day, text for a digest of each target, pdf for the digest as a PDF, ics for the incidents as iCalendar or patterns for an analysis of lost pings")
+	fromFlag := fs.String("from", "", "first day to cover, e.g. 2018-06-01. The start of this month for csv, text and pdf, a week ago for patterns and the start of the log for ics")
+	toFlag := fs.String("to", "", "last day to cover, e.g. 2018-06-30. Today if not set")
+	tenantFlag := fs.String("tenant", "", "only cover the targets of the supplied tenant")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if err := configure(fs); err != nil {
+		return err
+	}
+	only := currentSettings().targets
+	if len(*tenantFlag) > 0 {
+		t := findTenant(*tenantFlag)
+		if t == nil {
+			return fmt.Errorf("unknown tenant '%s'", *tenantFlag)
+		}
+		only = t.targets
+	}
+
+	// Days run from midnight to midnight, and the last one is included
+	now := time.Now()
+	today := startOfDay(now)
+	var from time.Time
+	switch *format {
+	case "csv", "text", "pdf":
+		from = today.AddDate(0, 0, 1-today.Day())
+	case "patterns":
+		from = now.AddDate(0, 0, -patternDays)
+	case "ics":
+	default:
+		return fmt.Errorf("unknown report format '%s'. Use csv, text, pdf, ics or patterns", *format)
+	}
+	to := today.AddDate(0, 0, 1)
+	if *format == "patterns" {
+		to = now
+	}
+	from, to, err := dayRange(*fromFlag, *toFlag, from, to)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "ics":
+		return exportCalendar(os.Stdout, *logFlag, from, to, only)
+	case "patterns":
+		return lossPatterns(os.Stdout, *logFlag, from, to, only)
+	case "text":
+		return digest(os.Stdout, *logFlag, from, to, only)
+	case "pdf":
+		var text bytes.Buffer
+		if err := digest(&text, *logFlag, from, to, only); err != nil {
+			return err
+		}
+		title := fmt.Sprintf("autoping report from %s to %s", from.Format("2006-01-02"),
+			to.AddDate(0, 0, -1).Format("2006-01-02"))
+		return writePDF(os.Stdout, title, text.Bytes())
+	}
+	return dailyReport(os.Stdout, *logFlag, from, to, only)
+}
+
+// Returns the start of the day given with -from and the end of the day given
+// with -to, or the supplied times for those that weren't given
+func dayRange(fromFlag, toFlag string, from, to time.Time) (time.Time, time.Time, error) {
+	var err error
+	if len(fromFlag) > 0 {
+		if from, err = time.ParseInLocation("2006-01-02", fromFlag, timeZone); err != nil {
+			return from, to, fmt.Errorf("-from must look like 2006-01-02: %v", err)
+		}
+	}
+	if len(toFlag) > 0 {
+		if to, err = time.ParseInLocation("2006-01-02", toFlag, timeZone); err != nil {
+			return from, to, fmt.Errorf("-to must look like 2006-01-02: %v", err)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return from, to, errors.New("-to must not be before -from")
+	}
+	return from, to, nil
+}
+
+// Check the config file given with -c, as the only argument or in
+// AUTOPING_CONFIG, together with the environment, including the ping timings
+// they set, and say whether they are fine
+func validateCommand(args []string) error {
+	fs := subcommandFlags("validate-config", "c")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() == 1 && len(*configFlag) == 0:
+		*configFlag = fs.Arg(0)
+	case fs.NArg() > 0 || len(*configFlag) == 0 && len(config.File()) == 0:
+		return errors.New("usage: autoping validate-config <config file>")
+	}
+	if err := configure(fs); err != nil {
+		return err
+	}
+	if len(pingAddrs()) == 0 {
+		return fmt.Errorf("%s: there are no targets to ping", *configFlag)
+	}
+	ts, _ := currentTenants()
+	fmt.Printf("%s is valid: %d targets of %d tenants, pinged every %v\n", *configFlag,
+		len(pingAddrs()), len(ts), currentSettings().interval)
+	return nil
+}
+
+// Print the version of autoping, the Go release it was built with and the
+// experimental features it has
+func versionCommand(args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: autoping version")
+	}
+	fmt.Printf("autoping %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS,
+		runtime.GOARCH)
+	for _, name := range experimentNames() {
+		fmt.Printf("Experimental: %s - %s\n", name, experiments[name])
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Instead of flags, targets, the ping interval and timeout, the log file and
+// the latency thresholds can be set in AUTOPING_* environment variables or in
+// a YAML or TOML file passed with -c, as read by the config package. The file
+// is checked when autoping starts, and mistakes are reported with the line
+// they are on. The file wins over the environment, and flags given on the
+// command line win over both
+
+// Read the settings in the environment and in the supplied config file, if
+// any, with the file winning over the environment
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 0 {
+		file, err := config.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Merge(file)
+	}
+	if err := cfg.Check(); err != nil {
+		// Settings from the environment alone are in no file
+		if len(path) == 0 {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Returns the settings made from the supplied config, with those given as
+// flags in the supplied flag set winning. Settings that aren't in either go
+// back to those of the profile, or their defaults. The targets file's targets
+// and settings are kept from the supplied settings
+func configSettings(cfg *config.Config, fs *flag.FlagSet, old *settings) *settings {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	s := &settings{targets: cfg.Targets, labels: cfg.Labels, maintenance: cfg.Maintenance,
+		mailboxes: cfg.Mailboxes, configSample: cfg.Sampling.Targets, configOverrides: cfg.Overrides,
+		fileTargets: old.fileTargets, fileSettings: old.fileSettings, tenants: configTenants(cfg),
+		operatorToken: cfg.OperatorToken}
+	if set["i"] {
+		s.targets = importFlag
+	}
+
+	// The profile's settings come first, so the file and flags win over them
+	p, _ := currentPreset()
+	s.minOutage, s.outageAfter = p.minOutage, p.outageAfter
+	if set["min-outage"] {
+		s.minOutage = *minOutageFlag
+	}
+	if set["outage-after"] {
+		s.outageAfter = *outageAfterFlag
+	}
+	s.interval = p.interval
+	if set["interval"] {
+		s.interval = *intervalFlag
+	} else if cfg.Interval > 0 {
+		s.interval = time.Duration(cfg.Interval)
+	}
+	s.sample = 1
+	if set["sample"] {
+		s.sample = *sampleFlag
+	} else if cfg.Sampling.Every > 0 {
+		s.sample = cfg.Sampling.Every
+	}
+	s.mergeTargetSettings()
+
+	// A profile's timeout that doesn't fit in a shorter interval is left out
+	if p.timeout < s.interval {
+		s.timeout = p.timeout
+	}
+	if cfg.Timeout > 0 {
+		s.timeout = time.Duration(cfg.Timeout)
+	}
+	if set["timeout"] {
+		s.timeout = *timeoutFlag
+	}
+	if s.timeout == 0 {
+		s.timeout = autoTimeout(s.interval)
+	}
+	s.thresholds = [3]float64{p.latency.Mild, p.latency.Severe, p.latency.Extreme}
+	if cfg.Latency != nil {
+		s.thresholds = [3]float64{cfg.Latency.Mild, cfg.Latency.Severe, cfg.Latency.Extreme}
+	}
+	return s
+}
+
+// Apply the supplied settings that weren't given as flags in the supplied flag
+// set, as configSettings does, and the log files they name
+func applyConfig(cfg *config.Config, fs *flag.FlagSet) {
+	useSettings(configSettings(cfg, fs, currentSettings()))
+	if len(cfg.LogFile) > 0 && !flagSet(fs, "log") {
+		*logFlag = cfg.LogFile
+	}
+	logRoutes, eventsFile = cfg.Logs, cfg.Events
+}
+
+// Returns true if the flag with the supplied name was given in the supplied
+// flag set
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// Read the environment and the config file again and apply them. Targets that
+// are still in them keep their outage and latency tracking. If anything is
+// wrong with them, nothing changes. The log files only change when autoping is restarted
+func reloadConfig(path string) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	s := configSettings(cfg, flag.CommandLine, currentSettings())
+	if err := s.checkReload(); err != nil {
+		return err
+	}
+
+	useSettings(s)
+	setReports(cfg)
+	setRemoteWrite(cfg)
+	setClouds(cfg)
+	setSheets(cfg)
+	setCrash(cfg)
+	setExperiments(cfg)
+	logExperiments()
+	updateTargets()
+	return nil
+}
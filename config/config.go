@@ -0,0 +1,997 @@
+// Package config reads autoping's settings from the environment and from the
+// YAML or TOML file passed with -c. Settings can come from three places, and
+// each wins over the one before it:
+//
+//	AUTOPING_* environment variables < the config file < command line flags
+//
+// This package covers the first two. Flags are left to autoping itself,
+// which only applies the settings here that weren't given as flags.
+//
+// The environment variables are AUTOPING_TARGET (several targets separated by
+// commas), AUTOPING_INTERVAL, AUTOPING_TIMEOUT, AUTOPING_LOG_PATH and
+// AUTOPING_LATENCY_MILD, AUTOPING_LATENCY_SEVERE and AUTOPING_LATENCY_EXTREME,
+// matching the settings of the config file, and AUTOPING_OPERATOR_TOKEN and
+// AUTOPING_SMTP_PASSWORD and AUTOPING_REMOTE_WRITE_PASSWORD, so secrets can be
+// kept out of the file. Tenants, reports, mailboxes, the files of each class
+// of log lines, the event file, sampling, labels, per-target overrides,
+// maintenance windows, remote write, cloud metrics, the spreadsheet incidents
+// are added to, where crash reports go and experimental features can only be
+// set in the file.
+// AUTOPING_CONFIG names the config file when -c isn't given
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables autoping reads
+const EnvPrefix = "AUTOPING_"
+
+// Config holds the settings read from the environment or a config file.
+// Settings that weren't given are left at their zero value
+type Config struct {
+	Targets  []string `yaml:"targets" toml:"targets"`   // Addresses to ping
+	Interval Duration `yaml:"interval" toml:"interval"` // Time between pings
+	Timeout  Duration `yaml:"timeout" toml:"timeout"`   // Time to wait for a pong
+	LogFile  string   `yaml:"log_file" toml:"log_file"` // File all loggers write to
+	Latency  *Latency `yaml:"latency" toml:"latency"`   // Latency tier thresholds
+
+	// Time zone timestamps are written in and days roll over at, e.g.
+	// Australia/Sydney. The system's if not set
+	Timezone string `yaml:"timezone" toml:"timezone"`
+
+	// Customers whose targets are pinged alongside the others, by name. Each
+	// one only sees its own targets on the status page and API
+	Tenants       map[string]Tenant `yaml:"tenants" toml:"tenants"`
+	OperatorToken string            `yaml:"operator_token" toml:"operator_token"` // Token that sees every tenant
+
+	SMTP    SMTP     `yaml:"smtp" toml:"smtp"`       // Mail server reports and mail probes are sent through
+	Reports []Report `yaml:"reports" toml:"reports"` // Reports sent by email on a schedule
+
+	// Mailboxes the probes sent to mail:// targets are looked for in, by
+	// address
+	Mailboxes map[string]Mailbox `yaml:"mailboxes" toml:"mailboxes"`
+
+	// Files some classes of log lines are written to instead of the log file,
+	// by class
+	Logs map[string]LogFile `yaml:"logs" toml:"logs"`
+
+	// File every log line is also written to as a JSON object, one per line
+	Events LogFile `yaml:"events" toml:"events"`
+
+	Sampling Sampling `yaml:"sampling" toml:"sampling"` // How many pongs are logged
+
+	// Labels of targets, such as site=office or link=starlink, by address
+	Labels map[string]map[string]string `yaml:"labels" toml:"labels"`
+
+	// Settings of targets that differ from those of the others, such as a LAN
+	// gateway pinged more often, by address
+	Overrides map[string]Override `yaml:"overrides" toml:"overrides"`
+
+	Maintenance []Maintenance `yaml:"maintenance" toml:"maintenance"` // Times outages are expected
+
+	RemoteWrite RemoteWrite `yaml:"remote_write" toml:"remote_write"` // Prometheus receiver samples are pushed to
+
+	CloudWatch   CloudWatch   `yaml:"cloudwatch" toml:"cloudwatch"`       // AWS CloudWatch metrics are published to
+	AzureMonitor AzureMonitor `yaml:"azure_monitor" toml:"azure_monitor"` // Azure Monitor metrics are published to
+
+	GoogleSheets GoogleSheets `yaml:"google_sheets" toml:"google_sheets"` // Spreadsheet incidents are added to
+
+	Crash Crash `yaml:"crash" toml:"crash"` // Where reports of panics go
+
+	// Experimental features turned on, such as detectors that ship turned
+	// off, by name
+	Experimental []string `yaml:"experimental" toml:"experimental"`
+
+	lines map[string]int // Line of the file each setting is on, by path
+}
+
+// Crash is the directory a report is written to when autoping panics, and
+// the endpoint it is posted to, if any
+type Crash struct {
+	Dir     string            `yaml:"dir" toml:"dir"`         // Directory reports are written to
+	URL     string            `yaml:"url" toml:"url"`         // Endpoint reports are posted to as JSON, if any
+	Headers map[string]string `yaml:"headers" toml:"headers"` // Headers sent with each report, e.g. Authorization
+}
+
+// GoogleSheets is a Google Sheets spreadsheet a row is added to for every
+// incident, with the credentials of a service account it is shared with
+type GoogleSheets struct {
+	SpreadsheetID string `yaml:"spreadsheet_id" toml:"spreadsheet_id"` // ID in the spreadsheet's URL
+	Sheet         string `yaml:"sheet" toml:"sheet"`                   // Name of the sheet. Sheet1 if not set
+	Credentials   string `yaml:"credentials" toml:"credentials"`       // JSON key file of the service account
+}
+
+// CloudWatch is the AWS region and namespace packet loss and RTT are
+// published to every minute as custom metrics
+type CloudWatch struct {
+	Region    string `yaml:"region" toml:"region"`       // e.g. eu-west-1
+	Namespace string `yaml:"namespace" toml:"namespace"` // autoping if not set
+}
+
+// AzureMonitor is the Azure resource packet loss and RTT are published to
+// every minute as custom metrics
+type AzureMonitor struct {
+	Region     string `yaml:"region" toml:"region"`           // Region of the resource, e.g. westeurope
+	ResourceID string `yaml:"resource_id" toml:"resource_id"` // e.g. /subscriptions/.../virtualMachines/probe
+	Namespace  string `yaml:"namespace" toml:"namespace"`     // autoping if not set
+}
+
+// RemoteWrite is a Prometheus remote-write receiver, such as Mimir, Thanos or
+// VictoriaMetrics, that the result of every ping is pushed to
+type RemoteWrite struct {
+	URL      string            `yaml:"url" toml:"url"`           // Endpoint, e.g. https://mimir.example.com/api/v1/push
+	Interval Duration          `yaml:"interval" toml:"interval"` // Time between pushes. 1m if not set
+	Username string            `yaml:"username" toml:"username"` // Username to log in with, if any
+	Password string            `yaml:"password" toml:"password"` // Password to log in with, if any
+	Headers  map[string]string `yaml:"headers" toml:"headers"`   // Headers sent with each push, e.g. X-Scope-OrgID
+	Labels   map[string]string `yaml:"labels" toml:"labels"`     // Labels added to every series, e.g. to tell sites apart
+}
+
+// Override holds the settings of a target that differ from those of the
+// others. Those that aren't set are the same as the others'. The settings of
+// one kind of target are kept apart, in options of their own, and are written
+// alongside the rest in the file
+type Override struct {
+	Interval    Duration `yaml:"interval" toml:"interval"`         // Time between pings
+	Timeout     Duration `yaml:"timeout" toml:"timeout"`           // Time to wait for a pong. Half the interval, up to 30s, if only the interval is set
+	MinOutage   Duration `yaml:"min_outage" toml:"min_outage"`     // Time without a pong before an outage is declared
+	OutageAfter int      `yaml:"outage_after" toml:"outage_after"` // Missed pings before an outage is declared, instead of min_outage
+	Latency     *Latency `yaml:"latency" toml:"latency"`           // Latency tier thresholds
+	Mirror      string   `yaml:"mirror" toml:"mirror"`             // URL the result of every ping is posted to, if any
+
+	HTTPOptions `yaml:",inline"` // Settings of targets probed over HTTP
+	UDPOptions  `yaml:",inline"` // Settings of targets probed over UDP
+}
+
+// HTTPOptions are the settings only targets probed over HTTP can have
+type HTTPOptions struct {
+	Method string `yaml:"method" toml:"method"` // GET or HEAD. GET if not set
+}
+
+// UDPOptions are the settings only targets probed over UDP can have
+type UDPOptions struct {
+	// Datagram sent. "autoping" if not set
+	Payload Payload `yaml:"payload" toml:"payload"`
+	// What an answer has to hold to count as a pong. Any answer counts if not
+	// set
+	Expect Payload `yaml:"expect" toml:"expect"`
+}
+
+// Maintenance is a window starting whenever its schedule matches, during which
+// targets are still pinged, but their outages and blips don't count
+type Maintenance struct {
+	Schedule Cron     `yaml:"schedule" toml:"schedule"` // When the window starts
+	Duration Duration `yaml:"duration" toml:"duration"` // How long it lasts
+	Targets  []string `yaml:"targets" toml:"targets"`   // Targets it covers. Every target if not set
+}
+
+// Sampling sets how many pongs are logged: 1 in Every, or 1 in the number
+// given for a target in Targets. Every pong is logged if neither is set
+type Sampling struct {
+	Every   int            `yaml:"every" toml:"every"`     // Log 1 in this many pongs
+	Targets map[string]int `yaml:"targets" toml:"targets"` // Log 1 in this many pongs of a target, by address
+}
+
+// LogFile is a file one class of log lines, or the events, are written to,
+// and when it is rotated. Rotated files get .1, .2 and so on added to their name, .1 being
+// the newest
+type LogFile struct {
+	File    string `yaml:"file" toml:"file"`         // Path of the file
+	Rotate  string `yaml:"rotate" toml:"rotate"`     // daily, weekly or monthly. Never if not set
+	MaxSize int64  `yaml:"max_size" toml:"max_size"` // Megabytes the file is rotated at. No limit if not set
+	Keep    int    `yaml:"keep" toml:"keep"`         // Rotated files kept. 7 if not set
+}
+
+// Tenant is a customer with its own targets and API token
+type Tenant struct {
+	Token   string   `yaml:"token" toml:"token"`     // Token the tenant's requests carry
+	Targets []string `yaml:"targets" toml:"targets"` // Addresses to ping for the tenant
+}
+
+// SMTP holds the mail server reports and mail probes are sent through
+type SMTP struct {
+	Server   string `yaml:"server" toml:"server"`     // host:port of the server
+	From     string `yaml:"from" toml:"from"`         // Sender of the reports and probes
+	Username string `yaml:"username" toml:"username"` // Username to log in with, if any
+	Password string `yaml:"password" toml:"password"` // Password to log in with, if any
+}
+
+// Mailbox is the IMAP server and login of a mailbox mail probes are sent to
+type Mailbox struct {
+	IMAP     string `yaml:"imap" toml:"imap"`         // host:port of the server, spoken to over TLS
+	Username string `yaml:"username" toml:"username"` // Username to log in with
+	Password string `yaml:"password" toml:"password"` // Password to log in with
+}
+
+// Report is a subscription to a report sent by email on a schedule
+type Report struct {
+	Name     string   `yaml:"name" toml:"name"`         // Name the report goes by in the log
+	To       []string `yaml:"to" toml:"to"`             // Recipients
+	Schedule string   `yaml:"schedule" toml:"schedule"` // daily, weekly or monthly
+	Format   string   `yaml:"format" toml:"format"`     // text, csv, ics or pdf. text if not set
+	Targets  []string `yaml:"targets" toml:"targets"`   // Only cover these targets
+	Tenant   string   `yaml:"tenant" toml:"tenant"`     // Only cover the targets of this tenant
+}
+
+// Names labels can have, as in Prometheus
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Schedules and formats a report can have
+var (
+	Schedules     = []string{"daily", "weekly", "monthly"}
+	ReportFormats = []string{"text", "csv", "ics", "pdf"}
+)
+
+// Classes of log lines that can have files of their own, and how often the
+// files can be rotated
+var (
+	LogClasses = []string{"ping", "outage", "error", "access"}
+	Rotations  = []string{"daily", "weekly", "monthly"}
+)
+
+// Duration is a positive duration such as "90s" or "2m". Zero if not set
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("%q isn't a duration like 30s or 2m", text)
+	}
+	if v <= 0 {
+		return fmt.Errorf("duration %v must be more than 0", v)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	if err := d.UnmarshalText([]byte(n.Value)); err != nil {
+		return fmt.Errorf("line %d: %v", n.Line, err)
+	}
+	return nil
+}
+
+// Payload is bytes given as text, or as hex after 0x for those that aren't
+// text, such as "0xfeedface"
+type Payload []byte
+
+func (p *Payload) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.HasPrefix(s, "0x") {
+		*p = Payload(s)
+		return nil
+	}
+	b, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return fmt.Errorf("%q isn't text or hex after 0x", text)
+	}
+	*p = b
+	return nil
+}
+
+func (p Payload) MarshalYAML() (interface{}, error) {
+	if utf8.Valid(p) && !strings.HasPrefix(string(p), "0x") {
+		return string(p), nil
+	}
+	return "0x" + hex.EncodeToString(p), nil
+}
+
+func (p *Payload) UnmarshalYAML(n *yaml.Node) error {
+	if err := p.UnmarshalText([]byte(n.Value)); err != nil {
+		return fmt.Errorf("line %d: %v", n.Line, err)
+	}
+	return nil
+}
+
+// Latency holds the thresholds of the latency tiers, as multiples of the mean
+// latency. Tiers that aren't set keep their defaults
+type Latency struct {
+	Mild    float64
+	Severe  float64
+	Extreme float64
+}
+
+// DefaultLatency holds the latency tier thresholds used unless they are set
+var DefaultLatency = Latency{Mild: 2, Severe: 3, Extreme: 10}
+
+// Method to set the thresholds from a table of tier names and multiples,
+// checking that they make sense
+func (lc *Latency) set(values map[string]float64) error {
+	*lc = DefaultLatency
+	for name, v := range values {
+		switch name {
+		case "mild":
+			lc.Mild = v
+		case "severe":
+			lc.Severe = v
+		case "extreme":
+			lc.Extreme = v
+		default:
+			return fmt.Errorf("unknown latency tier %q. Use mild, severe or extreme", name)
+		}
+	}
+	if lc.Mild <= 1 {
+		return fmt.Errorf("mild latency threshold %v must be more than 1 times the mean", lc.Mild)
+	}
+	if lc.Severe <= lc.Mild || lc.Extreme <= lc.Severe {
+		return fmt.Errorf("latency thresholds must go up from mild (%v) to severe (%v) to extreme (%v)",
+			lc.Mild, lc.Severe, lc.Extreme)
+	}
+	return nil
+}
+
+func (lc *Latency) UnmarshalYAML(n *yaml.Node) error {
+	var values map[string]float64
+	if err := n.Decode(&values); err != nil {
+		return err
+	}
+	if err := lc.set(values); err != nil {
+		return fmt.Errorf("line %d: %v", n.Line, err)
+	}
+	return nil
+}
+
+func (lc *Latency) UnmarshalTOML(data interface{}) error {
+	table, ok := data.(map[string]interface{})
+	if !ok {
+		return errors.New("latency must be a table of tier thresholds")
+	}
+	values := make(map[string]float64)
+	for name, v := range table {
+		switch n := v.(type) {
+		case int64:
+			values[name] = float64(n)
+		case float64:
+			values[name] = n
+		default:
+			return fmt.Errorf("latency threshold %s must be a number", name)
+		}
+	}
+	return lc.set(values)
+}
+
+// Load reads the supplied config file. Files ending in .toml are read as
+// TOML, and anything else as YAML
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(data), &cfg)
+		if pe, ok := err.(toml.ParseError); ok {
+			return nil, fmt.Errorf("%s: line %d: %s", path, pe.Position.Line, pe.Message)
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if keys := md.Undecoded(); len(keys) > 0 {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, keys[0].String())
+		}
+		cfg.lines = tomlLines(string(data), md)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err := dec.Decode(&cfg)
+		if te, ok := err.(*yaml.TypeError); ok {
+			return nil, fmt.Errorf("%s: %s", path, strings.Join(te.Errors, "; "))
+		} else if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		cfg.lines = yamlLines(data)
+	}
+
+	for i, addr := range cfg.Targets {
+		if len(strings.TrimSpace(addr)) == 0 {
+			return nil, fmt.Errorf("%s: %v", path, cfg.at(fmt.Errorf("target %d is empty", i+1), "targets", i))
+		}
+	}
+	return &cfg, nil
+}
+
+// Check makes sure the tenants, reports, log and event files, sampling,
+// labels, overrides, maintenance windows, time zone, remote write, cloud
+// metrics, spreadsheet, crash reports and experimental features make sense.
+// Their secrets can come from the environment, so this is done once the
+// settings have been merged. Mistakes in settings that came from the file
+// are reported with the line they are on
+func (cfg *Config) Check() error {
+	if err := cfg.checkTenants(); err != nil {
+		return err
+	}
+	if err := cfg.checkReports(); err != nil {
+		return err
+	}
+	if err := cfg.checkMailboxes(); err != nil {
+		return err
+	}
+	if err := cfg.checkLogs(); err != nil {
+		return err
+	}
+	if err := cfg.checkEvents(); err != nil {
+		return err
+	}
+	if err := cfg.checkSampling(); err != nil {
+		return err
+	}
+	if err := cfg.checkLabels(); err != nil {
+		return err
+	}
+	if err := cfg.checkOverrides(); err != nil {
+		return err
+	}
+	if err := cfg.checkMaintenance(); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return cfg.at(fmt.Errorf("timezone: %v", err), "timezone")
+	}
+	if err := cfg.checkRemoteWrite(); err != nil {
+		return err
+	}
+	if err := cfg.checkClouds(); err != nil {
+		return err
+	}
+	if err := cfg.checkSheets(); err != nil {
+		return err
+	}
+	if err := cfg.checkCrash(); err != nil {
+		return err
+	}
+	return cfg.checkExperimental()
+}
+
+// Method to check that every tenant has targets and a token of its own, and
+// that there is an operator token to see them all with
+func (cfg *Config) checkTenants() error {
+	if len(cfg.Tenants) == 0 {
+		return nil
+	}
+	if len(cfg.OperatorToken) == 0 {
+		return cfg.at(errors.New("tenants need an operator_token to see every tenant with"), "tenants")
+	}
+	tokens := map[string]string{cfg.OperatorToken: "operator_token"}
+	for name, t := range cfg.Tenants {
+		if len(t.Token) == 0 {
+			return cfg.at(fmt.Errorf("tenant %s has no token", name), "tenants", name)
+		}
+		if other, ok := tokens[t.Token]; ok {
+			return cfg.at(fmt.Errorf("tenant %s has the same token as %s", name, other), "tenants", name, "token")
+		}
+		tokens[t.Token] = "tenant " + name
+		if len(t.Targets) == 0 {
+			return cfg.at(fmt.Errorf("tenant %s has no targets", name), "tenants", name)
+		}
+		for i, addr := range t.Targets {
+			if len(strings.TrimSpace(addr)) == 0 {
+				return cfg.at(fmt.Errorf("target %d of tenant %s is empty", i+1, name), "tenants", name, "targets", i)
+			}
+		}
+	}
+	return nil
+}
+
+// Method to check that every report has a name of its own, recipients, a
+// schedule and a format, and that there is a mail server to send them through
+func (cfg *Config) checkReports() error {
+	if len(cfg.Reports) == 0 {
+		return nil
+	}
+	if len(cfg.SMTP.Server) == 0 || len(cfg.SMTP.From) == 0 {
+		return cfg.at(errors.New("reports need an smtp server and from address to be sent with"), "reports")
+	}
+	names := make(map[string]bool)
+	for i, r := range cfg.Reports {
+		switch {
+		case len(r.Name) == 0:
+			return cfg.at(fmt.Errorf("report %d has no name", i+1), "reports", i)
+		case names[r.Name]:
+			return cfg.at(fmt.Errorf("there are two reports called %s", r.Name), "reports", i, "name")
+		case len(r.To) == 0:
+			return cfg.at(fmt.Errorf("report %s has no recipients", r.Name), "reports", i)
+		case !oneOf(r.Schedule, Schedules):
+			return cfg.at(fmt.Errorf("report %s has schedule %q. Use %s", r.Name, r.Schedule,
+				strings.Join(Schedules, ", ")), "reports", i, "schedule")
+		case len(r.Format) > 0 && !oneOf(r.Format, ReportFormats):
+			return cfg.at(fmt.Errorf("report %s has format %q. Use %s", r.Name, r.Format,
+				strings.Join(ReportFormats, ", ")), "reports", i, "format")
+		case len(r.Tenant) > 0 && len(r.Targets) > 0:
+			return cfg.at(fmt.Errorf("report %s has both targets and a tenant", r.Name), "reports", i, "tenant")
+		}
+		if _, ok := cfg.Tenants[r.Tenant]; len(r.Tenant) > 0 && !ok {
+			return cfg.at(fmt.Errorf("report %s is for unknown tenant %s", r.Name, r.Tenant), "reports", i, "tenant")
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// Method to check that every mailbox has an IMAP server and a login, and that
+// there is an smtp server to send probes to it through
+func (cfg *Config) checkMailboxes() error {
+	if len(cfg.Mailboxes) == 0 {
+		return nil
+	}
+	if len(cfg.SMTP.Server) == 0 || len(cfg.SMTP.From) == 0 {
+		return cfg.at(errors.New("mailboxes need an smtp server and from address to send probes with"), "mailboxes")
+	}
+	for addr, m := range cfg.Mailboxes {
+		if !strings.Contains(addr, "@") {
+			return cfg.at(fmt.Errorf("mailbox %s must be an email address", addr), "mailboxes", addr)
+		}
+		if _, _, err := net.SplitHostPort(m.IMAP); err != nil {
+			return cfg.at(fmt.Errorf("imap server %q of mailbox %s must be host:port", m.IMAP, addr), "mailboxes", addr, "imap")
+		}
+		if len(m.Username) == 0 {
+			return cfg.at(fmt.Errorf("mailbox %s has no username", addr), "mailboxes", addr)
+		}
+	}
+	return nil
+}
+
+// Method to check that every class of log lines with a file of its own is a
+// known one, and that its file and rotation make sense
+func (cfg *Config) checkLogs() error {
+	files := make(map[string]string)
+	for class, l := range cfg.Logs {
+		switch {
+		case !oneOf(class, LogClasses):
+			return cfg.at(fmt.Errorf("unknown class of log lines %q. Use %s", class, strings.Join(LogClasses, ", ")), "logs", class)
+		case len(l.File) == 0:
+			return cfg.at(fmt.Errorf("%s log has no file", class), "logs", class)
+		case l.File == "stdout" || l.File == "stderr":
+			return cfg.at(fmt.Errorf("%s log can't go to %s. Use log_file to log everything there", class, l.File), "logs", class, "file")
+		case len(files[l.File]) > 0:
+			return cfg.at(fmt.Errorf("%s and %s logs both go to %s", files[l.File], class, l.File), "logs", class, "file")
+		}
+		if err := l.checkRotation(class + " log"); err != nil {
+			return cfg.at(err, "logs", class)
+		}
+		files[l.File] = class
+	}
+	return nil
+}
+
+// Method to check that the event file, if there is one, is a file of its own
+// and that its rotation makes sense
+func (cfg *Config) checkEvents() error {
+	ev := cfg.Events
+	if len(ev.File) == 0 {
+		if len(ev.Rotate) > 0 || ev.MaxSize != 0 || ev.Keep != 0 {
+			return cfg.at(errors.New("events has no file"), "events")
+		}
+		return nil
+	}
+	switch {
+	case ev.File == "stdout" || ev.File == "stderr":
+		return cfg.at(fmt.Errorf("events can't go to %s. Use a file", ev.File), "events", "file")
+	case ev.File == cfg.LogFile:
+		return cfg.at(fmt.Errorf("events and the log both go to %s", ev.File), "events", "file")
+	}
+	for class, l := range cfg.Logs {
+		if l.File == ev.File {
+			return cfg.at(fmt.Errorf("events and the %s log both go to %s", class, ev.File), "events", "file")
+		}
+	}
+	return cfg.at(ev.checkRotation("events"), "events")
+}
+
+// Method to check that the rotation of the file, which the supplied name
+// stands for in errors, makes sense
+func (l LogFile) checkRotation(name string) error {
+	switch {
+	case len(l.Rotate) > 0 && !oneOf(l.Rotate, Rotations):
+		return fmt.Errorf("%s has rotate %q. Use %s", name, l.Rotate, strings.Join(Rotations, ", "))
+	case l.MaxSize < 0:
+		return fmt.Errorf("%s has max_size %d. Use a number of megabytes", name, l.MaxSize)
+	case l.Keep < 0:
+		return fmt.Errorf("%s has keep %d. Use the number of rotated files to keep", name, l.Keep)
+	}
+	return nil
+}
+
+// Method to check that every sampling rate is 1 in 1 or more pongs
+func (cfg *Config) checkSampling() error {
+	if cfg.Sampling.Every < 0 {
+		return cfg.at(fmt.Errorf("sampling every %d must be 1 or more", cfg.Sampling.Every), "sampling", "every")
+	}
+	for addr, n := range cfg.Sampling.Targets {
+		if n < 1 {
+			return cfg.at(fmt.Errorf("sampling of %s is %d. Use 1 or more", addr, n), "sampling", "targets", addr)
+		}
+	}
+	return nil
+}
+
+// Method to check that the overridden settings of every target make sense on
+// their own. Whether the timeout is shorter than the interval also depends on
+// flags, so autoping checks that itself
+func (cfg *Config) checkOverrides() error {
+	for addr, o := range cfg.Overrides {
+		switch {
+		case o.Interval != 0 && time.Duration(o.Interval) < time.Second:
+			return cfg.at(fmt.Errorf("interval of %s is %v. Use 1s or more", addr, time.Duration(o.Interval)), "overrides", addr, "interval")
+		case o.Timeout < 0:
+			return cfg.at(fmt.Errorf("timeout of %s must be more than 0", addr), "overrides", addr, "timeout")
+		case o.MinOutage < 0:
+			return cfg.at(fmt.Errorf("min_outage of %s must be more than 0", addr), "overrides", addr, "min_outage")
+		case o.OutageAfter < 0:
+			return cfg.at(fmt.Errorf("outage_after of %s is %d. Use 1 or more", addr, o.OutageAfter), "overrides", addr, "outage_after")
+		case len(o.Method) > 0 && o.Method != "GET" && o.Method != "HEAD":
+			return cfg.at(fmt.Errorf("method of %s is %s. Use GET or HEAD", addr, o.Method), "overrides", addr, "method")
+		}
+		if len(o.Mirror) > 0 {
+			u, err := url.Parse(o.Mirror)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+				return cfg.at(fmt.Errorf("mirror of %s is %q. Use an http or https URL", addr, o.Mirror), "overrides", addr, "mirror")
+			}
+		}
+	}
+	return nil
+}
+
+// Method to check that every label has a name that metric exports accept and
+// a value that can be written in a log line
+func (cfg *Config) checkLabels() error {
+	for addr, labels := range cfg.Labels {
+		for name, value := range labels {
+			if !labelName.MatchString(name) {
+				return cfg.at(fmt.Errorf("label %q of %s must be letters, digits and underscores", name, addr), "labels", addr, name)
+			}
+			if len(value) == 0 || strings.ContainsAny(value, " \t\n[]=") {
+				return cfg.at(fmt.Errorf("label %s of %s is %q. Use a value without spaces, brackets or =", name, addr, value), "labels", addr, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Method to check that every maintenance window has a schedule and a duration
+func (cfg *Config) checkMaintenance() error {
+	for i, m := range cfg.Maintenance {
+		switch {
+		case len(m.Schedule.String()) == 0:
+			return cfg.at(fmt.Errorf("maintenance window %d has no schedule", i+1), "maintenance", i)
+		case m.Duration == 0:
+			return cfg.at(fmt.Errorf("maintenance window %d has no duration", i+1), "maintenance", i)
+		case time.Duration(m.Duration) > 7*24*time.Hour:
+			return cfg.at(fmt.Errorf("maintenance window %d lasts %v. Use a week or less", i+1, time.Duration(m.Duration)), "maintenance", i, "duration")
+		}
+	}
+	return nil
+}
+
+// Method to check that the remote-write receiver, if there is one, has an
+// HTTP URL, and that its labels have names and values Prometheus accepts
+func (cfg *Config) checkRemoteWrite() error {
+	rw := cfg.RemoteWrite
+	if len(rw.URL) == 0 {
+		if len(rw.Username) > 0 || len(rw.Headers) > 0 || len(rw.Labels) > 0 {
+			return cfg.at(errors.New("remote_write has no url"), "remote_write")
+		}
+		return nil
+	}
+	u, err := url.Parse(rw.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+		return cfg.at(fmt.Errorf("remote_write url %q must be an http or https URL", rw.URL), "remote_write", "url")
+	}
+	if len(rw.Password) > 0 && len(rw.Username) == 0 {
+		return cfg.at(errors.New("remote_write has a password but no username"), "remote_write")
+	}
+	if rw.Interval > 0 && time.Duration(rw.Interval) < time.Second {
+		return cfg.at(fmt.Errorf("remote_write interval %v must be 1s or more", time.Duration(rw.Interval)), "remote_write", "interval")
+	}
+	for name, value := range rw.Labels {
+		if !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return cfg.at(fmt.Errorf("remote_write label %q must be letters, digits and underscores, not starting with __", name), "remote_write", "labels", name)
+		}
+		if len(value) == 0 {
+			return cfg.at(fmt.Errorf("remote_write label %s has no value", name), "remote_write", "labels", name)
+		}
+	}
+	return nil
+}
+
+// Method to check that CloudWatch and Azure Monitor, if set, have what
+// metrics need to be published to them
+func (cfg *Config) checkClouds() error {
+	cw, az := cfg.CloudWatch, cfg.AzureMonitor
+	if len(cw.Namespace) > 0 && len(cw.Region) == 0 {
+		return cfg.at(errors.New("cloudwatch has no region"), "cloudwatch")
+	}
+	if len(az.Region) > 0 || len(az.ResourceID) > 0 || len(az.Namespace) > 0 {
+		switch {
+		case len(az.Region) == 0:
+			return cfg.at(errors.New("azure_monitor has no region"), "azure_monitor")
+		case !strings.HasPrefix(az.ResourceID, "/subscriptions/"):
+			return cfg.at(fmt.Errorf("azure_monitor resource_id %q must start with /subscriptions/", az.ResourceID), "azure_monitor", "resource_id")
+		}
+	}
+	return nil
+}
+
+// Method to check that the spreadsheet, if there is one, has credentials to
+// add rows with
+func (cfg *Config) checkSheets() error {
+	gs := cfg.GoogleSheets
+	if len(gs.SpreadsheetID) == 0 && len(gs.Credentials) == 0 && len(gs.Sheet) == 0 {
+		return nil
+	}
+	if len(gs.SpreadsheetID) == 0 || len(gs.Credentials) == 0 {
+		return cfg.at(errors.New("google_sheets needs both a spreadsheet_id and the credentials of a service account"), "google_sheets")
+	}
+	return nil
+}
+
+// Returns true if s is one of the supplied values
+func oneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// FromEnv reads the settings in the AUTOPING_* environment variables. Empty
+// variables count as not set
+func FromEnv() (*Config, error) {
+	var cfg Config
+	if v := env("TARGET"); len(v) > 0 {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); len(addr) > 0 {
+				cfg.Targets = append(cfg.Targets, addr)
+			}
+		}
+	}
+	for name, d := range map[string]*Duration{"INTERVAL": &cfg.Interval, "TIMEOUT": &cfg.Timeout} {
+		if v := env(name); len(v) > 0 {
+			if err := d.UnmarshalText([]byte(v)); err != nil {
+				return nil, fmt.Errorf("%s%s: %v", EnvPrefix, name, err)
+			}
+		}
+	}
+	cfg.LogFile = env("LOG_PATH")
+	cfg.OperatorToken = env("OPERATOR_TOKEN")
+	cfg.SMTP.Password = env("SMTP_PASSWORD")
+	cfg.RemoteWrite.Password = env("REMOTE_WRITE_PASSWORD")
+
+	values := make(map[string]float64)
+	for _, tier := range []string{"mild", "severe", "extreme"} {
+		name := "LATENCY_" + strings.ToUpper(tier)
+		if v := env(name); len(v) > 0 {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s%s: %q isn't a number", EnvPrefix, name, v)
+			}
+			values[tier] = f
+		}
+	}
+	if len(values) > 0 {
+		cfg.Latency = new(Latency)
+		if err := cfg.Latency.set(values); err != nil {
+			return nil, fmt.Errorf("%sLATENCY_*: %v", EnvPrefix, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// File returns the config file named in AUTOPING_CONFIG, or "" if none is
+func File() string {
+	return env("CONFIG")
+}
+
+// Merge sets the settings given in over, replacing those already set. A
+// latency table replaces the one before it as a whole
+func (cfg *Config) Merge(over *Config) {
+	if over.lines != nil {
+		cfg.lines = over.lines
+	}
+	if len(over.Targets) > 0 {
+		cfg.Targets = over.Targets
+	}
+	if over.Interval > 0 {
+		cfg.Interval = over.Interval
+	}
+	if over.Timeout > 0 {
+		cfg.Timeout = over.Timeout
+	}
+	if len(over.LogFile) > 0 {
+		cfg.LogFile = over.LogFile
+	}
+	if over.Latency != nil {
+		cfg.Latency = over.Latency
+	}
+	if len(over.Timezone) > 0 {
+		cfg.Timezone = over.Timezone
+	}
+	if len(over.Tenants) > 0 {
+		cfg.Tenants = over.Tenants
+	}
+	if len(over.OperatorToken) > 0 {
+		cfg.OperatorToken = over.OperatorToken
+	}
+	if len(over.SMTP.Server) > 0 {
+		password := cfg.SMTP.Password
+		cfg.SMTP = over.SMTP
+		if len(cfg.SMTP.Password) == 0 {
+			cfg.SMTP.Password = password
+		}
+	}
+	if len(over.Reports) > 0 {
+		cfg.Reports = over.Reports
+	}
+	if len(over.Mailboxes) > 0 {
+		cfg.Mailboxes = over.Mailboxes
+	}
+	if len(over.Logs) > 0 {
+		cfg.Logs = over.Logs
+	}
+	if over.Events != (LogFile{}) {
+		cfg.Events = over.Events
+	}
+	if over.Sampling.Every > 0 || len(over.Sampling.Targets) > 0 {
+		cfg.Sampling = over.Sampling
+	}
+	if len(over.Labels) > 0 {
+		cfg.Labels = over.Labels
+	}
+	if len(over.Overrides) > 0 {
+		cfg.Overrides = over.Overrides
+	}
+	if len(over.Maintenance) > 0 {
+		cfg.Maintenance = over.Maintenance
+	}
+	if len(over.GoogleSheets.SpreadsheetID) > 0 {
+		cfg.GoogleSheets = over.GoogleSheets
+	}
+	if len(over.CloudWatch.Region) > 0 {
+		cfg.CloudWatch = over.CloudWatch
+	}
+	if len(over.AzureMonitor.Region) > 0 {
+		cfg.AzureMonitor = over.AzureMonitor
+	}
+	if len(over.Experimental) > 0 {
+		cfg.Experimental = over.Experimental
+	}
+	if len(over.Crash.Dir) > 0 || len(over.Crash.URL) > 0 {
+		cfg.Crash = over.Crash
+	}
+	if len(over.RemoteWrite.URL) > 0 {
+		password := cfg.RemoteWrite.Password
+		cfg.RemoteWrite = over.RemoteWrite
+		if len(cfg.RemoteWrite.Password) == 0 {
+			cfg.RemoteWrite.Password = password
+		}
+	}
+}
+
+// Returns the value of the supplied AUTOPING_ environment variable
+func env(name string) string {
+	return strings.TrimSpace(os.Getenv(EnvPrefix + name))
+}
+
+// IsZero reports whether none of the overrides are set
+func (o Override) IsZero() bool {
+	return reflect.ValueOf(o).IsZero()
+}
+
+// Merge returns the overrides with those set in the supplied ones replacing
+// them, setting by setting, the options of each kind of target included
+func (o Override) Merge(over Override) Override {
+	mergeFields(reflect.ValueOf(&o).Elem(), reflect.ValueOf(over))
+	return o
+}
+
+// Set the fields of the struct v to those of over that are set, going into
+// the structs embedded in it
+func mergeFields(v, over reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		switch {
+		case v.Type().Field(i).Anonymous:
+			mergeFields(v.Field(i), over.Field(i))
+		case !over.Field(i).IsZero():
+			v.Field(i).Set(over.Field(i))
+		}
+	}
+}
+
+// Method to check that crash reports, if they are posted, go to an HTTP URL
+func (cfg *Config) checkCrash() error {
+	c := cfg.Crash
+	if len(c.URL) == 0 {
+		if len(c.Headers) > 0 {
+			return cfg.at(errors.New("crash has headers but no url"), "crash")
+		}
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+		return cfg.at(fmt.Errorf("crash url %q must be an http or https URL", c.URL), "crash", "url")
+	}
+	return nil
+}
+
+// Secret settings, and those that can carry a secret such as headers, by name
+var secretKeys = map[string]bool{"password": true, "token": true, "operator_token": true, "headers": true}
+
+// Redacted returns the settings as YAML with their secrets left out:
+// passwords, tokens, the values of headers and the passwords in URLs. For
+// crash reports and the like, which are sent where secrets don't belong
+func (cfg *Config) Redacted() (string, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	redact(&doc, false)
+	out, err := yaml.Marshal(&doc)
+	return string(out), err
+}
+
+// Replace the secrets in the supplied node and those under it. secret says
+// whether the node is the value of a secret setting
+func redact(n *yaml.Node, secret bool) {
+	if n.Kind == yaml.ScalarNode {
+		if secret && len(n.Value) > 0 {
+			n.Value, n.Tag, n.Style = "REDACTED", "!!str", 0
+		} else if u, err := url.Parse(n.Value); err == nil && u.User != nil {
+			n.Value = u.Redacted()
+		}
+		return
+	}
+	for i, c := range n.Content {
+		switch {
+		case n.Kind != yaml.MappingNode:
+			redact(c, secret)
+		case i%2 == 1:
+			redact(c, secret || secretKeys[n.Content[i-1].Value])
+		}
+	}
+}
+
+var featureName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Method to check that experimental features are named like anomaly_detector,
+// each of them once. Whether this build has them is left to autoping, which
+// ignores those it doesn't
+func (cfg *Config) checkExperimental() error {
+	seen := make(map[string]bool)
+	for i, name := range cfg.Experimental {
+		if !featureName.MatchString(name) {
+			return cfg.at(fmt.Errorf("experimental feature %q must be lower case letters, digits and underscores", name), "experimental", i)
+		}
+		if seen[name] {
+			return cfg.at(fmt.Errorf("experimental feature %q is listed twice", name), "experimental", i)
+		}
+		seen[name] = true
+	}
+	return nil
+}
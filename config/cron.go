@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cron is a cron expression of five fields - minute, hour, day of the month,
+// month and day of the week - such as "0 3 * * *" for 03:00 every day. Each
+// field is *, a number, a range such as 1-5, any of those followed by a step
+// such as */15, or a list of them separated by commas. Sunday is 0 or 7
+type Cron struct {
+	expr   string
+	fields [5]uint64 // Values allowed in each field, as bits
+	anyDay [2]bool   // Whether the day of the month and of the week are *
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of the month", 1, 31},
+	{"month", 1, 12},
+	{"day of the week", 0, 7},
+}
+
+func (c *Cron) UnmarshalText(text []byte) error {
+	fields := strings.Fields(string(text))
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("%q isn't a cron expression of 5 fields like \"0 3 * * *\"", text)
+	}
+	c.expr = string(text)
+	for i, field := range fields {
+		bits, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return fmt.Errorf("%s of %q: %v", cronFields[i].name, text, err)
+		}
+		c.fields[i] = bits
+	}
+	// Sunday can be 0 or 7
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1
+	}
+	c.anyDay = [2]bool{fields[2] == "*", fields[4] == "*"}
+	return nil
+}
+
+func (c *Cron) UnmarshalYAML(n *yaml.Node) error {
+	if err := c.UnmarshalText([]byte(n.Value)); err != nil {
+		return fmt.Errorf("line %d: %v", n.Line, err)
+	}
+	return nil
+}
+
+func (c Cron) String() string {
+	return c.expr
+}
+
+// Returns the values allowed by one field of a cron expression, as bits
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("step %q must be a number of 1 or more", part[i+1:])
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("%q isn't a number", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("%q isn't a number", bounds[1])
+				}
+			} else if step > 1 {
+				hi = max // 5/15 means from 5 on, every 15
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q must be within %d-%d", rng, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Matches returns true if the minute of the supplied time is one the
+// expression allows. As in cron, when both the day of the month and the day
+// of the week are restricted, either of them matching is enough
+func (c Cron) Matches(t time.Time) bool {
+	has := func(field, v int) bool { return c.fields[field]&(1<<uint(v)) != 0 }
+	if !has(0, t.Minute()) || !has(1, t.Hour()) || !has(3, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(2, t.Day()), has(4, int(t.Weekday()))
+	switch {
+	case c.anyDay[0] && c.anyDay[1]:
+		return true
+	case c.anyDay[0]:
+		return dow
+	case c.anyDay[1]:
+		return dom
+	}
+	return dom || dow
+}
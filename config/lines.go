@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Mistakes found by Check, after the file has been read, are reported with
+// the line of the file the setting is on, as those found while reading it
+// are. Settings are named by their path, the keys and list indexes leading
+// to them, such as reports.1.schedule or overrides.example.com.interval
+
+// Returns the path of the setting reached through the supplied keys and
+// indexes
+func settingPath(keys ...interface{}) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprint(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Method to return the supplied error with the line of the config file the
+// setting reached through the supplied keys is on. A setting that is missing
+// is put down to the line of the one it should have been under. Errors about
+// settings that didn't come from the file are returned as they are
+func (cfg *Config) at(err error, keys ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	for n := len(keys); n > 0; n-- {
+		if line, ok := cfg.lines[settingPath(keys[:n]...)]; ok {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return err
+}
+
+// Returns the line of every setting in the supplied YAML document, by path
+func yamlLines(data []byte) map[string]int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	lines := make(map[string]int)
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		join := func(k string) string {
+			if len(path) == 0 {
+				return k
+			}
+			return path + "." + k
+		}
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				p := join(n.Content[i].Value)
+				lines[p] = n.Content[i].Line
+				walk(n.Content[i+1], p)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				p := join(strconv.Itoa(i))
+				lines[p] = c.Line
+				walk(c, p)
+			}
+		}
+	}
+	walk(&doc, "")
+	return lines
+}
+
+// Returns the line of every setting in the supplied TOML document, by path.
+// The TOML package keeps the positions of keys to itself, so each of the keys
+// it read, which come in the order they are in the document, is looked for
+// from the line of the one before it
+func tomlLines(data string, md toml.MetaData) map[string]int {
+	src := strings.Split(data, "\n")
+	lines := make(map[string]int)
+	tables := make(map[string]int) // Entries of each array of tables so far
+	cursor := 0
+	for _, key := range md.Keys() {
+		var path []interface{}
+		for i := range key {
+			path = append(path, key[i])
+			if n, ok := tables[key[:i+1].String()]; ok && i < len(key)-1 {
+				path = append(path, n-1)
+			}
+		}
+		if md.Type(key...) == "ArrayHash" {
+			n := tables[key.String()]
+			tables[key.String()] = n + 1
+			path = append(path, n)
+		}
+		for l := cursor; l < len(src); l++ {
+			if tomlDefines(src[l], key) {
+				cursor = l
+				lines[settingPath(path...)] = l + 1
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// Returns true if the supplied line of a TOML document starts the table of
+// the supplied key or sets it
+func tomlDefines(line string, key toml.Key) bool {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") {
+		header := strings.TrimSpace(strings.Trim(strings.SplitN(line, "#", 2)[0], "[] \t"))
+		return header == key.String()
+	}
+	name := key[len(key)-1]
+	for _, k := range []string{name, strconv.Quote(name), "'" + name + "'"} {
+		for i := strings.Index(line, k); i >= 0; {
+			rest := strings.TrimLeft(line[i+len(k):], " \t")
+			before := strings.TrimRight(line[:i], " \t")
+			if (strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ".")) &&
+				(len(before) == 0 || strings.HasSuffix(before, "{") || strings.HasSuffix(before, ",") ||
+					strings.HasSuffix(before, ".")) {
+				return true
+			}
+			j := strings.Index(line[i+1:], k)
+			if j < 0 {
+				break
+			}
+			i += 1 + j
+		}
+	}
+	return false
+}
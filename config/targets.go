@@ -0,0 +1,130 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Targets is the list of targets in a targets file, which another system can
+// write for a fleet. Each line is a target, optionally followed by settings of
+// its own as key=value pairs with the keys of the config file's overrides and
+// sample, e.g. "192.168.1.1 interval=5s sample=10". Blank lines and lines
+// starting with # are skipped. Latency tiers can only be overridden in the
+// config file
+type Targets struct {
+	Addrs    []string                  // Targets, in the order they are listed
+	Settings map[string]TargetSettings // Settings of targets whose lines have any, by address
+}
+
+// TargetSettings are the settings a target can be given on its line of a
+// targets file. Those that aren't given are zero
+type TargetSettings struct {
+	Override `yaml:",inline"`
+	Sample   int `yaml:"sample"` // Log 1 in this many pongs
+}
+
+// Keys of the settings that can be given on a line of a targets file
+var targetKeys = settingKeys(reflect.TypeOf(TargetSettings{}))
+
+// Returns the YAML keys of the fields of the supplied struct type, including
+// those of inline structs, leaving out latency tiers
+func settingKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("yaml")
+		if tag == ",inline" {
+			for key := range settingKeys(t.Field(i).Type) {
+				keys[key] = true
+			}
+		} else if tag != "latency" {
+			keys[tag] = true
+		}
+	}
+	return keys
+}
+
+// LoadTargets reads the supplied targets file
+func LoadTargets(path string) (*Targets, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ts := &Targets{Settings: make(map[string]TargetSettings)}
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		addr := fields[0]
+		if seen[addr] {
+			return nil, fmt.Errorf("%s: line %d: %s is listed twice", path, line, addr)
+		}
+		seen[addr] = true
+		ts.Addrs = append(ts.Addrs, addr)
+		if len(fields) == 1 {
+			continue
+		}
+		settings, err := parseTargetSettings(fields[1:], line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		ts.Settings[addr] = settings
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	cfg := Config{Overrides: make(map[string]Override)}
+	for addr, settings := range ts.Settings {
+		cfg.Overrides[addr] = settings.Override
+	}
+	if err := cfg.checkOverrides(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ts, nil
+}
+
+// Returns the settings given by the supplied key=value pairs from the
+// supplied line of a targets file. They are decoded as the same settings of
+// the config file would be, so they take the same values
+func parseTargetSettings(options []string, line int) (TargetSettings, error) {
+	var settings TargetSettings
+	m := &yaml.Node{Kind: yaml.MappingNode, Line: line}
+	given := make(map[string]bool)
+	for _, option := range options {
+		i := strings.IndexByte(option, '=')
+		if i < 1 || i == len(option)-1 {
+			return settings, fmt.Errorf("line %d: %q isn't a setting like interval=5s", line, option)
+		}
+		key, value := option[:i], option[i+1:]
+		if !targetKeys[key] {
+			return settings, fmt.Errorf("line %d: unknown setting %q", line, key)
+		}
+		if given[key] {
+			return settings, fmt.Errorf("line %d: %s is given twice", line, key)
+		}
+		given[key] = true
+		m.Content = append(m.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: key, Line: line},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value, Line: line})
+	}
+	if err := m.Decode(&settings); err != nil {
+		if te, ok := err.(*yaml.TypeError); ok {
+			return settings, fmt.Errorf("%s", strings.Join(te.Errors, "; "))
+		}
+		return settings, err
+	}
+	if given["sample"] && settings.Sample < 1 {
+		return settings, fmt.Errorf("line %d: sample %d must be 1 or more", line, settings.Sample)
+	}
+	return settings, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Mistakes the config file is checked for once it has been read are reported
+// with the line they are on, in YAML and TOML alike
+func TestConfigErrorLines(t *testing.T) {
+	for _, c := range []struct {
+		name, text, want string
+	}{
+		{"report.yaml", "targets: [192.0.2.1]\nsmtp:\n  server: mail:25\n  from: a@example.com\nreports:\n" +
+			"  - name: daily\n    to: [b@example.com]\n    schedule: daily\n" +
+			"  - name: weekly\n    to: [b@example.com]\n    schedule: fortnightly\n",
+			"line 11: report weekly has schedule"},
+		{"missing.yaml", "targets: [192.0.2.1]\nmaintenance:\n  - schedule: \"0 3 * * *\"\n    duration: 1h\n" +
+			"  - schedule: \"0 4 * * *\"\n",
+			"line 5: maintenance window 2 has no duration"},
+		{"override.yaml", "targets: [192.0.2.1]\noverrides:\n  192.0.2.1:\n    method: POST\n",
+			"line 4: method of 192.0.2.1 is POST"},
+		{"report.toml", "targets = [\"192.0.2.1\"]\n[smtp]\nserver = \"mail:25\"\nfrom = \"a@example.com\"\n" +
+			"[[reports]]\nname = \"daily\"\nto = [\"b@example.com\"]\nschedule = \"daily\"\n" +
+			"[[reports]]\nname = \"weekly\"\nto = [\"b@example.com\"]\nschedule = \"fortnightly\"\n",
+			"line 12: report weekly has schedule"},
+		{"labels.toml", "targets = [\"192.0.2.1\"]\n\n[labels]\n\"192.0.2.1\" = { site = \"a b\" }\n",
+			"line 4: label site of 192.0.2.1"},
+		{"override.toml", "targets = [\"192.0.2.1\"]\n[overrides.\"192.0.2.1\"]\ninterval = \"5s\"\nmirror = \"ftp://x\"\n",
+			"line 4: mirror of 192.0.2.1"},
+	} {
+		path := filepath.Join(t.TempDir(), c.name)
+		if err := ioutil.WriteFile(path, []byte(c.text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), path+": "+c.want) {
+			t.Errorf("%s: got error %v, want %s", c.name, err, c.want)
+		}
+	}
+}
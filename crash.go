@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// autoping mostly runs on headless boxes nobody watches, so when it panics
+// what went wrong has to be kept somewhere it can be found later. A crash
+// report - the panic, the stacks of every goroutine, the settings with their
+// secrets left out and the last log lines before it - is written as JSON to
+// the directory given with -crash-dir or under crash in the config file, and
+// posted to the url set there too, if any. A panic in a ping is recovered and
+// the pings carry on, and one in serving a request is left to net/http, which
+// drops the connection and goes on serving. Anywhere else autoping stops once
+// the report is out
+
+const recentLineCount = 100 // Log lines kept for crash reports
+
+var crashDirFlag = flag.String("crash-dir", "", "directory a report is written to when autoping panics")
+
+var (
+	crashMu      sync.Mutex
+	crashCfg     config.Crash // Where crash reports go
+	crashConfig  string       // Settings with their secrets left out, as YAML
+	crashClient  = &http.Client{Timeout: 10 * time.Second}
+	recentLogged = &recentLines{}
+)
+
+// A crash report
+type crashReport struct {
+	Time       time.Time `json:"time"`
+	Version    string    `json:"version"`
+	GoVersion  string    `json:"go_version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Where      string    `json:"where"` // Target whose ping panicked, or what else did
+	Fatal      bool      `json:"fatal"` // Did autoping stop?
+	Panic      string    `json:"panic"`
+	Stack      string    `json:"stack"`      // Stack of the goroutine that panicked
+	Goroutines string    `json:"goroutines"` // Stacks of every goroutine
+	Config     string    `json:"config"`     // Settings, without secrets
+	Flags      []string  `json:"flags"`      // Flags given, without values that may be secret
+	Recent     []string  `json:"recent"`     // Log lines before the panic, oldest first
+}
+
+// Set where crash reports go and the settings they carry from the supplied
+// config. -crash-dir wins over the config file
+func setCrash(cfg *config.Config) {
+	summary, err := cfg.Redacted()
+	if err != nil {
+		summary = fmt.Sprintf("can't be shown: %v", err)
+	}
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	crashCfg, crashConfig = cfg.Crash, summary
+	if len(*crashDirFlag) > 0 {
+		crashCfg.Dir = *crashDirFlag
+	}
+}
+
+// Write a crash report if the goroutine this is deferred in panics, saying it
+// happened in the supplied place, then let the panic carry on
+func crashed(where string) {
+	if r := recover(); r != nil {
+		reportCrash(where, r, debug.Stack(), true)
+		panic(r)
+	}
+}
+
+// Wrap the supplied handler so a panic in it is reported, then passed on to
+// net/http. Aborted handlers panic on purpose, so they aren't reported
+func crashReporting(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					reportCrash("serving "+r.URL.Path, p, debug.Stack(), false)
+				}
+				panic(p)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Write a report of the supplied panic in the supplied place to the crash
+// directory and post it to the crash url, if either is set. fatal says
+// whether autoping is about to stop
+func reportCrash(where string, r interface{}, stack []byte, fatal bool) {
+	crashMu.Lock()
+	c, summary := crashCfg, crashConfig
+	crashMu.Unlock()
+	if len(c.Dir) == 0 && len(c.URL) == 0 {
+		return
+	}
+
+	all := make([]byte, 1<<20)
+	all = all[:runtime.Stack(all, true)]
+	report := crashReport{Time: time.Now(), Version: version, GoVersion: runtime.Version(), OS: runtime.GOOS,
+		Arch: runtime.GOARCH, Where: where, Fatal: fatal, Panic: fmt.Sprint(r), Stack: string(stack),
+		Goroutines: string(all), Config: summary, Flags: flagsGiven(), Recent: recentLogged.lines()}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		crashNote("Encoding crash report: %v", err)
+		return
+	}
+
+	if len(c.Dir) > 0 {
+		path := filepath.Join(c.Dir, "crash-"+report.Time.Format("20060102-150405")+".json")
+		if err := os.MkdirAll(c.Dir, 0700); err != nil {
+			crashNote("Writing crash report: %v", err)
+		} else if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			crashNote("Writing crash report: %v", err)
+		} else {
+			crashNote("Crash report written to %s", path)
+		}
+	}
+	if len(c.URL) > 0 {
+		if err := postCrash(c, data); err != nil {
+			crashNote("Posting crash report to %s: %v", c.URL, err)
+		} else {
+			crashNote("Crash report posted to %s", c.URL)
+		}
+	}
+}
+
+// Post the supplied crash report to the crash url
+func postCrash(c config.Crash, data []byte) error {
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := crashClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// Log a line about a crash report, or write it to stderr if the loggers aren't
+// set up yet
+func crashNote(format string, v ...interface{}) {
+	if eLog != nil {
+		eLog.Printf(format, v...)
+	} else {
+		fmt.Fprintf(os.Stderr, format+"\n", v...)
+	}
+}
+
+// Returns the flags given on the command line. Values are left out of those
+// whose names say they may hold a secret
+func flagsGiven() []string {
+	var given []string
+	flag.Visit(func(f *flag.Flag) {
+		name := strings.ToLower(f.Name)
+		if strings.Contains(name, "token") || strings.Contains(name, "password") || strings.Contains(name, "secret") {
+			given = append(given, "-"+f.Name+"=REDACTED")
+		} else {
+			given = append(given, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return given
+}
+
+// Keeps the last log lines written to it. The slots are reused, so keeping a
+// line doesn't allocate once they have all been filled
+type recentLines struct {
+	mu    sync.Mutex
+	slots [recentLineCount][]byte
+	next  int  // Slot the next line goes in
+	full  bool // Have all the slots been filled?
+}
+
+func (rl *recentLines) Write(p []byte) (int, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.slots[rl.next] = append(rl.slots[rl.next][:0], p...)
+	rl.next = (rl.next + 1) % len(rl.slots)
+	rl.full = rl.full || rl.next == 0
+	return len(p), nil
+}
+
+// Method to return the lines kept, oldest first
+func (rl *recentLines) lines() []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	var lines []string
+	if rl.full {
+		for _, l := range rl.slots[rl.next:] {
+			lines = append(lines, strings.TrimSuffix(string(l), "\n"))
+		}
+	}
+	for _, l := range rl.slots[:rl.next] {
+		lines = append(lines, strings.TrimSuffix(string(l), "\n"))
+	}
+	return lines
+}
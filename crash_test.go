@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// A panic in serving a request is written to the crash directory, and still
+// reaches net/http
+func TestCrashReportingHandler(t *testing.T) {
+	dir := t.TempDir()
+	crashMu.Lock()
+	before := crashCfg
+	crashCfg.Dir = dir
+	crashMu.Unlock()
+	t.Cleanup(func() {
+		crashMu.Lock()
+		crashCfg = before
+		crashMu.Unlock()
+	})
+
+	h := crashReporting(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }))
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("panic %v passed on, not boom", p)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/badge/192.0.2.1", nil))
+	}()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("%d crash reports written", len(paths))
+	}
+	data, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report crashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Panic != "boom" || report.Where != "serving /badge/192.0.2.1" || report.Fatal {
+		t.Errorf("report of %q in %q, fatal %v", report.Panic, report.Where, report.Fatal)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+)
+
+// On Windows, outages, recoveries, blips and periods of flakey latency can
+// also be written to the Windows Event Log with -eventlog, so that enterprise
+// event collection picks them up. Each kind of event has its own event ID
+
+const eventSource = "autoping" // Event source the events are logged under
+
+const (
+	eventOutage   = 1001 // Lost contact with a target. Logged as an error
+	eventRestored = 1002 // Connection to a target restored. Logged as information
+	eventFlakey   = 1003 // Period of flakey latency finished. Logged as a warning
+	eventBlip     = 1004 // Missed pings that recovered in time. Logged as a warning
+)
+
+// Method to write an event about the target to the Windows Event Log, if it
+// was opened
+func (tg *target) event(id uint32, format string, v ...interface{}) {
+	if *eventLogFlag {
+		reportEvent(id, fmt.Sprintf("[%s] "+format, append([]interface{}{tg.tag()}, v...)...))
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+// The Windows Event Log only exists on Windows
+func openEventLog() error {
+	return errors.New("the Windows Event Log is only available on Windows")
+}
+
+func reportEvent(id uint32, msg string) {}
@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+var eventLog *eventlog.Log
+
+// Register autoping as an event source, if it isn't already, and open the
+// Windows Event Log. Registering needs administrator rights the first time
+func openEventLog() error {
+	err := eventlog.InstallAsEventCreate(eventSource, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil && !strings.Contains(err.Error(), "registry key already exists") {
+		return err
+	}
+	eventLog, err = eventlog.Open(eventSource)
+	return err
+}
+
+// Write the supplied message to the Windows Event Log with the severity that
+// goes with its event ID
+func reportEvent(id uint32, msg string) {
+	if eventLog == nil {
+		return
+	}
+	var err error
+	switch id {
+	case eventOutage:
+		err = eventLog.Error(id, msg)
+	case eventFlakey, eventBlip:
+		err = eventLog.Warning(id, msg)
+	default:
+		err = eventLog.Info(id, msg)
+	}
+	if err != nil {
+		eLog.Printf("Writing to the Windows Event Log: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// For those who would rather not parse the log or run a database, every log
+// line can also be written as a JSON object on a line of its own - its time,
+// level, target, the target's labels and message - to the event file set
+// under events in the config file. It is rotated like the files of classes of
+// log lines. Reports, the calendar, autoping incidents and the baselines read
+// history back from the event file when there is one, and from the log files
+// only what was logged before its first event, so nothing is lost when it is
+// set up on an existing installation
+
+var eventsFile config.LogFile // File events are written to. No file if not set
+
+// Log line as written to the event file
+type eventRecord struct {
+	Time   time.Time         `json:"time"`
+	Level  string            `json:"level"` // PING, OUTAGE, ERROR, TRACE, ACCESS or NOTE
+	Target string            `json:"target,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Msg    string            `json:"msg"`
+}
+
+// Writes each log line written to it to another writer as an event. Events
+// are appended to a buffer from the pool the log lines are built in rather
+// than made with json.Marshal, so writing them doesn't allocate either
+type eventWriter struct {
+	w io.Writer
+}
+
+func (ew eventWriter) Write(p []byte) (int, error) {
+	// The parts of the line are only used until Write returns, so it isn't
+	// copied to a string
+	l, ok := parseLogLine(strings.TrimSuffix(unsafe.String(unsafe.SliceData(p), len(p)), "\n"))
+	if !ok {
+		return len(p), nil
+	}
+	bp := logBufs.Get().(*[]byte)
+	b := appendEvent((*bp)[:0], eventRecord{Time: time.Now().In(timeZone), Level: l.prefix, Target: l.target,
+		Labels: currentSettings().labels[l.target], Msg: l.msg})
+	_, err := ew.w.Write(b)
+	*bp = b
+	logBufs.Put(bp)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Append the supplied event to b as a line of JSON, the same as json.Marshal
+// writes it
+func appendEvent(b []byte, ev eventRecord) []byte {
+	b = append(b, `{"time":"`...)
+	b = ev.Time.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, `","level":`...)
+	b = appendJSONString(b, ev.Level)
+	if len(ev.Target) > 0 {
+		b = append(b, `,"target":`...)
+		b = appendJSONString(b, ev.Target)
+	}
+	if len(ev.Labels) > 0 {
+		// In the order of their names, as json.Marshal writes maps. Targets
+		// have few labels, so the names fit on the stack
+		var names [8]string
+		keys := names[:0]
+		for k := range ev.Labels {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		b = append(b, `,"labels":{`...)
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, k)
+			b = append(b, ':')
+			b = appendJSONString(b, ev.Labels[k])
+		}
+		b = append(b, '}')
+	}
+	b = append(b, `,"msg":`...)
+	b = appendJSONString(b, ev.Msg)
+	return append(b, "}\n"...)
+}
+
+// Append the supplied string to b as a JSON string, escaped as json.Marshal
+// escapes it
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, `\b`...)
+			case '\f':
+				b = append(b, `\f`...)
+			case '\n':
+				b = append(b, `\n`...)
+			case '\r':
+				b = append(b, `\r`...)
+			case '\t':
+				b = append(b, `\t`...)
+			default:
+				if c < 0x20 || c == '<' || c == '>' || c == '&' {
+					b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+				} else {
+					b = append(b, c)
+				}
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, "\ufffd"...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, `\u202`...)
+			b = append(b, hex[r&0xf])
+		default:
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
+
+// Split the supplied event into the parts of a log line. Returns false if it
+// isn't one
+func parseEvent(text string) (logLine, bool) {
+	var ev eventRecord
+	if err := json.Unmarshal([]byte(text), &ev); err != nil || ev.Time.IsZero() || len(ev.Level) == 0 {
+		return logLine{}, false
+	}
+	return logLine{prefix: ev.Level, target: ev.Target, t: ev.Time.In(timeZone), msg: ev.Msg}, true
+}
+
+// Write the lines of the supplied loggers to the event file too, if there is
+// one. Returns the file, to be closed when autoping stops, or nil
+func openEvents(loggers ...*log.Logger) (io.Closer, error) {
+	if len(eventsFile.File) == 0 {
+		return nil, nil
+	}
+	rf, err := openRotating(eventsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range loggers {
+		setLogOutput(l, io.MultiWriter(logOutput(l), eventWriter{rf}))
+	}
+	return rf, nil
+}
+
+// Returns the supplied writer of log lines, writing them to the event file
+// too if there is one. For processes other than the running autoping, which
+// don't rotate it
+func withEvents(w io.Writer) (io.Writer, error) {
+	if len(eventsFile.File) == 0 {
+		return w, nil
+	}
+	f, err := openLog(eventsFile.File)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiWriter(w, eventWriter{f}), nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAppendEvent(t *testing.T) {
+	at := time.Date(2018, 6, 2, 10, 14, 0, 123456789, time.FixedZone("AEST", 10*60*60))
+	for _, ev := range []eventRecord{
+		{Time: at, Level: "PING", Msg: "Stopped"},
+		{Time: at.UTC(), Level: "OUTAGE", Target: "192.168.2.1", Msg: "Lost contact. Outage duration 2m0s"},
+		{Time: at, Level: "PING", Target: "example.jp", Labels: map[string]string{"site": "office", "link": "fibre",
+			"isp": "a&b"}, Msg: "32 bytes from 192.0.2.1: icmp_seq=0 time=12.3ms"},
+		{Time: at, Level: "ERROR", Target: `"quoted"\path`, Msg: "tab\there\nnew line\r\b\f\x01 <html>   "},
+		{Time: at, Level: "NOTE", Msg: "ünïcödé ✓ and bad \xff\xfe bytes"},
+	} {
+		want, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendEvent(nil, ev)); got != string(want)+"\n" {
+			t.Errorf("got  %s\nwant %s", got, want)
+		}
+	}
+}
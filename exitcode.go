@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Scripts and service managers can tell why autoping stopped from its exit
+// status. Anything that keeps it from starting that isn't a lack of permission
+// counts as a config error
+
+const (
+	exitOK          = 0 // Stopped cleanly, or every target answered with -once
+	exitConfig      = 1 // Bad flags or config, or anything else that kept it from running
+	exitPermission  = 2 // Not allowed to open a file or an ICMP socket
+	exitUnreachable = 3 // A target didn't answer with -once
+	exitOutage      = 4 // Stopped while an outage was still going
+)
+
+// An error that calls for exitPermission without wrapping a permission error,
+// such as a summary of checks some of which were denied
+type deniedError struct{ error }
+
+func (deniedError) Is(target error) bool {
+	return target == os.ErrPermission
+}
+
+// A mistake in the flags, which the flag package has already reported along
+// with the usage
+type usageError struct{ error }
+
+// Parse the supplied arguments with the supplied flag set. Returns a
+// usageError for a mistake in them, or flag.ErrHelp if -h was given
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		return usageError{err}
+	}
+	return err
+}
+
+// Report the supplied error, unless the flag package already has, and exit
+// with the status it calls for. Asking for help with -h isn't an error
+func exitAfter(err error) {
+	var ue usageError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(exitOK)
+	case !errors.As(err, &ue):
+		fmt.Println(err)
+	}
+	os.Exit(exitStatus(err))
+}
+
+// Returns the status to exit with after the supplied error
+func exitStatus(err error) int {
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EPERM) {
+		return exitPermission
+	}
+	return exitConfig
+}
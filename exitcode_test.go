@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	fs := flag.NewFlagSet("autoping test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Bool("once", false, "")
+
+	err := parseFlags(fs, []string{"-bogus"})
+	var ue usageError
+	if !errors.As(err, &ue) || exitStatus(err) != exitConfig {
+		t.Errorf("unknown flag: %v, status %d", err, exitStatus(err))
+	}
+	if err := parseFlags(fs, []string{"-h"}); err != flag.ErrHelp {
+		t.Errorf("-h: %v", err)
+	}
+	if err := parseFlags(fs, []string{"-once"}); err != nil {
+		t.Errorf("-once: %v", err)
+	}
+
+	denied := fmt.Errorf("reading config: %w", &os.PathError{Op: "open", Path: "autoping.yaml", Err: os.ErrPermission})
+	if status := exitStatus(denied); status != exitPermission {
+		t.Errorf("config that can't be read: status %d", status)
+	}
+}
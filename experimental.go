@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// New detectors can ship turned off, and be turned on per install by naming
+// them under experimental in the config file, e.g. "experimental:
+// [anomaly_detector]", without a build of their own. Each one is listed in
+// experiments and checks experimentOn before doing anything. Names this build
+// doesn't have are logged and ignored, so one config file can go to installs
+// running different versions. "autoping version" lists the experimental
+// features of a build
+
+// Experimental features of this build, with what each one does, by name. A
+// feature that is ready to be on for everyone leaves the list
+var experiments = map[string]string{}
+
+var (
+	experimentsMu sync.Mutex
+	experimentsOn map[string]bool // Experimental features turned on
+	experimentsNo []string        // Features turned on that this build doesn't have
+)
+
+// Turn on the experimental features named in the supplied config, and only
+// those
+func setExperiments(cfg *config.Config) {
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	experimentsOn, experimentsNo = make(map[string]bool), nil
+	for _, name := range cfg.Experimental {
+		if _, ok := experiments[name]; ok {
+			experimentsOn[name] = true
+		} else {
+			experimentsNo = append(experimentsNo, name)
+		}
+	}
+}
+
+// Returns true if the experimental feature with the supplied name is on
+func experimentOn(name string) bool {
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	return experimentsOn[name]
+}
+
+// Log which experimental features are on, and those turned on that this
+// build doesn't have
+func logExperiments() {
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	var on []string
+	for name := range experimentsOn {
+		on = append(on, name)
+	}
+	sort.Strings(on)
+	if len(on) > 0 {
+		pLog.Printf("Experimental features on: %s", strings.Join(on, ", "))
+	}
+	for _, name := range experimentsNo {
+		eLog.Printf("Experimental feature %s isn't in this build of autoping, ignoring it", name)
+	}
+}
+
+// Returns the names of the experimental features of this build, sorted
+func experimentNames() []string {
+	var names []string
+	for name := range experiments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
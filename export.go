@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// "autoping export" writes the pings and incidents in the log file to files
+// for analysing long stretches of history in pandas, Polars or DuckDB, where
+// "autoping report" sums them up instead. With -format parquet, the default
+// and so far the only format, samples.parquet gets a row for every pong,
+// missed pong and failed ping in the log, and incidents.parquet one for every
+// outage, blip and period of flakey latency. Pongs that were sampled out of
+// the log are only in it as a count, so they aren't exported
+
+// Write the pings and incidents logged between the days given in the supplied
+// arguments to files in the directory given with -dir. If targets are given,
+// only theirs are written
+func exportCommand(args []string) error {
+	fs := subcommandFlags("export", "i", "log", "c", "interval", "profile", "locale", "timezone")
+	format := fs.String("format", "parquet", "format of the files. Only parquet so far")
+	fromFlag := fs.String("from", "", "first day to cover, e.g. 2018-06-01. The start of the log if not set")
+	toFlag := fs.String("to", "", "last day to cover, e.g. 2018-06-30. Today if not set")
+	dirFlag := fs.String("dir", ".", "directory to write samples.parquet and incidents.parquet to")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if err := configure(fs); err != nil {
+		return err
+	}
+	if *format != "parquet" {
+		return fmt.Errorf("unknown export format '%s'. Use parquet", *format)
+	}
+	today := startOfDay(time.Now())
+	from, to, err := dayRange(*fromFlag, *toFlag, time.Time{}, today.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	only := currentSettings().targets
+
+	// A sample without an RTT was lost, and a failure says why
+	sTime := newColumn("time", pqInt64, pqTimestampMillis, false)
+	sTarget := newColumn("target", pqByteArray, pqUTF8, true)
+	sRtt := newColumn("rtt_ms", pqDouble, pqNone, true)
+	sLost := newColumn("lost", pqBoolean, pqNone, false)
+	sFailure := newColumn("failure", pqByteArray, pqUTF8, true)
+	addSample := func(t time.Time, name string, rtt time.Duration, failure string) {
+		sTime.addInt64(t.UnixNano() / int64(time.Millisecond))
+		addOptString(sTarget, name)
+		if len(failure) == 0 {
+			sRtt.addDouble(ms(rtt))
+		} else {
+			sRtt.addNull()
+		}
+		sLost.addBool(len(failure) > 0)
+		addOptString(sFailure, failure)
+	}
+
+	iTarget := newColumn("target", pqByteArray, pqUTF8, true)
+	iKind := newColumn("kind", pqByteArray, pqUTF8, false)
+	iStart := newColumn("start", pqInt64, pqTimestampMillis, false)
+	iEnd := newColumn("end", pqInt64, pqTimestampMillis, false)
+	iDuration := newColumn("duration_s", pqDouble, pqNone, false)
+
+	err = scanLog(*logFlag, func(prefix, name string, t time.Time, msg string) {
+		if t.Before(from) || !t.Before(to) || !inTargets(name, only) {
+			return
+		}
+		switch {
+		case prefix == "PING" && pongLine.MatchString(msg):
+			if rtt, err := time.ParseDuration(pongLine.FindStringSubmatch(msg)[1]); err == nil {
+				addSample(t, name, rtt, "")
+			}
+			return
+		case prefix == "OUTAGE" && msg == "Timeout - Missed pong":
+			addSample(t, name, 0, failTimeout)
+			return
+		case prefix == "ERROR" && failedLine.MatchString(msg):
+			addSample(t, name, 0, failedLine.FindStringSubmatch(msg)[1])
+			return
+		case prefix != "OUTAGE":
+			return
+		}
+		inc, ok := parseIncident(msg, t)
+		if m := blipLine.FindStringSubmatch(msg); !ok && m != nil {
+			d, err := time.ParseDuration(m[1])
+			inc, ok = incident{kind: "Blip", start: t.Add(-d), end: t}, err == nil
+		}
+		if !ok {
+			return
+		}
+		addOptString(iTarget, name)
+		iKind.addString(inc.kind)
+		iStart.addInt64(inc.start.UnixNano() / int64(time.Millisecond))
+		iEnd.addInt64(inc.end.UnixNano() / int64(time.Millisecond))
+		iDuration.addDouble(inc.end.Sub(inc.start).Seconds())
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*dirFlag, 0755); err != nil {
+		return err
+	}
+	samples := filepath.Join(*dirFlag, "samples.parquet")
+	if err := createParquet(samples, []*pqColumn{sTime, sTarget, sRtt, sLost, sFailure}); err != nil {
+		return err
+	}
+	incidents := filepath.Join(*dirFlag, "incidents.parquet")
+	if err := createParquet(incidents, []*pqColumn{iTarget, iKind, iStart, iEnd, iDuration}); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d samples to %s and %d incidents to %s\n", len(sTime.present), samples,
+		len(iKind.present), incidents)
+	return nil
+}
+
+// Add the supplied string to the supplied column, or a null if it is empty
+func addOptString(c *pqColumn, s string) {
+	if len(s) == 0 {
+		c.addNull()
+	} else {
+		c.addString(s)
+	}
+}
+
+// Write a Parquet file holding the supplied columns to the supplied path
+func createParquet(path string, cols []*pqColumn) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeParquet(f, cols)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/kurankat/autoping-go/client"
+)
+
+// Every failed ping is put in one of a few categories, so the log, the status
+// page and the reports can say why pings failed instead of just that they did
+
+// The categories are those of the API, so what is counted and what the API
+// says is counted can't drift apart
+const (
+	failDNS         = client.FailDNS
+	failSocket      = client.FailSocket
+	failPermission  = client.FailPermission
+	failTimeout     = client.FailTimeout
+	failUnreachable = client.FailUnreachable
+	failHTTP        = client.FailHTTP
+	failMail        = client.FailMail
+	failUDP         = client.FailUDP
+	failSIP         = client.FailSIP
+	failTLS         = client.FailTLS
+	failQUIC        = client.FailQUIC
+)
+
+// Failure categories in the order they are reported
+var failCategories = func() []string {
+	var cs []string
+	for _, c := range client.FailureCategories {
+		cs = append(cs, c.Name)
+	}
+	return cs
+}()
+
+// Returns the category of the supplied ping error
+func classifyFailure(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return failDNS
+	case os.IsPermission(err) || errors.Is(err, syscall.EPERM):
+		return failPermission
+	case errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH):
+		return failUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return failTimeout
+	}
+	return failSocket
+}
+
+// Returns true if failures of the supplied category say something about the
+// connection, rather than about the machine autoping runs on
+func countsAsMissed(category string) bool {
+	return category != failSocket && category != failPermission
+}
+
+type failureCounter struct {
+	mu     sync.Mutex
+	counts map[string]int // Failed pings by category since startup
+}
+
+// Method to count a failed ping of the supplied category
+func (fc *failureCounter) add(category string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.counts[category]++
+}
+
+// Method to return a copy of the counts, with every category present
+func (fc *failureCounter) snapshot() map[string]int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	counts := make(map[string]int)
+	for _, c := range failCategories {
+		counts[c] = fc.counts[c]
+	}
+	return counts
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Recent incidents are also served as an Atom feed at /feed.atom, so anyone
+// interested can subscribe with a feed reader
+
+const feedLength = 50 // Maximum number of incidents in the feed
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string `xml:"title"`
+	ID      string `xml:"id"`
+	Updated string `xml:"updated"`
+	Summary string `xml:"summary"`
+}
+
+// Serve the most recent incidents as an Atom feed, newest first
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	tgs := requestTargets(r)
+	if len(tgs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now()
+	feed := atomFeed{
+		Title:   "autoping incidents for " + strings.Join(targetNames(tgs), ", "),
+		ID:      "tag:autoping," + tgs[0].hist.start.In(timeZone).Format("2006-01-02") + ":" + strings.Join(targetNames(tgs), ","),
+		Updated: now.In(timeZone).Format(time.RFC3339),
+		Author:  "autoping",
+	}
+
+	incs := allIncidents(tgs)
+	for i := len(incs) - 1; i >= 0 && len(feed.Entries) < feedLength; i-- {
+		inc := incs[i]
+		entry := atomEntry{
+			Title: inc.kind + " of " + inc.target,
+			ID:    fmt.Sprintf("tag:autoping,%s:%s", inc.start.In(timeZone).Format("2006-01-02"), incidentID(inc)),
+		}
+		if inc.end.IsZero() {
+			entry.Title += " (ongoing)"
+			entry.Updated = now.In(timeZone).Format(time.RFC3339)
+			entry.Summary = fmt.Sprintf("Started %s. Ongoing for %s",
+				lc.timestamp(inc.start), lc.duration(now.Sub(inc.start)))
+		} else {
+			entry.Updated = inc.end.In(timeZone).Format(time.RFC3339)
+			entry.Summary = fmt.Sprintf("Started %s, finished %s. Duration %s",
+				lc.timestamp(inc.start), lc.timestamp(inc.end), lc.duration(inc.end.Sub(inc.start)))
+		}
+		if spark := incidentSparkline(inc); len(strings.TrimSpace(spark)) > 0 {
+			entry.Summary += "\nRTT from an hour before to an hour after: " + spark
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		eLog.Printf("Writing feed: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// "autoping incidents" browses the incidents in the log file from the
+// terminal. Incidents are listed newest first, narrowed down by typing part of
+// their target or kind, and opened to see their timeline, the RTTs around them
+// and their notes, all with single keys as tui.go reads them. When stdin isn't
+// a terminal, as in scripts, commands are read a line at a time instead and
+// incidents are picked by number. Acknowledgements and notes are appended to
+// the log file, or the outage file if there is one, as NOTE lines, so the
+// running autoping, which writes to the same file, and later browsing
+// sessions see them too
+
+var (
+	lostLine = regexp.MustCompile(`^Lost contact\. Outage duration (\S+)$`)
+	ackLine  = regexp.MustCompile(`^Acknowledged (\S+)$`)
+	noteLine = regexp.MustCompile(`^Note on (\S+): (.*)$`)
+)
+
+const timelineLength = 30 // Most log lines shown in the timeline of an incident
+
+type browsedIncident struct {
+	incident
+	acked bool
+	notes []string // Notes with the time they were written
+}
+
+type annotation struct {
+	target string
+	key    string    // Key of the incident, as returned by incidentKey
+	at     time.Time // Time the annotation was logged
+	note   string    // Text of the note. Empty for an acknowledgement
+}
+
+// Return an identifier for the incident among those of its target
+func incidentKey(inc incident) string {
+	return fmt.Sprintf("%s-%d", kindSlug(inc.kind), inc.start.Unix())
+}
+
+// Return the supplied kind of incident in lower case, with dashes for spaces
+func kindSlug(kind string) string {
+	return strings.ToLower(strings.Replace(kind, " ", "-", -1))
+}
+
+// Method to report whether the incident is the one the supplied key was
+// written for. Incidents still going when the key was written had their start
+// worked out from the time of the last missed ping, so it is allowed to be a
+// couple of ping intervals off
+func (bi *browsedIncident) matches(target, key string) bool {
+	i := strings.LastIndex(key, "-")
+	if i < 0 || target != bi.target {
+		return false
+	}
+	unix, err := strconv.ParseInt(key[i+1:], 10, 64)
+	if err != nil || key[:i] != kindSlug(bi.kind) {
+		return false
+	}
+	d := bi.start.Sub(time.Unix(unix, 0))
+	interval := currentSettings().interval
+	return d <= 2*interval && d >= -2*interval
+}
+
+// Read the incidents in the supplied log file, oldest first, with their
+// acknowledgements and notes. Outages that haven't finished are included. If
+// any targets are supplied, only their incidents are read
+func loadIncidents(path string, only []string) ([]*browsedIncident, error) {
+	var incs []*browsedIncident
+	var notes []annotation
+	open := make(map[string]*browsedIncident) // Outages still going, by target
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if !inTargets(name, only) {
+			return
+		}
+		switch {
+		case prefix == "NOTE" && ackLine.MatchString(msg):
+			notes = append(notes, annotation{target: name, key: ackLine.FindStringSubmatch(msg)[1], at: t})
+		case prefix == "NOTE" && noteLine.MatchString(msg):
+			m := noteLine.FindStringSubmatch(msg)
+			notes = append(notes, annotation{target: name, key: m[1], at: t, note: m[2]})
+		case prefix == "OUTAGE" && lostLine.MatchString(msg):
+			if open[name] == nil {
+				d, err := time.ParseDuration(lostLine.FindStringSubmatch(msg)[1])
+				if err == nil {
+					open[name] = &browsedIncident{incident: incident{target: name, kind: "Outage", start: t.Add(-d)}}
+				}
+			}
+		case prefix == "OUTAGE":
+			if inc, ok := parseIncident(msg, t); ok {
+				inc.target = name
+				incs = append(incs, &browsedIncident{incident: inc})
+				if inc.kind == "Outage" {
+					delete(open, name)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, bi := range open {
+		incs = append(incs, bi)
+	}
+
+	for _, a := range notes {
+		for i := len(incs) - 1; i >= 0; i-- {
+			if incs[i].matches(a.target, a.key) {
+				if len(a.note) == 0 {
+					incs[i].acked = true
+				} else {
+					incs[i].notes = append(incs[i].notes, lc.timestamp(a.at)+"  "+a.note)
+				}
+				break
+			}
+		}
+	}
+	return incs, nil
+}
+
+// Read the log lines about the supplied incident's target from shortly before
+// it started to shortly after it finished, and its RTTs from an hour before to
+// an hour after
+func incidentDetail(path string, inc incident) ([]string, *pingHistory, error) {
+	end := inc.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	var timeline []string
+	hist := &pingHistory{}
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if name != inc.target {
+			return
+		}
+		switch {
+		case prefix == "PING" && pongLine.MatchString(msg):
+			rtt, err := time.ParseDuration(pongLine.FindStringSubmatch(msg)[1])
+			if err == nil && t.After(inc.start.Add(-time.Hour)) && t.Before(end.Add(time.Hour)) {
+				hist.record(t, true, rtt)
+			}
+			return
+		case prefix == "OUTAGE" && msg == "Timeout - Missed pong":
+			if t.After(inc.start.Add(-time.Hour)) && t.Before(end.Add(time.Hour)) {
+				hist.record(t, false, 0)
+			}
+		case prefix != "OUTAGE" && prefix != "ERROR" && prefix != "NOTE":
+			return
+		}
+		if !t.Before(inc.start.Add(-5*time.Minute)) && !t.After(end.Add(5*time.Minute)) {
+			timeline = append(timeline, fmt.Sprintf("%s %-6s %s", t.Format("15:04:05"), prefix, msg))
+		}
+	})
+	return timeline, hist, err
+}
+
+// Browse the incidents in the log file given in the supplied arguments, with
+// keys if stdin is a terminal, or else reading commands from it a line at a
+// time
+func incidentsCommand(args []string) error {
+	fs := subcommandFlags("incidents", "i", "log", "c", "interval", "profile", "locale", "timezone")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if err := configure(fs); err != nil {
+		return err
+	}
+	incs, err := loadIncidents(*logFlag, currentSettings().targets)
+	if err != nil {
+		return err
+	}
+
+	b := &browser{out: os.Stdout, incs: incs}
+	if restore, err := rawTerminal(os.Stdin); err == nil {
+		defer restore()
+		rows, cols := terminalSize(os.Stdout)
+		return browseKeys(b, os.Stdin, os.Stdout, rows, cols)
+	}
+	b.list()
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(b.out, "> ")
+		if !in.Scan() {
+			fmt.Fprintln(b.out)
+			return in.Err()
+		}
+		if quit := b.do(strings.TrimSpace(in.Text())); quit {
+			return nil
+		}
+	}
+}
+
+type browser struct {
+	out        io.Writer
+	incs       []*browsedIncident // Every incident, oldest first
+	filter     string             // Only incidents whose target or kind contain this are listed
+	unacked    bool               // Only unacknowledged incidents are listed
+	shown      []*browsedIncident // Incidents in the last list, by number
+	noteLogger *log.Logger        // Writes NOTE lines. Opened on first use
+}
+
+// Method to carry out the supplied command. Returns true to quit
+func (b *browser) do(cmd string) bool {
+	word, rest := cmd, ""
+	if i := strings.IndexByte(cmd, ' '); i > 0 {
+		word, rest = cmd[:i], strings.TrimSpace(cmd[i+1:])
+	}
+	switch {
+	case len(cmd) == 0 || cmd == "l":
+		b.list()
+	case cmd == "q":
+		return true
+	case cmd == "?" || cmd == "h":
+		fmt.Fprintln(b.out, `Commands:
+  <n>           show incident n
+  a <n>         acknowledge incident n
+  n <n> <text>  add a note to incident n
+  /<text>       only list incidents whose target or kind contain text, / for all
+  u             only list unacknowledged incidents, or all again
+  l             list the incidents again
+  q             quit`)
+	case strings.HasPrefix(cmd, "/"):
+		b.filter = strings.ToLower(strings.TrimPrefix(cmd, "/"))
+		b.list()
+	case cmd == "u":
+		b.unacked = !b.unacked
+		b.list()
+	case word == "a" || word == "n":
+		num := rest
+		if word == "n" {
+			num = strings.SplitN(rest, " ", 2)[0]
+		}
+		bi := b.pick(num)
+		if bi == nil {
+			return false
+		}
+		if word == "a" {
+			fmt.Fprintln(b.out, b.annotate(bi, ""))
+		} else if note := strings.TrimSpace(strings.TrimPrefix(rest, num)); len(note) > 0 {
+			fmt.Fprintln(b.out, b.annotate(bi, note))
+		} else {
+			fmt.Fprintln(b.out, "Usage: n <n> <text>")
+		}
+	default:
+		if bi := b.pick(cmd); bi != nil {
+			b.detail(b.out, bi)
+		}
+	}
+	return false
+}
+
+// Method to work out the incidents that pass the filters, newest first
+func (b *browser) refresh() {
+	b.shown = nil
+	for i := len(b.incs) - 1; i >= 0; i-- {
+		bi := b.incs[i]
+		text := strings.ToLower(bi.target + " " + bi.kind)
+		if strings.Contains(text, b.filter) && !(b.unacked && bi.acked) {
+			b.shown = append(b.shown, bi)
+		}
+	}
+}
+
+// Method to list the incidents that pass the filters, newest first
+func (b *browser) list() {
+	b.refresh()
+	if len(b.shown) == 0 {
+		fmt.Fprintln(b.out, "No incidents. ? for help")
+		return
+	}
+	for n, bi := range b.shown {
+		fmt.Fprintf(b.out, "%3d %s\n", n+1, b.row(bi))
+	}
+	fmt.Fprintln(b.out, "? for help")
+}
+
+// Method to return the line the supplied incident is listed as
+func (b *browser) row(bi *browsedIncident) string {
+	mark := " "
+	if bi.acked {
+		mark = "✓"
+	}
+	return fmt.Sprintf("%s %-20s %-24s %-22s %s", mark, bi.target, bi.kind, lc.timestamp(bi.start), b.duration(bi))
+}
+
+// Method to return the duration of the supplied incident for display
+func (b *browser) duration(bi *browsedIncident) string {
+	if bi.end.IsZero() {
+		return "ongoing for " + lc.duration(time.Since(bi.start))
+	}
+	return lc.duration(bi.end.Sub(bi.start))
+}
+
+// Method to return the incident with the supplied number in the last list, or
+// nil after saying why there is none
+func (b *browser) pick(num string) *browsedIncident {
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		fmt.Fprintln(b.out, "Unknown command. ? for help")
+		return nil
+	}
+	if n < 1 || n > len(b.shown) {
+		fmt.Fprintf(b.out, "No incident %d\n", n)
+		return nil
+	}
+	return b.shown[n-1]
+}
+
+// Method to write the detail of the supplied incident to w
+func (b *browser) detail(w io.Writer, bi *browsedIncident) {
+	timeline, hist, err := incidentDetail(*logFlag, bi.incident)
+	if err != nil {
+		fmt.Fprintln(w, "Reading the log file:", err)
+		return
+	}
+	fmt.Fprintf(w, "%s of %s\n", bi.kind, bi.target)
+	fmt.Fprintf(w, "  Started       %s\n", lc.timestamp(bi.start))
+	to := time.Now()
+	if !bi.end.IsZero() {
+		fmt.Fprintf(w, "  Finished      %s\n", lc.timestamp(bi.end))
+		to = bi.end.Add(time.Hour)
+	}
+	fmt.Fprintf(w, "  Duration      %s\n", b.duration(bi))
+	fmt.Fprintf(w, "  Acknowledged  %v\n", bi.acked)
+	if spark := hist.sparkline(bi.start.Add(-time.Hour), to); len(strings.TrimSpace(spark)) > 0 {
+		fmt.Fprintf(w, "  RTT           %s\n", spark)
+	}
+	fmt.Fprintln(w, "Timeline:")
+	for i, line := range timeline {
+		if i == timelineLength {
+			fmt.Fprintf(w, "  ... %d more lines\n", len(timeline)-i)
+			break
+		}
+		fmt.Fprintln(w, "  "+line)
+	}
+	if len(bi.notes) > 0 {
+		fmt.Fprintln(w, "Notes:")
+		for _, note := range bi.notes {
+			fmt.Fprintln(w, "  "+note)
+		}
+	}
+}
+
+// Method to acknowledge the supplied incident, or add the supplied note to it,
+// writing a NOTE line to the log file. Returns what was done, or why it
+// couldn't be
+func (b *browser) annotate(bi *browsedIncident, note string) string {
+	if b.noteLogger == nil {
+		// Notes go with the outages, in the outage file if there is one
+		path := logFileFor("outage")
+		if logToStream(path) {
+			return "The log is written to " + path + " so notes can't be added to it"
+		}
+		f, err := openLog(path)
+		if err != nil {
+			return "Opening the log file: " + err.Error()
+		}
+		w, err := withEvents(f)
+		if err != nil {
+			return "Opening the event file: " + err.Error()
+		}
+		b.noteLogger = newLogger(w, "NOTE - ")
+	}
+	if len(note) == 0 {
+		b.noteLogger.Printf("[%s] Acknowledged %s", bi.target, incidentKey(bi.incident))
+		bi.acked = true
+		return "Acknowledged"
+	}
+	b.noteLogger.Printf("[%s] Note on %s: %s", bi.target, incidentKey(bi.incident), note)
+	bi.notes = append(bi.notes, lc.timestamp(time.Now())+"  "+note)
+	return "Note added"
+}
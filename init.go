@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// "autoping init" asks for the targets, the time between pings, the log file
+// and where to email a daily digest, with the defaults in brackets, and writes
+// a config file with them. The file is read back and checked before it takes
+// the place of the one asked for, so it is valid as written. Answering
+// nothing, or running it with stdin closed, takes the defaults, though there
+// is no default target
+
+const initFile = "autoping.yaml" // Config file written unless -c or AUTOPING_CONFIG say otherwise
+
+// Ask for the settings of a config file on stdin and write it to the file
+// given with -c, as the only argument or in AUTOPING_CONFIG
+func initCommand(args []string) error {
+	fs := subcommandFlags("init", "c")
+	force := fs.Bool("force", false, "replace the config file if it already exists")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	path := *configFlag
+	switch {
+	case fs.NArg() == 1 && len(path) == 0:
+		path = fs.Arg(0)
+	case fs.NArg() > 0:
+		return errors.New("usage: autoping init [-force] [config file]")
+	case len(path) == 0:
+		if path = config.File(); len(path) == 0 {
+			path = initFile
+		}
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return fmt.Errorf("%s: autoping init writes YAML, so name the file .yaml", path)
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists. Pass -force to replace it", path)
+	}
+
+	w := &wizard{in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+	fmt.Fprintf(w.out, "Writing %s. Press Enter to take the default in brackets\n\n", path)
+	var targets []string
+	for len(targets) == 0 {
+		targets = strings.FieldsFunc(w.ask("Targets to ping, separated by commas", ""), func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		if len(targets) == 0 && w.eof {
+			return errors.New("no targets given")
+		} else if len(targets) == 0 {
+			fmt.Fprintln(w.out, "At least one target is needed")
+		}
+	}
+	interval := w.askDuration("Time between pings", "1m")
+	logFile := w.ask("Log file, or stdout or stderr", defaultLogPath)
+
+	var to []string
+	for {
+		to = strings.FieldsFunc(w.ask("Email addresses to send a daily digest to, separated by commas", "none"),
+			func(r rune) bool { return r == ',' || r == ' ' })
+		if len(to) == 1 && to[0] == "none" {
+			to = nil
+		}
+		if err := checkAddresses(to); err == nil || w.eof {
+			break
+		} else {
+			fmt.Fprintln(w.out, err)
+		}
+	}
+	var smtp config.SMTP
+	if len(to) > 0 {
+		smtp.Server = w.ask("Mail server to send it through, as host:port", "localhost:25")
+		for {
+			smtp.From = w.ask("Address to send it from", "autoping@"+hostname())
+			if err := checkAddresses([]string{smtp.From}); err == nil || w.eof {
+				break
+			} else {
+				fmt.Fprintln(w.out, err)
+			}
+		}
+		if smtp.Username = w.ask("Username on the mail server", "none"); smtp.Username == "none" {
+			smtp.Username = ""
+		}
+	}
+
+	// Write the file next to where it goes, check it and only then put it in
+	// place
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by autoping init on %s. The README has the other settings\n",
+		time.Now().In(timeZone).Format("2006-01-02"))
+	fmt.Fprintf(&b, "targets: [%s]\n", strings.Join(yamlStrings(targets), ", "))
+	fmt.Fprintf(&b, "interval: %s\n", interval)
+	fmt.Fprintf(&b, "log_file: %s\n", yamlString(logFile))
+	if len(to) > 0 {
+		fmt.Fprintf(&b, "smtp:\n  server: %s\n  from: %s\n", yamlString(smtp.Server), yamlString(smtp.From))
+		if len(smtp.Username) > 0 {
+			fmt.Fprintf(&b, "  username: %s\n  # Set the password in AUTOPING_SMTP_PASSWORD\n", yamlString(smtp.Username))
+		}
+		fmt.Fprintf(&b, "reports:\n  - name: daily\n    to: [%s]\n    schedule: daily\n    format: text\n",
+			strings.Join(yamlStrings(to), ", "))
+	}
+	tmp := path + ".new"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	cfg, err := config.Load(tmp)
+	if err == nil {
+		err = cfg.Check()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("the answers don't make a valid config file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fmt.Fprintf(w.out, "\nWrote %s. Start pinging with: autoping -c %s\n", path, path)
+	if len(smtp.Username) > 0 {
+		fmt.Fprintln(w.out, "Set AUTOPING_SMTP_PASSWORD to the password of", smtp.Username, "first")
+	}
+	return nil
+}
+
+// Asks questions on a terminal
+type wizard struct {
+	in  *bufio.Scanner
+	out io.Writer
+	eof bool // Has stdin closed? Every question takes its default from then on
+}
+
+// Method to ask the supplied question and return the answer, or the supplied
+// default if there is none
+func (w *wizard) ask(question, def string) string {
+	if len(def) > 0 {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	if w.eof || !w.in.Scan() {
+		w.eof = true
+		fmt.Fprintln(w.out)
+		return def
+	}
+	if answer := strings.TrimSpace(w.in.Text()); len(answer) > 0 {
+		return answer
+	}
+	return def
+}
+
+// Method to ask the supplied question until the answer is a duration of 1s or
+// more, and return it as given
+func (w *wizard) askDuration(question, def string) string {
+	for {
+		answer := w.ask(question, def)
+		d, err := time.ParseDuration(answer)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w.out, "%q isn't a duration like 30s or 2m\n", answer)
+		case d < time.Second:
+			fmt.Fprintln(w.out, "Use 1s or more")
+		default:
+			return answer
+		}
+		if w.eof {
+			return def
+		}
+	}
+}
+
+// Returns an error naming the first of the supplied email addresses that
+// isn't one
+func checkAddresses(addrs []string) error {
+	for _, addr := range addrs {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("%q isn't an email address", addr)
+		}
+	}
+	return nil
+}
+
+// Returns the name of this host, or localhost if it has none
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || len(name) == 0 {
+		return "localhost"
+	}
+	return name
+}
+
+// Returns the supplied string as a YAML scalar, quoted unless it is plain
+// enough not to need it
+func yamlString(s string) string {
+	if len(s) > 0 && !strings.ContainsAny(s, ",[]{}\"\\") && !strings.ContainsAny(s[:1], "-?:#&*!|>'%@`") &&
+		!strings.Contains(s, ": ") && !strings.Contains(s, " #") && strings.TrimSpace(s) == s {
+		return s
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// Returns the supplied strings as YAML scalars
+func yamlStrings(ss []string) []string {
+	var out []string
+	for _, s := range ss {
+		out = append(out, yamlString(s))
+	}
+	return out
+}
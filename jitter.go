@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"time"
+)
+
+// Many autopings started together, e.g. on boot or from cron, ping at the same
+// moments, so a router they share gets every probe at once. -jitter waits a
+// random time before the first ping, so each instance keeps a moment of its
+// own. -spread pings an instance's targets one after another over the
+// interval, instead of all together at its start
+
+var jitterFlag = flag.Duration("jitter", 0, "wait a random time of up to this long before the first ping, e.g. 30s, so instances started together don't ping together")
+var spreadFlag = flag.Bool("spread", false, "spread the pings to the targets evenly over the interval instead of sending them together")
+
+// Returns a random time to wait before the first ping, up to -jitter
+func startJitter() time.Duration {
+	if *jitterFlag <= 0 {
+		return 0
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return time.Duration(r.Int63n(int64(*jitterFlag)))
+}
+
+// Returns how long after the start of its interval of the supplied length to
+// ping the supplied target of the supplied number of targets
+func phaseOffset(i, n int, interval time.Duration) time.Duration {
+	if !*spreadFlag || n < 2 {
+		return 0
+	}
+	return (time.Duration(i) * interval / time.Duration(n)).Round(time.Millisecond)
+}
+
+// Wait for the supplied time, or until the supplied context is done. Returns
+// false if the context is done first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
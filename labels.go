@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Targets can carry labels set in the config file, such as site=office and
+// link=starlink, to tell the monitoring of two WAN links apart. They follow
+// the target's address in every log line about it, e.g.
+// "[192.168.1.1 link=starlink site=office] Lost contact...", and are shown in
+// digests and served by the API
+
+// Method to return the labels of the supplied target as name=value pairs
+// sorted by name and separated by spaces, or "" if it has none
+func (s *settings) labelString(addr string) string {
+	var pairs []string
+	for name, value := range s.labels[addr] {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// Method to return the target's address followed by its labels, as written
+// in its log lines
+func (tg *target) tag() string {
+	s := currentSettings()
+	if len(s.labels[tg.addr]) == 0 {
+		return tg.addr
+	}
+	if labels := s.labelString(tg.addr); len(labels) > 0 {
+		return tg.addr + " " + labels
+	}
+	return tg.addr
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Durations, percentages and timestamps meant for people (the status page,
+// badge, feed and calendar) are written the way the chosen locale expects, so
+// "1h23m0s" becomes "1 h 23 min". The log file keeps Go's formats, since
+// autoping reads it back
+
+type locale struct {
+	decimal string // Decimal separator
+	percent string // Written between a number and the % sign
+	layout  string // Layout of timestamps
+	never   string // Written instead of a timestamp that never happened
+	units   [3]string
+}
+
+var locales = map[string]locale{
+	"en":    {".", "", "2 Jan 2006 15:04:05", "never", [3]string{"h", "min", "s"}},
+	"en-US": {".", "", "Jan 2, 2006 3:04:05 PM", "never", [3]string{"h", "min", "s"}},
+	"de":    {",", " ", "02.01.2006 15:04:05", "nie", [3]string{"Std.", "Min.", "Sek."}},
+	"es":    {",", " ", "02/01/2006 15:04:05", "nunca", [3]string{"h", "min", "s"}},
+	"fr":    {",", " ", "02/01/2006 15:04:05", "jamais", [3]string{"h", "min", "s"}},
+	"it":    {",", " ", "02/01/2006 15:04:05", "mai", [3]string{"h", "min", "s"}},
+	"nl":    {",", " ", "02-01-2006 15:04:05", "nooit", [3]string{"u", "min", "s"}},
+	"pt":    {",", " ", "02/01/2006 15:04:05", "nunca", [3]string{"h", "min", "s"}},
+}
+
+var lc = locales["en"] // Locale chosen by the user
+
+// Method to write a duration as hours, minutes and seconds, leaving out the
+// seconds once it is over an hour long
+func (l locale) duration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	var parts []string
+	if h > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", h, l.units[0]))
+	}
+	if m > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", m, l.units[1]))
+	}
+	if h == 0 && (s > 0 || m == 0) {
+		parts = append(parts, fmt.Sprintf("%d %s", s, l.units[2]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Method to write a percentage with two decimal places
+func (l locale) pct(f float64) string {
+	return strings.Replace(fmt.Sprintf("%.2f", f), ".", l.decimal, 1) + l.percent + "%"
+}
+
+// Method to write a timestamp
+func (l locale) timestamp(t time.Time) string {
+	if t.IsZero() {
+		return l.never
+	}
+	return t.In(timeZone).Format(l.layout)
+}
+
+// Returns the names of the supported locales
+func localeNames() string {
+	var names []string
+	for n := range locales {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Take an advisory lock on the supplied log file, held until autoping exits.
+// Other programs can still append to the file
+func lockLog(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// Tell the supplied process to shut down, as SIGTERM does
+func stopProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Take a lock on the supplied log file, held until autoping exits. Locks on
+// Windows keep others from writing to what they cover, so the byte locked is
+// far past the end of the file, where no log line will ever go
+func lockLog(f *os.File) error {
+	ol := windows.Overlapped{OffsetHigh: 0x7fffffff}
+	err := windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errLocked
+	}
+	return err
+}
+
+// Windows can't send another process a signal, so the supplied process is
+// killed, and its pid file removed for it. An outage that is still going isn't
+// closed, as it is when autoping shuts down on its own
+func stopProcess(p *os.Process) error {
+	if err := p.Kill(); err != nil {
+		return err
+	}
+	return os.Remove(*pidFileFlag)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Reading back the log file lets autoping export and summarise history that
+// was recorded before the current run
+
+const defaultLogPath = "/var/log/goping.log" // File all loggers write to by default
+
+// Returns true if the supplied log destination is stdout or stderr rather than
+// a file
+func logToStream(path string) bool {
+	return path == "stdout" || path == "stderr"
+}
+
+// Open the supplied log destination for appending. "stdout" and "stderr" log
+// to the standard streams, e.g. in a container; anything else is a file
+func openLog(path string) (*os.File, error) {
+	switch path {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// Read the supplied log file line by line, calling fn with the prefix ("PING",
+// "OUTAGE", "ERROR" or "TRACE"), target, time and message of each line. The
+// target is empty for lines that don't name one. Lines that weren't written by
+// one of autoping's loggers are skipped. When classes of log lines have files
+// of their own, their lines are read from them too, in time order. When there
+// is an event file, the log is read from it instead, and from the log files
+// only up to its first event
+func scanLog(path string, fn func(prefix, target string, t time.Time, msg string)) error {
+	events := path == *logFlag && len(eventsFile.File) > 0
+	if logToStream(path) && !events {
+		return fmt.Errorf("can't read back a log written to %s. Pass the file it was saved to with -log", path)
+	}
+	var readers []*lineReader
+	defer func() {
+		for _, lr := range readers {
+			lr.close()
+		}
+	}()
+	var live []*lineReader // Readers with a line still to pass on
+	add := func(lr *lineReader) error {
+		readers = append(readers, lr)
+		ok, err := lr.next()
+		if ok {
+			live = append(live, lr)
+		}
+		return err
+	}
+
+	// Log lines are timed to the second, so the log files are read up to the
+	// start of the second of the first event
+	var until time.Time
+	if events {
+		lr := &lineReader{files: writtenFiles(eventsFile)}
+		if err := add(lr); err != nil {
+			return err
+		}
+		if len(live) > 0 {
+			until = lr.line.t.Truncate(time.Second)
+		}
+	}
+	sources := logSources(path)
+	if logToStream(path) {
+		sources = sources[1:]
+	}
+	for _, files := range sources {
+		if err := add(&lineReader{files: files, until: until}); err != nil {
+			return err
+		}
+	}
+
+	// Pass on the earliest line of any file, the log file first if there is a
+	// tie
+	for len(live) > 0 {
+		first := 0
+		for i := range live {
+			if live[i].line.t.Before(live[first].line.t) {
+				first = i
+			}
+		}
+		l := live[first].line
+		fn(l.prefix, l.target, l.t, l.msg)
+		ok, err := live[first].next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			live = append(live[:first], live[first+1:]...)
+		}
+	}
+	return nil
+}
+
+type logLine struct {
+	prefix string
+	target string
+	t      time.Time
+	msg    string
+}
+
+// Reads the log lines in a list of files, one file after the other
+type lineReader struct {
+	files   []string // Files still to open
+	f       *os.File
+	scanner *bufio.Scanner
+	line    logLine   // Line read last
+	until   time.Time // Time of the first line not to read. Every line is read if zero
+}
+
+// Method to read the next log line. Returns false once every file has been
+// read
+func (lr *lineReader) next() (bool, error) {
+	for {
+		if lr.scanner == nil {
+			if len(lr.files) == 0 {
+				return false, nil
+			}
+			f, err := os.Open(lr.files[0])
+			if err != nil {
+				return false, err
+			}
+			lr.files = lr.files[1:]
+			lr.f, lr.scanner = f, bufio.NewScanner(f)
+		}
+		for lr.scanner.Scan() {
+			l, ok := parseLogLine(lr.scanner.Text())
+			if !ok {
+				continue
+			}
+			if !lr.until.IsZero() && !l.t.Before(lr.until) {
+				lr.close()
+				lr.files = nil
+				return false, nil
+			}
+			lr.line = l
+			return true, nil
+		}
+		err := lr.scanner.Err()
+		lr.close()
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// Method to close the file being read, if any
+func (lr *lineReader) close() {
+	if lr.f != nil {
+		lr.f.Close()
+		lr.f, lr.scanner = nil, nil
+	}
+}
+
+// Returns true if the time at the start of the supplied rest of a log line is
+// followed by the offset of its zone, as it is in lines logged since they
+// carried it. Older lines are read as logged in the zone in use
+func hasZoneOffset(rest string) bool {
+	if len(rest) < 26 || rest[19] != ' ' || rest[20] != '+' && rest[20] != '-' || rest[25] != ' ' {
+		return false
+	}
+	for _, c := range rest[21:25] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Split the supplied log line, or event, into its parts. Returns false if it
+// wasn't written by one of autoping's loggers
+func parseLogLine(text string) (logLine, bool) {
+	if strings.HasPrefix(text, "{") {
+		return parseEvent(text)
+	}
+	// Split without allocating, as the event file does it for every line
+	i := strings.Index(text, " - ")
+	if i < 0 || len(text)-i-3 < 20 {
+		return logLine{}, false
+	}
+	prefix, rest := text[:i], text[i+3:]
+	stamp := rest[:19]
+	if hasZoneOffset(rest) {
+		stamp = rest[:25]
+	}
+	t, err := time.ParseInLocation(logTimeLayout[:len(stamp)], stamp, timeZone)
+	if err != nil {
+		return logLine{}, false
+	}
+	msg, name := rest[len(stamp)+1:], ""
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "] "); i > 0 {
+			name, msg = msg[1:i], msg[i+2:]
+		}
+		// Labels follow the target's address
+		if i := strings.IndexByte(name, ' '); i > 0 {
+			name = name[:i]
+		}
+	}
+	return logLine{prefix: prefix, target: name, t: t.In(timeZone), msg: msg}, true
+}
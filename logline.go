@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Routers and other small boxes autoping runs on have little memory to spare,
+// and every allocation made for a ping is garbage the collector has to find
+// later. The lines logged for every pong and missed pong are built by
+// appending to a buffer taken from a pool instead of with Printf, which boxes
+// each of its arguments, and handed to the logger without copying it to a
+// string. The logger writes them under its lock, as it does every other line,
+// so lines written at once from different goroutines don't get mixed up in
+// the files, event file and crash report they go to. They read the same as
+// lines logged with logf. BenchmarkResults shows how many allocations each
+// result still takes
+
+var logBufs = sync.Pool{New: func() interface{} {
+	b := make([]byte, 0, 256)
+	return &b
+}}
+
+const logTimeLayout = "2006/01/02 15:04:05 -0700" // Time at the start of log lines, after the prefix
+
+// Writes the lines of a logger to another writer behind the logger's prefix
+// and the time in the zone in use with its offset. The log package only
+// writes times in the system's zone, and without it
+type stampedWriter struct {
+	prefix string
+	out    io.Writer
+}
+
+func (sw stampedWriter) Write(p []byte) (int, error) {
+	bp := logBufs.Get().(*[]byte)
+	b := append((*bp)[:0], sw.prefix...)
+	b = time.Now().In(timeZone).AppendFormat(b, logTimeLayout)
+	b = append(b, ' ')
+	b = append(b, p...)
+	_, err := sw.out.Write(b)
+	*bp = b
+	logBufs.Put(bp)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Returns a logger writing lines to the supplied writer behind the supplied
+// prefix and the time, e.g. "PING - 2018/06/02 10:00:00 +1000 [10.0.0.1] ..."
+func newLogger(out io.Writer, prefix string) *log.Logger {
+	return log.New(stampedWriter{prefix: prefix, out: out}, "", 0)
+}
+
+// Returns the writer the lines of the supplied logger go to once they have
+// their prefix and time
+func logOutput(l *log.Logger) io.Writer {
+	if sw, ok := l.Writer().(stampedWriter); ok {
+		return sw.out
+	}
+	return l.Writer()
+}
+
+// Send the lines of the supplied logger to the supplied writer, with their
+// prefix and time as before
+func setLogOutput(l *log.Logger, out io.Writer) {
+	if sw, ok := l.Writer().(stampedWriter); ok {
+		out = stampedWriter{prefix: sw.prefix, out: out}
+	}
+	l.SetOutput(out)
+}
+
+// Method to log a line about the target with the supplied logger, as logf
+// does, without allocating. The supplied function appends the message to the
+// buffer it is given and returns it
+func (tg *target) logAppend(l *log.Logger, msg func(b []byte) []byte) {
+	bp := logBufs.Get().(*[]byte)
+	b := (*bp)[:0]
+	b = append(b, '[')
+	b = append(b, tg.tag()...)
+	b = append(b, "] "...)
+	b = msg(b)
+
+	// The logger copies the line to a buffer of its own before it returns, so
+	// the buffer can be reused
+	l.Output(2, unsafe.String(unsafe.SliceData(b), len(b)))
+
+	*bp = b
+	logBufs.Put(bp)
+}
+
+// Append the supplied duration to b as Duration.String writes it, without
+// allocating unless it is negative
+func appendDuration(b []byte, d time.Duration) []byte {
+	// The shortest decimal that reads back as the float is the same as the one
+	// Duration.String writes, as a duration in any one unit has far fewer
+	// digits than a float64 can hold
+	switch {
+	case d < 0:
+		return append(b, d.String()...)
+	case d == 0:
+		return append(b, "0s"...)
+	case d < time.Microsecond:
+		return append(strconv.AppendInt(b, int64(d), 10), "ns"...)
+	case d < time.Millisecond:
+		return append(strconv.AppendFloat(b, float64(d)/1e3, 'f', -1, 64), "µs"...)
+	case d < time.Second:
+		return append(strconv.AppendFloat(b, float64(d)/1e6, 'f', -1, 64), "ms"...)
+	}
+	if d >= time.Hour {
+		b = append(strconv.AppendInt(b, int64(d/time.Hour), 10), 'h')
+	}
+	if d >= time.Minute {
+		b = append(strconv.AppendInt(b, int64(d/time.Minute%60), 10), 'm')
+	}
+	return append(strconv.AppendFloat(b, float64(d%time.Minute)/1e9, 'f', -1, 64), 's')
+}
+
+// Append the supplied address to b as its String method writes it. IPv4
+// addresses are the ones appended without allocating
+func appendIPAddr(b []byte, a *net.IPAddr) []byte {
+	if a == nil {
+		return append(b, "<nil>"...)
+	}
+	ip4 := a.IP.To4()
+	if ip4 == nil || len(a.Zone) > 0 {
+		return append(b, a.String()...)
+	}
+	for i, n := range ip4 {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = strconv.AppendInt(b, int64(n), 10)
+	}
+	return b
+}
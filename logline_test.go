@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns a target logging with a logger of its own to the supplied writer
+func logTarget(t *testing.T, w io.Writer) (*target, *log.Logger) {
+	setTargets([]string{"192.0.2.1"})
+	t.Cleanup(func() { setTargets(nil) })
+	return findTarget("192.0.2.1"), newLogger(w, "PING - ")
+}
+
+func TestLogAppendAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations aren't counted under the race detector")
+	}
+	dir := t.TempDir()
+	file, err := openLog(filepath.Join(dir, "autoping.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := openLog(filepath.Join(dir, "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+
+	for _, setup := range []struct {
+		name string
+		w    io.Writer
+	}{
+		{"log file", file},
+		{"log file, event file and crash report", io.MultiWriter(file, eventWriter{events}, &recentLines{})},
+	} {
+		tg, l := logTarget(t, setup.w)
+		allocs := testing.AllocsPerRun(100, func() {
+			tg.logAppend(l, func(b []byte) []byte {
+				b = append(b, "Lost contact. Outage duration "...)
+				return appendDuration(b, 2*time.Minute)
+			})
+		})
+		if allocs > 0 {
+			t.Errorf("%s: %g allocations a line", setup.name, allocs)
+		}
+	}
+}
+
+// Lines logged at once from many goroutines have to come out whole, in every
+// writer they go to
+func TestLogAppendConcurrent(t *testing.T) {
+	var file strings.Builder
+	recent := &recentLines{}
+	tg, l := logTarget(t, io.MultiWriter(&file, recent, ioutil.Discard))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				tg.logAppend(l, func(b []byte) []byte { return append(b, "Timeout - Missed pong"...) })
+				tg.logf(l, "Running ping in %v", time.Second)
+			}
+		}()
+	}
+	wg.Wait()
+	lines := append(strings.Split(strings.TrimSuffix(file.String(), "\n"), "\n"), recent.lines()...)
+	for _, line := range lines {
+		if l, ok := parseLogLine(line); !ok || l.target != "192.0.2.1" ||
+			l.msg != "Timeout - Missed pong" && l.msg != "Running ping in 1s" {
+			t.Fatalf("mixed up line %q", line)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	ping "github.com/go-ping/ping"
+	"github.com/kurankat/autoping-go/config"
+)
+
+// For those who run their own mail server, mail not getting through matters
+// as much as the link being down. A target given as mail:// and an email
+// address, e.g. mail://probe@example.com, is probed by sending a message to
+// it through the smtp server in the config file and looking for it in the
+// mailbox set for the address under mailboxes, over IMAP, until it arrives.
+// The time it took to arrive stands in for the RTT. A message that doesn't
+// arrive within the target's timeout is a missed pong, and a server that
+// turns the message or the login down is one with the failure mail_error.
+// Probes are deleted from the mailbox once they have arrived, along with any
+// that arrived too late
+
+const (
+	mailProbeHeader = "X-Autoping-Probe" // Header carrying the ID of a mail probe
+	mailPoll        = time.Second        // Time between looks in the mailbox
+)
+
+var errMailLate = timeoutError{errors.New("mail probe didn't arrive in time")}
+
+type mailProber struct{ noOptions } // Probes mail:// targets by sending them mail
+
+// Method to check that the supplied mail target has a mailbox to look for its
+// probes in
+func (mailProber) check(s *settings, addr string) error {
+	if _, ok := s.mailboxes[strings.TrimPrefix(addr, "mail://")]; !ok {
+		return fmt.Errorf("target %s has no mailbox set under mailboxes", addr)
+	}
+	return nil
+}
+
+// Method to return the IMAP server of the supplied mail target's mailbox
+func (mailProber) host(s *settings, addr string) (string, error) {
+	host, _, err := net.SplitHostPort(s.mailboxes[strings.TrimPrefix(addr, "mail://")].IMAP)
+	return host, err
+}
+
+// Method to probe the supplied target by sending it mail and waiting for it
+// to arrive, in place of a ping
+func (mailProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the probe was sent
+	deadline := t.Add(tg.timeout())
+	to := strings.TrimPrefix(tg.addr, "mail://")
+	box, ok := currentSettings().mailboxes[to]
+	if !ok {
+		tg.pingFailed(t, failSocket, fmt.Errorf("no mailbox set for %s", to))
+		return
+	}
+	reportsMu.Lock()
+	s := reportSMTP
+	reportsMu.Unlock()
+
+	id, err := probeID()
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+	msg := mailProbe(s.From, to, id)
+	tg.logf(tLog, "Sending mail probe %s to %s through %s", id, to, s.Server)
+	if err := sendMailProbe(s, to, msg, deadline); err != nil {
+		tg.probeFailed(t, failMail, fmt.Errorf("sending through %s: %w", s.Server, err))
+		return
+	}
+	arrived, err := awaitMailProbe(box, id, deadline)
+	if err != nil {
+		tg.probeFailed(t, failMail, fmt.Errorf("looking in %s: %w", box.IMAP, err))
+		return
+	}
+
+	took := arrived.Sub(t)
+	if tg.samplePong(took) {
+		tg.logf(pLog, "%d bytes from %s: probe=%s time=%v", len(msg), to, id, took)
+	}
+	tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketsRecv: 1, Addr: tg.addr,
+		Rtts: []time.Duration{took}, MinRtt: took, MaxRtt: took, AvgRtt: took})
+}
+
+// Returns a mail probe with the supplied ID, from and to the supplied
+// addresses
+func mailProbe(from, to, id string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\n", from, to)
+	fmt.Fprintf(&b, "Subject: autoping mail probe %s\r\n", id)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().In(timeZone).Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@autoping>\r\n", id)
+	fmt.Fprintf(&b, "%s: %s\r\n\r\n", mailProbeHeader, id)
+	fmt.Fprintf(&b, "Sent by autoping to time mail delivery. It is deleted once it has arrived.\r\n")
+	return b.Bytes()
+}
+
+// Send the supplied mail probe to the supplied address through the supplied
+// mail server, giving up at the supplied deadline. The connection is upgraded
+// to TLS if the server offers it, as smtp.SendMail does
+func sendMailProbe(s config.SMTP, to string, msg []byte, deadline time.Time) error {
+	host, _, err := net.SplitHostPort(s.Server)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", s.Server, time.Until(deadline))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if len(s.Username) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Look for the mail probe with the supplied ID in the supplied mailbox every
+// mailPoll until it arrives or the deadline passes. Returns when it was found.
+// The probe and any others in the mailbox are deleted once it is
+func awaitMailProbe(box config.Mailbox, id string, deadline time.Time) (time.Time, error) {
+	c, err := dialIMAP(box.IMAP, deadline)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer c.close()
+	if _, err := c.cmd("LOGIN %s %s", imapQuote(box.Username), imapQuote(box.Password)); err != nil {
+		return time.Time{}, err
+	}
+	if _, err := c.cmd("SELECT INBOX"); err != nil {
+		return time.Time{}, err
+	}
+
+	for {
+		found, err := c.search(id)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if len(found) > 0 {
+			arrived := time.Now()
+			if all, err := c.search(""); err == nil && len(all) > 0 {
+				if _, err := c.cmd(`UID STORE %s +FLAGS.SILENT (\Deleted)`, strings.Join(all, ",")); err == nil {
+					c.cmd("EXPUNGE")
+				}
+			}
+			return arrived, nil
+		}
+		if time.Now().Add(mailPoll).After(deadline) {
+			return time.Time{}, errMailLate
+		}
+		time.Sleep(mailPoll)
+		// Servers tell of new messages in answer to any command
+		if _, err := c.cmd("NOOP"); err != nil {
+			return time.Time{}, err
+		}
+	}
+}
+
+// Connection to an IMAP server, just enough of the protocol to find and
+// delete mail probes
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int // Number of the last command sent
+}
+
+// Connect to the supplied IMAP server over TLS, giving up at the supplied
+// deadline
+func dialIMAP(server string, deadline time.Time) (*imapConn, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Deadline: deadline}, "tcp", server, &tls.Config{ServerName: host})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.line()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP server said %q", greeting)
+	}
+	return c, nil
+}
+
+// Method to read a line sent by the server, along with any literal it ends
+// with
+func (c *imapConn) line() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.LastIndexByte(line, '{'); i >= 0 && strings.HasSuffix(line, "}") {
+		if n, err := strconv.Atoi(line[i+1 : len(line)-1]); err == nil {
+			literal := make([]byte, n)
+			if _, err := io.ReadFull(c.r, literal); err != nil {
+				return "", err
+			}
+			rest, err := c.line()
+			return line + string(literal) + rest, err
+		}
+	}
+	return line, nil
+}
+
+// Method to send a command and wait for it to complete. Returns the untagged
+// lines sent in answer, or an error if the command didn't complete with OK
+func (c *imapConn) cmd(format string, v ...interface{}) ([]string, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	if _, err := fmt.Fprintf(c.conn, tag+" "+format+"\r\n", v...); err != nil {
+		return nil, err
+	}
+	var untagged []string
+	for {
+		line, err := c.line()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, tag+" ") {
+			untagged = append(untagged, line)
+			continue
+		}
+		if status := strings.TrimPrefix(line, tag+" "); !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("IMAP %s: %s", strings.Fields(format)[0], status)
+		}
+		return untagged, nil
+	}
+}
+
+// Method to return the UIDs of the mail probes with the supplied ID in the
+// mailbox, or of every mail probe if the ID is empty
+func (c *imapConn) search(id string) ([]string, error) {
+	lines, err := c.cmd("UID SEARCH HEADER %s %s", mailProbeHeader, imapQuote(id))
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(l, "* SEARCH"))...)
+		}
+	}
+	return uids, nil
+}
+
+// Method to log out and close the connection
+func (c *imapConn) close() {
+	c.cmd("LOGOUT")
+	c.conn.Close()
+}
+
+// Returns the supplied string as an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Maintenance windows set in the config file, such as a router that reboots
+// every night at 03:00, start whenever their cron schedule matches and last
+// for their duration. Targets are still pinged during them, but their missed
+// pongs, blips and outages are logged as happening during maintenance, so
+// reports, digests, the calendar and the status page leave them out and no
+// events are raised for them. An outage that is still going when the window
+// ends counts in full, since it is no longer the expected one. The calendar
+// shows the windows too, from as far back as the history goes to a week
+// ahead, so the team knows when alerts are expected to be quiet
+
+const maintenanceAhead = 7 * 24 * time.Hour // How far ahead calendars show maintenance windows
+
+// A time a maintenance window is open
+type maintenanceWindow struct {
+	window config.Maintenance // Window from the config file
+	start  time.Time          // Time it opens
+	end    time.Time          // Time it closes
+}
+
+// Returns true if the supplied target is in a maintenance window at the
+// supplied time
+func inMaintenance(addr string, t time.Time) bool {
+	t = t.In(timeZone)
+	minute := t.Truncate(time.Minute)
+	for _, m := range currentSettings().maintenance {
+		if len(m.Targets) > 0 && !inTargets(addr, m.Targets) {
+			continue
+		}
+		// The window is open if it started within its duration before now
+		for start := minute; t.Sub(start) < time.Duration(m.Duration); start = start.Add(-time.Minute) {
+			if m.Schedule.Matches(start) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Method to return the times the maintenance windows of the settings are open
+// between the supplied times, in order of window and then time. Only windows
+// covering any of the supplied targets are included, or every window if none
+// are supplied. A window that opens again before it has closed is open from
+// the first time to the last close
+func (s *settings) maintenanceWindows(from, to time.Time, only []string) []maintenanceWindow {
+	var ws []maintenanceWindow
+	for _, m := range s.maintenance {
+		if !coversAny(m.Targets, only) {
+			continue
+		}
+		d := time.Duration(m.Duration)
+		first := len(ws)
+		for t := from.In(timeZone).Truncate(time.Minute).Add(-d); t.Before(to); t = t.Add(time.Minute) {
+			if !m.Schedule.Matches(t) || !t.Add(d).After(from) {
+				continue
+			}
+			if n := len(ws); n > first && !t.After(ws[n-1].end) {
+				ws[n-1].end = t.Add(d)
+				continue
+			}
+			ws = append(ws, maintenanceWindow{window: m, start: t, end: t.Add(d)})
+		}
+	}
+	return ws
+}
+
+// Returns true if a window covering the supplied targets, or every target if
+// there are none, covers any of the others, or if there are no others
+func coversAny(targets, only []string) bool {
+	if len(targets) == 0 || len(only) == 0 {
+		return true
+	}
+	for _, addr := range targets {
+		if inTargets(addr, only) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A target with a mirror URL under overrides in the config file has the
+// result of every ping posted there as a JSON object as soon as it is known,
+// so whatever processing autoping doesn't do can be built elsewhere while
+// autoping keeps scheduling and sending the pings. Results are posted one at
+// a time, in the order they came in, and aren't tried again. If the mirrors
+// fall behind, results that don't fit in the queue are dropped rather than
+// holding up the pings
+
+const mirrorQueueSize = 1000 // Results waiting to be posted before more are dropped
+
+// Result of a ping as posted to a mirror
+type mirrorResult struct {
+	Time    time.Time         `json:"time"` // Time the ping was fired
+	Target  string            `json:"target"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	OK      bool              `json:"ok"`                    // Did a pong come back?
+	RTT     float64           `json:"rtt_seconds,omitempty"` // Round trip time of the pong
+	Failure string            `json:"failure,omitempty"`     // Why the ping failed, e.g. timeout or dns
+
+	url string // Mirror it goes to
+	tag string // Tag of the target in log lines
+}
+
+var (
+	mirrorQueue   = make(chan mirrorResult, mirrorQueueSize)
+	mirrorMu      sync.Mutex
+	mirrorDropped int             // Results dropped since the last post because the queue was full
+	mirrorFailing map[string]bool // Mirrors the last post to failed, by URL
+)
+
+var mirrorClient = &http.Client{Timeout: 10 * time.Second}
+
+// Method to queue the result of a ping fired at the supplied time for the
+// target's mirror, if it has one. failure is the category of a failed ping
+func (tg *target) mirror(t time.Time, ok bool, rtt time.Duration, failure string) {
+	s := currentSettings()
+	u := s.overrides[tg.addr].Mirror
+	if len(u) == 0 {
+		return
+	}
+	r := mirrorResult{Time: t, Target: tg.addr, Labels: s.labels[tg.addr], OK: ok, RTT: rtt.Seconds(),
+		Failure: failure, url: u, tag: tg.tag()}
+	select {
+	case mirrorQueue <- r:
+	default:
+		mirrorMu.Lock()
+		mirrorDropped++
+		mirrorMu.Unlock()
+	}
+}
+
+// Post queued results to their mirrors until the supplied context is done
+func mirrorResults(ctx context.Context) {
+	defer crashed("mirror")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-mirrorQueue:
+			sendMirror(r)
+		}
+	}
+}
+
+// Post the supplied result to its mirror, logging the first of a run of
+// failures to post to it and when it works again
+func sendMirror(r mirrorResult) {
+	mirrorMu.Lock()
+	dropped := mirrorDropped
+	mirrorDropped = 0
+	mirrorMu.Unlock()
+	if dropped > 0 {
+		eLog.Printf("Mirror: dropped %d results, more than %d were waiting to be posted", dropped, mirrorQueueSize)
+	}
+
+	err := postMirror(r)
+	mirrorMu.Lock()
+	defer mirrorMu.Unlock()
+	if mirrorFailing == nil {
+		mirrorFailing = make(map[string]bool)
+	}
+	switch {
+	case err != nil && !mirrorFailing[r.url]:
+		eLog.Printf("[%s] Posting result to mirror %s: %v", r.tag, r.url, err)
+		mirrorFailing[r.url] = true
+	case err == nil && mirrorFailing[r.url]:
+		tLog.Printf("[%s] Mirror %s working again", r.tag, r.url)
+		delete(mirrorFailing, r.url)
+	}
+}
+
+// Post the supplied result to its mirror as JSON
+func postMirror(r mirrorResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "autoping/"+version)
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
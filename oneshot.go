@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Besides running as a daemon, autoping can be used from scripts and cron
+// jobs. With -once it pings each target once, says which answered and exits
+// with status 3 if any didn't. With -duration it monitors for that long, then
+// stops as it does on SIGTERM and prints the summary of each target
+
+// Ping every target once and write whether each one answered to stdout, and
+// push the results to the Pushgateway if one was given. Returns the status to
+// exit with: exitOK if every target answered, exitPermission if a target
+// couldn't be pinged for lack of permission and exitUnreachable if one didn't
+// answer otherwise
+func pingOnce() int {
+	start := time.Now()
+	for _, tg := range currentTargets() {
+		pings.Add(1)
+		go func(tg *target) {
+			defer pings.Done()
+			tg.sup.run(tg.runPing)
+		}(tg)
+	}
+	pings.Wait()
+
+	status := exitOK
+	for _, tg := range currentTargets() {
+		if r, ok := tg.hist.latest(); ok && r.ok && !r.t.Before(start) {
+			fmt.Printf("%s answered in %v\n", tg.addr, r.rtt)
+			continue
+		}
+		if tg.failures.snapshot()[failPermission] > 0 {
+			fmt.Printf("%s couldn't be pinged: not allowed to open an ICMP socket\n", tg.addr)
+			status = exitPermission
+			continue
+		}
+		fmt.Printf("%s didn't answer\n", tg.addr)
+		if status == exitOK {
+			status = exitUnreachable
+		}
+	}
+	if len(*pushgatewayFlag) > 0 {
+		if err := pushResults(start); err != nil {
+			fmt.Println("Pushing to the Pushgateway:", err)
+			eLog.Printf("Pushing to the Pushgateway %s: %v", *pushgatewayFlag, err)
+		}
+	}
+	return status
+}
+
+// Method to return the result of the latest ping, if there has been one
+func (h *pingHistory) latest() (pingResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last, h.pinged
+}
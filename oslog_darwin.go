@@ -0,0 +1,61 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+/*
+#include <os/log.h>
+#include <stdlib.h>
+
+static os_log_t autoping_log;
+
+static void autoping_log_init(const char *subsystem) {
+	autoping_log = os_log_create(subsystem, "autoping");
+}
+
+static void autoping_log_write(os_log_type_t level, const char *msg) {
+	os_log_with_type(autoping_log, level, "%{public}s", msg);
+}
+*/
+import "C"
+
+import (
+	"io"
+	"log"
+	"strings"
+	"unsafe"
+)
+
+// Writes each log line to the unified log at a fixed level
+type osLogWriter struct {
+	level C.os_log_type_t
+}
+
+func (w osLogWriter) Write(p []byte) (int, error) {
+	msg := C.CString(strings.TrimSuffix(string(p), "\n"))
+	defer C.free(unsafe.Pointer(msg))
+	C.autoping_log_write(w.level, msg)
+	return len(p), nil
+}
+
+// Send every logger's lines to the unified log as well as the log file, so
+// they show up in Console and "log stream". Errors are logged as errors, and
+// trace lines as debug messages
+func useOSLog() error {
+	subsystem := C.CString(serviceLabel)
+	defer C.free(unsafe.Pointer(subsystem))
+	C.autoping_log_init(subsystem)
+
+	for l, level := range map[*log.Logger]C.os_log_type_t{
+		pLog: C.OS_LOG_TYPE_INFO,
+		aLog: C.OS_LOG_TYPE_INFO,
+		oLog: C.OS_LOG_TYPE_DEFAULT,
+		eLog: C.OS_LOG_TYPE_ERROR,
+	} {
+		setLogOutput(l, io.MultiWriter(logOutput(l), osLogWriter{level}))
+	}
+	if *traceFlag {
+		setLogOutput(tLog, io.MultiWriter(logOutput(tLog), osLogWriter{C.OS_LOG_TYPE_DEBUG}))
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package main
+
+import (
+	"errors"
+)
+
+// The unified log only exists on macOS, and needs cgo to reach
+func useOSLog() error {
+	return errors.New("the unified log is only available on macOS, in builds with cgo")
+}
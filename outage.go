@@ -0,0 +1,110 @@
+package main
+
+import "time"
+
+// Whether a target is in an outage is worked out by a state machine of its
+// own, fed the result of every ping and nothing else, so it can be checked
+// apart from the logging, events and history that hang off it. Missed pongs
+// are counted until they add up to an outage under the target's outage rule,
+// and a pong ends the outage, or the blip if they didn't. The times are those
+// the pings were fired at, never the time the result came in, so every
+// duration logged matches the timestamps it is worked out from. The tests
+// fuzz it with made-up results and check it against its invariants after each
+
+type connTracker struct {
+	isOutage           bool
+	lastSuccessfulPing time.Time
+	outageDuration     time.Duration
+	missed             int       // Pings missed since the last successful one
+	firstMissed        time.Time // Time the first of those pings was fired
+	maintenance        bool      // Has the outage only been seen during maintenance?
+}
+
+// When missed pongs add up to an outage
+type outageRule struct {
+	after int           // Missed pongs in a row, if more than 0
+	min   time.Duration // Time without a pong otherwise
+}
+
+// Method to return the target's outage rule: -outage-after pings missed in a
+// row if it is set, or no pong for longer than the minimum outage duration
+// otherwise. The target's overrides win over both
+func (tg *target) outageRule() outageRule {
+	s := currentSettings()
+	o := s.overrides[tg.addr]
+	switch {
+	case o.OutageAfter > 0:
+		return outageRule{after: o.OutageAfter}
+	case o.MinOutage > 0:
+		return outageRule{min: time.Duration(o.MinOutage)}
+	}
+	return outageRule{after: s.outageAfter, min: s.minOutage}
+}
+
+// Method to report whether the pings missed up to the supplied time add up to
+// an outage under the rule
+func (r outageRule) due(c *connTracker, t time.Time) bool {
+	if r.after > 0 {
+		return c.missed >= r.after
+	}
+	return t.Sub(c.lastSuccessfulPing) > r.min
+}
+
+// Method to count a pong missed for the ping fired at the supplied time
+func (c *connTracker) pongMissed(t time.Time) {
+	if c.missed == 0 {
+		c.firstMissed = t
+	}
+	c.missed++
+}
+
+// Method to start or carry on an outage at the supplied time if the pongs
+// missed add up to one under the supplied rule. maint says whether the time is
+// in a maintenance window. Returns whether the target is in an outage, and
+// whether it has just been seen outside maintenance for the first time, which
+// is when contact counts as lost. A target that has never answered isn't in an
+// outage, as there is nothing to measure it from
+func (c *connTracker) loseContact(t time.Time, rule outageRule, maint bool) (outage, lost bool) {
+	if c.lastSuccessfulPing.IsZero() || !rule.due(c, t) {
+		return false, false
+	}
+
+	// An outage only counts once it is seen outside maintenance windows
+	maint = maint && (!c.isOutage || c.maintenance)
+	lost = !maint && (!c.isOutage || c.maintenance)
+	c.isOutage, c.maintenance = true, maint
+	c.outageDuration = t.Sub(c.lastSuccessfulPing)
+	return true, lost
+}
+
+// Method to take in a pong for the ping fired at the supplied time. Returns the
+// outage it ended, or the blip if the pongs missed before it didn't add up to
+// one, with how many were missed. The kind is empty if nothing was missed.
+// maint says whether an outage was only seen during maintenance
+func (c *connTracker) pongAnswered(t time.Time) (ended incident, missed int, maint bool) {
+	switch {
+	case c.isOutage:
+		ended = incident{kind: "Outage", start: c.lastSuccessfulPing, end: t}
+		c.outageDuration = t.Sub(c.lastSuccessfulPing)
+	case c.missed > 0:
+		ended = incident{kind: "Blip", start: c.firstMissed, end: t}
+	}
+	missed, maint = c.missed, c.maintenance
+	c.lastSuccessfulPing = t
+	c.isOutage, c.maintenance = false, false
+	c.missed = 0
+	return ended, missed, maint
+}
+
+// Method to end an outage still going at the supplied time without a pong,
+// e.g. at shutdown. Returns it, with an empty kind if there wasn't one, and
+// whether it was only seen during maintenance
+func (c *connTracker) closeOutage(t time.Time) (ended incident, maint bool) {
+	if c.isOutage {
+		ended = incident{kind: "Outage", start: c.lastSuccessfulPing, end: t}
+		c.outageDuration = t.Sub(c.lastSuccessfulPing)
+	}
+	maint = c.maintenance
+	c.isOutage, c.maintenance = false, false
+	return ended, maint
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// Method to check the state against what has to hold after a ping fired at
+// the supplied time, whatever the results before it were. Returns an error
+// saying what doesn't
+func (c *connTracker) check(t time.Time) error {
+	switch {
+	case c.missed < 0:
+		return fmt.Errorf("%d missed pongs", c.missed)
+	case c.missed > 0 && (c.firstMissed.After(t) || c.firstMissed.Before(c.lastSuccessfulPing)):
+		return fmt.Errorf("first missed pong at %v, outside %v to %v", c.firstMissed, c.lastSuccessfulPing, t)
+	case c.lastSuccessfulPing.After(t):
+		return fmt.Errorf("last pong at %v, after %v", c.lastSuccessfulPing, t)
+	case c.isOutage && c.lastSuccessfulPing.IsZero():
+		return fmt.Errorf("outage without a pong before it")
+	case c.isOutage && c.missed == 0:
+		return fmt.Errorf("outage without missed pongs")
+	case c.maintenance && !c.isOutage:
+		return fmt.Errorf("maintenance outside an outage")
+	case c.outageDuration < 0:
+		return fmt.Errorf("outage duration of %v", c.outageDuration)
+	}
+	return nil
+}
+
+// Returns the outage rule made from the supplied fuzzed numbers: up to 7
+// missed pongs in a row, or up to 10 minutes without a pong if that is 0
+func fuzzedRule(after uint8, min uint16) outageRule {
+	if r := (outageRule{after: int(after % 8)}); r.after > 0 {
+		return r
+	}
+	return outageRule{min: time.Duration(min%600) * time.Second}
+}
+
+// Feed a new state machine the ping results encoded in the supplied steps,
+// one a byte, as autoping does, and return an error for the first invariant
+// that doesn't hold. The low 2 bits of a step say whether the ping was
+// answered (0), missed (1) or missed in the startup grace period (2 and 3),
+// the next whether it was fired in a maintenance window, and the rest how
+// many seconds after the last ping it was fired. Every outage still going at
+// the end is closed, as at shutdown
+func walkTracker(steps []byte, rule outageRule) error {
+	var c connTracker
+	t := time.Date(2018, 6, 2, 10, 0, 0, 0, time.UTC)
+
+	// What the state machine should have seen so far, worked out apart from it
+	var (
+		lastPong    time.Time // Time of the last answered ping
+		firstMissed time.Time // Time of the first ping missed since
+		missed      int       // Pings missed since
+		open        bool      // Is an outage going?
+		lostAt      time.Time // Time contact was lost in the outage going, if it was
+	)
+
+	// Check an outage or blip ended at the supplied time by a pong, or by
+	// shutting down, against what was seen
+	ended := func(step int, ended incident, maint, pong bool) error {
+		blip := pong && !open && missed > 0
+		switch {
+		case open != (ended.kind == "Outage"):
+			return fmt.Errorf("step %d: outage going is %v, but %q ended", step, open, ended.kind)
+		case blip != (ended.kind == "Blip"):
+			return fmt.Errorf("step %d: %d missed pongs, outage going %v, but %q ended", step, missed, open,
+				ended.kind)
+		case ended.kind != "" && ended.end.Before(ended.start):
+			return fmt.Errorf("step %d: %s from %v to %v", step, ended.kind, ended.start, ended.end)
+		case ended.kind == "Outage" && (!ended.start.Equal(lastPong) || !ended.end.Equal(t)):
+			return fmt.Errorf("step %d: outage from %v to %v, not %v to %v", step, ended.start, ended.end, lastPong, t)
+		case ended.kind == "Blip" && (!ended.start.Equal(firstMissed) || !ended.end.Equal(t)):
+			return fmt.Errorf("step %d: blip from %v to %v, not %v to %v", step, ended.start, ended.end, firstMissed, t)
+		case ended.kind == "Outage" && maint != lostAt.IsZero():
+			return fmt.Errorf("step %d: outage only seen during maintenance is %v, contact lost at %v", step, maint,
+				lostAt)
+		}
+		return nil
+	}
+
+	for i, step := range steps {
+		t = t.Add(time.Duration(step>>3) * time.Second)
+		maint := step&4 != 0
+		switch step & 3 {
+		case 0:
+			e, gotMissed, gotMaint := c.pongAnswered(t)
+			if err := ended(i, e, gotMaint, true); err != nil {
+				return err
+			}
+			if gotMissed != missed {
+				return fmt.Errorf("step %d: %d missed pongs, not %d", i, gotMissed, missed)
+			}
+			if c.missed != 0 || c.isOutage {
+				return fmt.Errorf("step %d: %d missed pongs and outage %v after a pong", i, c.missed, c.isOutage)
+			}
+			lastPong, missed, open, lostAt = t, 0, false, time.Time{}
+		case 1:
+			c.pongMissed(t)
+			if missed++; missed == 1 {
+				firstMissed = t
+			}
+			due := !lastPong.IsZero() &&
+				(rule.after > 0 && missed >= rule.after || rule.after == 0 && t.Sub(lastPong) > rule.min)
+			outage, lost := c.loseContact(t, rule, maint)
+			switch {
+			case outage != due:
+				return fmt.Errorf("step %d: outage %v after %d missed pongs since %v", i, outage, missed, lastPong)
+			case lost && maint:
+				return fmt.Errorf("step %d: contact lost during maintenance", i)
+			case lost && !lostAt.IsZero():
+				return fmt.Errorf("step %d: contact lost again, after %v", i, lostAt)
+			case outage && !maint && !lost && lostAt.IsZero():
+				return fmt.Errorf("step %d: outage outside maintenance without contact lost", i)
+			case outage && c.outageDuration != t.Sub(lastPong):
+				return fmt.Errorf("step %d: outage duration %v, not %v", i, c.outageDuration, t.Sub(lastPong))
+			}
+			open = open || outage
+			if lost {
+				lostAt = t
+			}
+		default:
+			c.pongMissed(t)
+			if missed++; missed == 1 {
+				firstMissed = t
+			}
+		}
+		if err := c.check(t); err != nil {
+			return fmt.Errorf("step %d: %v", i, err)
+		}
+	}
+
+	e, maint := c.closeOutage(t)
+	if err := ended(len(steps), e, maint, false); err != nil {
+		return err
+	}
+	if c.isOutage || c.maintenance {
+		return fmt.Errorf("outage %v, maintenance %v after closing it", c.isOutage, c.maintenance)
+	}
+	return c.check(t)
+}
+
+func TestConnTracker(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		steps []byte
+		rule  outageRule
+	}{
+		{"no pongs", []byte{1, 1, 1}, outageRule{after: 2}},
+		{"blip", []byte{0, 8<<3 | 1, 8<<3 | 0}, outageRule{after: 2}},
+		{"outage after 2", []byte{0, 8<<3 | 1, 8<<3 | 1, 8<<3 | 1, 8<<3 | 0}, outageRule{after: 2}},
+		{"outage after 30s", []byte{0, 20<<3 | 1, 20<<3 | 1, 0}, outageRule{min: 30 * time.Second}},
+		{"outage in maintenance", []byte{0, 5, 5, 5, 0}, outageRule{after: 1}},
+		{"maintenance ending in an outage", []byte{0, 5, 5, 1, 1, 0}, outageRule{after: 1}},
+		{"outage at shutdown", []byte{0, 1, 1}, outageRule{after: 2}},
+		{"grace period", []byte{0, 2, 3, 1, 0}, outageRule{after: 3}},
+	} {
+		if err := walkTracker(test.steps, test.rule); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+func TestConnTrackerProperties(t *testing.T) {
+	cfg := &quick.Config{
+		MaxCount: 5000,
+		Rand:     rand.New(rand.NewSource(1)),
+		// Runs long enough for outages under a 10 minute rule, with most
+		// pings answered as they are for real
+		Values: func(args []reflect.Value, r *rand.Rand) {
+			steps := make([]byte, r.Intn(400))
+			for i := range steps {
+				steps[i] = byte(r.Intn(256))
+				if r.Intn(3) == 0 {
+					steps[i] &^= 3
+				}
+			}
+			args[0] = reflect.ValueOf(steps)
+			args[1] = reflect.ValueOf(uint8(r.Intn(256)))
+			args[2] = reflect.ValueOf(uint16(r.Intn(65536)))
+		},
+	}
+	err := quick.Check(func(steps []byte, after uint8, min uint16) bool {
+		if err := walkTracker(steps, fuzzedRule(after, min)); err != nil {
+			t.Logf("%v under %+v: %v", steps, fuzzedRule(after, min), err)
+			return false
+		}
+		return true
+	}, cfg)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func FuzzConnTracker(f *testing.F) {
+	f.Add([]byte{0, 8<<3 | 1, 8<<3 | 1, 8<<3 | 1, 8<<3 | 0}, uint8(2), uint16(0))
+	f.Add([]byte{0, 20<<3 | 1, 20<<3 | 1, 0}, uint8(0), uint16(30))
+	f.Add([]byte{0, 5, 5, 1, 1, 0, 1}, uint8(1), uint16(0))
+	f.Add([]byte{2, 0, 3, 1, 0}, uint8(3), uint16(0))
+	f.Fuzz(func(t *testing.T, steps []byte, after uint8, min uint16) {
+		if err := walkTracker(steps, fuzzedRule(after, min)); err != nil {
+			t.Errorf("under %+v: %v", fuzzedRule(after, min), err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Targets in one config can need different settings: a LAN gateway should
+// count as down after two missed pings 5s apart, while a host across the
+// Pacific needs a longer timeout and looser latency tiers. Overrides in the
+// config file set the interval, timeout, outage threshold and latency tier
+// thresholds of single targets, and the rest keep those of the flags, config
+// file and profile. Pings are sent on a tick that fits every target's
+// interval, each target when its interval is up
+
+const minTick = 100 * time.Millisecond // Shortest time between ticks, whatever the intervals
+
+// Method to return the time between the pings of the supplied target
+func (s *settings) targetInterval(addr string) time.Duration {
+	if o := s.overrides[addr]; o.Interval > 0 {
+		return time.Duration(o.Interval)
+	}
+	return s.interval
+}
+
+// Method to return the time to wait for the supplied target's pongs. A target
+// with an interval of its own and no timeout waits half its interval, up to
+// 30s
+func (s *settings) targetTimeout(addr string) time.Duration {
+	o := s.overrides[addr]
+	switch {
+	case o.Timeout > 0:
+		return time.Duration(o.Timeout)
+	case o.Interval > 0:
+		return autoTimeout(time.Duration(o.Interval))
+	}
+	return s.timeout
+}
+
+// Method to return the time between the target's pings
+func (tg *target) interval() time.Duration {
+	return currentSettings().targetInterval(tg.addr)
+}
+
+// Method to return the time to wait for the target's pongs
+func (tg *target) timeout() time.Duration {
+	return currentSettings().targetTimeout(tg.addr)
+}
+
+// Returns the timeout of pings sent at the supplied interval when none is set
+func autoTimeout(interval time.Duration) time.Duration {
+	if interval/2 > 30*time.Second {
+		return 30 * time.Second
+	}
+	return interval / 2
+}
+
+// Method to return the threshold of each of the target's latency tiers. An
+// array rather than a slice, as it is asked for with every pong
+func (tg *target) thresholds() [3]float64 {
+	s := currentSettings()
+	if l := s.overrides[tg.addr].Latency; l != nil {
+		return [3]float64{l.Mild, l.Severe, l.Extreme}
+	}
+	return s.thresholds
+}
+
+// Check that the timeout of every target with overrides in the supplied
+// settings is shorter than its interval
+func checkOverrides(s *settings) error {
+	for addr := range s.overrides {
+		if timeout, interval := s.targetTimeout(addr), s.targetInterval(addr); timeout >= interval {
+			return fmt.Errorf("timeout %v of %s must be shorter than its interval %v", timeout, addr, interval)
+		}
+	}
+	return nil
+}
+
+// Returns the time between ticks: the longest that every target's interval
+// is a whole number of, but no shorter than minTick
+func pingTick() time.Duration {
+	s := currentSettings()
+	tick := s.interval
+	for _, tg := range currentTargets() {
+		a, b := tick, s.targetInterval(tg.addr)
+		for b > 0 {
+			a, b = b, a%b
+		}
+		tick = a
+	}
+	if tick < minTick {
+		return minTick
+	}
+	return tick
+}
+
+// Method to report whether the target's next ping is due on the tick at the
+// supplied time, and if it is, to work out when the one after is due. Ticks
+// that come a little early still count, and on battery pings are at least
+// lowPowerInterval apart
+func (tg *target) pingDue(t time.Time, tick time.Duration) bool {
+	interval := tg.interval()
+	if lowPower() && interval < lowPowerInterval {
+		interval = lowPowerInterval
+	}
+	if !tg.nextPing.IsZero() && t.Before(tg.nextPing.Add(-tick/2)) {
+		return false
+	}
+
+	// Start afresh after a gap, e.g. after the interval changed, rather than
+	// catching up
+	next := tg.nextPing.Add(interval)
+	if tg.nextPing.IsZero() || !next.After(t) {
+		next = t.Add(interval)
+	}
+	tg.nextPing = next
+	return true
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Just enough of Parquet to write a table of int64, double, boolean and
+// string columns, some of which may be null, that pandas, Polars, Spark and
+// DuckDB can read: a single row group of one uncompressed, PLAIN encoded data
+// page per column, and the file metadata in Thrift's compact protocol. Going
+// without compression and dictionaries keeps the writer small, and a year of
+// pings of a few targets is still only a few tens of megabytes
+
+// Physical and converted types of columns, as numbered by Parquet
+const (
+	pqBoolean   = 0
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqUTF8            = 0
+	pqTimestampMillis = 9
+	pqNone            = -1 // No converted type
+)
+
+// A column of a table being written, holding its values until the file is
+// written
+type pqColumn struct {
+	name     string
+	typ      int32  // Physical type
+	conv     int32  // Converted type
+	optional bool   // Can values be null?
+	present  []bool // Whether each value is there rather than null
+	values   []byte // Values that are there, PLAIN encoded
+	bools    int    // Booleans in values, which take a bit each
+}
+
+// Returns a column that holds values of the supplied physical and converted
+// types, and can hold nulls if optional
+func newColumn(name string, typ, conv int32, optional bool) *pqColumn {
+	return &pqColumn{name: name, typ: typ, conv: conv, optional: optional}
+}
+
+// Method to add a null to the column
+func (c *pqColumn) addNull() {
+	c.present = append(c.present, false)
+}
+
+// Method to add an int64 to the column
+func (c *pqColumn) addInt64(v int64) {
+	c.present = append(c.present, true)
+	c.values = le64(c.values, uint64(v))
+}
+
+// Method to add a double to the column
+func (c *pqColumn) addDouble(v float64) {
+	c.present = append(c.present, true)
+	c.values = le64(c.values, math.Float64bits(v))
+}
+
+// Method to add a boolean to the column
+func (c *pqColumn) addBool(v bool) {
+	c.present = append(c.present, true)
+	if c.bools%8 == 0 {
+		c.values = append(c.values, 0)
+	}
+	if v {
+		c.values[len(c.values)-1] |= 1 << uint(c.bools%8)
+	}
+	c.bools++
+}
+
+// Method to add a string to the column
+func (c *pqColumn) addString(s string) {
+	c.present = append(c.present, true)
+	c.values = append(le32(c.values, uint32(len(s))), s...)
+}
+
+// Method to return the data page of the column: its definition levels, if it
+// can hold nulls, followed by its values
+func (c *pqColumn) page() []byte {
+	if !c.optional {
+		return c.values
+	}
+
+	// Definition levels are 1 for values that are there and 0 for nulls, in
+	// runs of the same level, as in the RLE/bit-packing hybrid encoding
+	var levels pbuf
+	for i := 0; i < len(c.present); {
+		run := 1
+		for i+run < len(c.present) && c.present[i+run] == c.present[i] {
+			run++
+		}
+		levels.uvarint(uint64(run) << 1)
+		if c.present[i] {
+			levels = append(levels, 1)
+		} else {
+			levels = append(levels, 0)
+		}
+		i += run
+	}
+	return append(append(le32(nil, uint32(len(levels))), levels...), c.values...)
+}
+
+// Write a Parquet file holding the supplied columns, which must all have the
+// same number of values, to w
+func writeParquet(w io.Writer, cols []*pqColumn) error {
+	rows := 0
+	if len(cols) > 0 {
+		rows = len(cols[0].present)
+	}
+	out := []byte("PAR1")
+	type chunk struct{ offset, size int64 }
+	var chunks []chunk
+	for _, c := range cols {
+		data := c.page()
+		var h thrift
+		h.begin()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.beginField(5)
+		h.i32(1, int32(rows))
+		h.i32(2, 0) // PLAIN
+		h.i32(3, 3) // RLE
+		h.i32(4, 3) // RLE
+		h.end()
+		h.end()
+		chunks = append(chunks, chunk{int64(len(out)), int64(len(h.buf) + len(data))})
+		out = append(append(out, h.buf...), data...)
+	}
+
+	var m thrift
+	m.begin()
+	m.i32(1, 1) // Version
+	m.list(2, tStruct, len(cols)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(cols)))
+	m.end()
+	for _, c := range cols {
+		m.begin()
+		m.i32(1, c.typ)
+		if c.optional {
+			m.i32(3, 1) // OPTIONAL
+		} else {
+			m.i32(3, 0) // REQUIRED
+		}
+		m.str(4, c.name)
+		if c.conv != pqNone {
+			m.i32(6, c.conv)
+		}
+		m.end()
+	}
+	m.i64(3, int64(rows))
+	m.list(4, tStruct, 1)
+	m.begin()
+	m.list(1, tStruct, len(cols))
+	var total int64
+	for i, c := range cols {
+		m.begin()
+		m.i64(2, chunks[i].offset)
+		m.beginField(3)
+		m.i32(1, c.typ)
+		m.list(2, tI32, 2)
+		m.zigzag(0) // PLAIN
+		m.zigzag(3) // RLE
+		m.list(3, tBinary, 1)
+		m.binary(c.name)
+		m.i32(4, 0) // UNCOMPRESSED
+		m.i64(5, int64(rows))
+		m.i64(6, chunks[i].size)
+		m.i64(7, chunks[i].size)
+		m.i64(9, chunks[i].offset)
+		m.end()
+		m.end()
+		total += chunks[i].size
+	}
+	m.i64(2, total)
+	m.i64(3, int64(rows))
+	m.end()
+	m.str(6, "autoping "+version)
+	m.end()
+
+	out = append(le32(append(out, m.buf...), uint32(len(m.buf))), "PAR1"...)
+	_, err := w.Write(out)
+	return err
+}
+
+// Types of Thrift's compact protocol
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// Writes structs in Thrift's compact protocol, which Parquet's metadata and
+// page headers are written in
+type thrift struct {
+	buf  pbuf
+	last []int16 // ID of the last field written, of each struct being written
+}
+
+// Method to start a struct, at the top or as an element of a list
+func (t *thrift) begin() {
+	t.last = append(t.last, 0)
+}
+
+// Method to start a struct that is the supplied field of the current one
+func (t *thrift) beginField(id int16) {
+	t.field(id, tStruct)
+	t.begin()
+}
+
+// Method to finish the current struct
+func (t *thrift) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// Method to write the header of a field. IDs close to the last one's are
+// written as the difference, in the same byte as the type
+func (t *thrift) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thrift) zigzag(v int64) {
+	t.buf.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thrift) binary(s string) {
+	t.buf.uvarint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, tI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, tI64)
+	t.zigzag(v)
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, tBinary)
+	t.binary(s)
+}
+
+// Method to write the header of a list field of the supplied number of
+// elements of the supplied type. The elements follow
+func (t *thrift) list(id int16, elem byte, n int) {
+	t.field(id, tList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf.uvarint(uint64(n))
+	}
+}
+
+// Returns the supplied bytes with the supplied value appended in little-endian
+// order
+func le32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func le64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Some equipment faults leave a fingerprint in the pattern of lost pings: a
+// modem that retrains every N minutes loses pings periodically, and a flapping
+// port loses bursts of the same length every time. The weekly pattern analysis
+// looks for both in the log file
+
+const (
+	patternDays     = 7   // Number of days of the log analysed by default
+	patternMinCount = 3   // Occurrences needed before calling something a pattern
+	patternShare    = 0.5 // Share of bursts or gaps that must match a pattern
+)
+
+type lossBurst struct {
+	start  time.Time // Time the first ping of the burst was lost
+	last   time.Time // Time the last ping of the burst was lost
+	length int       // Number of pings lost in a row
+}
+
+// Read the supplied log file between the supplied times and write an analysis
+// of the patterns in lost pings to w. If any targets are supplied, only their
+// pings are analysed
+func lossPatterns(w io.Writer, path string, from, to time.Time, only []string) error {
+	interval := currentSettings().interval
+
+	// Losses less than one and a half ping intervals apart are part of the same
+	// burst
+	var bursts []lossBurst
+	var blipDays []string
+	blipsPerDay := make(map[string]int)
+	var blipHours [24]int
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		lost := 0
+		switch {
+		case t.Before(from) || !t.Before(to) || !inTargets(name, only):
+			return
+		case prefix == "OUTAGE" && blipLine.MatchString(msg):
+			d, err := time.ParseDuration(blipLine.FindStringSubmatch(msg)[1])
+			if err != nil {
+				return
+			}
+			day := t.Add(-d).Format("Mon 2006-01-02")
+			if blipsPerDay[day] == 0 {
+				blipDays = append(blipDays, day)
+			}
+			blipsPerDay[day]++
+			blipHours[t.Add(-d).Hour()]++
+			return
+		case prefix == "OUTAGE" && msg == "Timeout - Missed pong":
+			lost = 1
+		case prefix == "PING" && cycleLine.MatchString(msg):
+			m := cycleLine.FindStringSubmatch(msg)
+			recv, _ := strconv.Atoi(m[1])
+			sent, _ := strconv.Atoi(m[2])
+			// Cycles with none back are counted by their missed pong line
+			if recv > 0 && recv < sent {
+				lost = 1
+			}
+		}
+		if lost == 0 {
+			return
+		}
+		if n := len(bursts); n > 0 && t.Sub(bursts[n-1].last) <= interval*3/2 {
+			bursts[n-1].last = t
+			bursts[n-1].length++
+		} else {
+			bursts = append(bursts, lossBurst{start: t, last: t, length: 1})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Loss pattern analysis from %s to %s\n", from.In(timeZone).Format("2006-01-02 15:04"),
+		to.In(timeZone).Format("2006-01-02 15:04"))
+	total := 0
+	lengths := make(map[int]int)
+	for _, b := range bursts {
+		total += b.length
+		lengths[b.length]++
+	}
+	fmt.Fprintf(w, "Lost pings: %d in %d bursts\n", total, len(bursts))
+
+	// Blips are easy to miss among the outages, so show when they happen
+	if len(blipDays) > 0 {
+		fmt.Fprintln(w, "Blips per day:")
+		for _, day := range blipDays {
+			fmt.Fprintf(w, "  %s  %d\n", day, blipsPerDay[day])
+		}
+		fmt.Fprintln(w, "Blips by hour of day:")
+		for h, n := range blipHours {
+			fmt.Fprintf(w, "  %02d:00  %-3d %s\n", h, n, strings.Repeat("#", n))
+		}
+	}
+	if len(bursts) == 0 {
+		return nil
+	}
+
+	// Bursts of the same length
+	var keys []int
+	for l := range lengths {
+		keys = append(keys, l)
+	}
+	sort.Ints(keys)
+	fmt.Fprintf(w, "Burst lengths:")
+	for _, l := range keys {
+		fmt.Fprintf(w, " %d x%d", l, lengths[l])
+	}
+	fmt.Fprintln(w)
+	if l, n := mostCommon(lengths); l > 1 && n >= patternMinCount &&
+		float64(n) >= patternShare*float64(len(bursts)) {
+		fmt.Fprintf(w, "Fixed-length bursts: %d of %d bursts lost %d pings in a row\n",
+			n, len(bursts), l)
+	}
+
+	// Bursts starting a regular number of ping intervals apart
+	gaps := make(map[int]int)
+	for i := 1; i < len(bursts); i++ {
+		gaps[int((bursts[i].start.Sub(bursts[i-1].start)+interval/2)/interval)]++
+	}
+	if g, n := mostCommon(gaps); n >= patternMinCount &&
+		float64(n) >= patternShare*float64(len(bursts)-1) {
+		fmt.Fprintf(w, "Periodic loss: %d of %d bursts started %v after the previous one\n",
+			n, len(bursts)-1, time.Duration(g)*interval)
+	}
+	return nil
+}
+
+// Returns the most common key in the supplied counts and how often it occurs.
+// Ties go to the smallest key
+func mostCommon(counts map[int]int) (key, n int) {
+	for k, c := range counts {
+		if c > n || (c == n && k < key) {
+			key, n = k, c
+		}
+	}
+	return key, n
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Some downtime is known about beforehand, e.g. while the router is swapped,
+// and shouldn't count against the ISP. SIGUSR1 pauses the pings without
+// stopping autoping and SIGUSR2 resumes them, as do "autoping pause" and
+// "autoping resume" for an instance started with -pidfile. "Monitoring
+// paused" and "Monitoring resumed" lines mark the gap in the log, outages and
+// periods of flakey latency still going are closed when the pings pause, and
+// the time without pings doesn't count towards the next outage. A paused
+// autoping that is restarted pings again
+
+var (
+	pauseMu     sync.Mutex
+	pausedSince time.Time // Time the pings were paused. Zero unless they are
+)
+
+// Returns the time the pings were paused, or the zero time if they aren't
+func monitoringPaused() time.Time {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	return pausedSince
+}
+
+// Stop sending pings at the supplied time, until resumeMonitoring is called.
+// The pings in flight are waited for, so the caller must be the one sending
+// them
+func pauseMonitoring(now time.Time) {
+	pauseMu.Lock()
+	was := pausedSince
+	if was.IsZero() {
+		pausedSince = now
+	}
+	pauseMu.Unlock()
+	if !was.IsZero() {
+		tLog.Printf("Monitoring has been paused since %v already", was)
+		return
+	}
+
+	pings.Wait()
+	pLog.Printf("Monitoring paused")
+	for _, tg := range currentTargets() {
+		tg.flushSampled()
+		tg.closeIncidents(now, "when paused")
+		tg.spl = nil
+		tg.publish()
+	}
+}
+
+// Send pings again from the supplied time. Pongs missed from now on count
+// towards an outage as if the last successful ping had just come back
+func resumeMonitoring(now time.Time) {
+	pauseMu.Lock()
+	was := pausedSince
+	pausedSince = time.Time{}
+	pauseMu.Unlock()
+	if was.IsZero() {
+		tLog.Printf("Monitoring isn't paused")
+		return
+	}
+
+	pLog.Printf("Monitoring resumed after %v", now.Sub(was).Round(time.Second))
+	for _, tg := range currentTargets() {
+		if !tg.connInfo.lastSuccessfulPing.IsZero() {
+			tg.connInfo.lastSuccessfulPing = now
+		}
+		tg.connInfo.missed = 0
+		tg.nextPing = time.Time{}
+		tg.publish()
+	}
+}
+
+// Tell the instance of autoping whose process ID is in the pid file to pause
+// its pings
+func pauseCommand(args []string) error {
+	return signalCommand("pause", pauseSignal, args)
+}
+
+// Tell the instance of autoping whose process ID is in the pid file to resume
+// its pings
+func resumeCommand(args []string) error {
+	return signalCommand("resume", resumeSignal, args)
+}
+
+// Send the supplied signal to the instance of autoping whose process ID is in
+// the pid file given in the supplied arguments of the supplied command
+func signalCommand(command string, sig os.Signal, args []string) error {
+	fs := subcommandFlags(command, "pidfile")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || len(*pidFileFlag) == 0 {
+		return fmt.Errorf("usage: autoping %s -pidfile <file>", command)
+	}
+	if sig == nil {
+		return errors.New("pings can't be paused on Windows")
+	}
+	pid, err := readPidFile(*pidFileFlag)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s doesn't exist. Is autoping running with -pidfile?", *pidFileFlag)
+	} else if err != nil {
+		return err
+	}
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil {
+		return fmt.Errorf("telling autoping (pid %d) to %s: %v", pid, command, err)
+	}
+	fmt.Printf("Told autoping (pid %d) to %s its pings\n", pid, command)
+	return nil
+}
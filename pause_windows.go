@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// Windows has no signals to spare, so pings can't be paused there
+var pauseSignal, resumeSignal os.Signal
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Reports in PDF are the text digest laid out on A4 pages, for customers who
+// would rather file a document than an email. The PDF is written directly,
+// in the Courier fonts every PDF reader has, so the columns of the digest
+// line up as they do in a terminal and no font has to be embedded. Characters
+// the fonts don't have are written as ?
+
+const (
+	pdfPageWidth  = 595 // A4, in points
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	pdfFontSize   = 9
+	pdfLeading    = 11                                                    // Points from one line to the next
+	pdfLineChars  = (pdfPageWidth - 2*pdfMargin) * 10 / (pdfFontSize * 6) // Courier is 0.6 of the font size wide
+	pdfPageLines  = (pdfPageHeight-2*pdfMargin)/pdfLeading - 3            // Leaving room for the title and page number
+	pdfTitleSize  = 12                                                    // Size of the title at the top of each page
+)
+
+// Characters of the text that WinAnsiEncoding has outside Latin-1, or that
+// are written as one it has
+var pdfWinAnsi = map[rune]byte{'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '\u00a0': 0xa0, '\u202f': 0xa0, '\u2009': ' '}
+
+// Write the supplied text to w as a PDF with the supplied title at the top of
+// every page. Lines too long for the page are wrapped
+func writePDF(w io.Writer, title string, text []byte) error {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(text))
+	for sc.Scan() {
+		line := []rune(strings.TrimRight(sc.Text(), " \r"))
+		for len(line) > pdfLineChars {
+			lines = append(lines, string(line[:pdfLineChars]))
+			line = append([]rune("    "), line[pdfLineChars:]...)
+		}
+		lines = append(lines, string(line))
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	var pages [][]string
+	for len(lines) > pdfPageLines {
+		pages = append(pages, lines[:pdfPageLines])
+		lines = lines[pdfPageLines:]
+	}
+	pages = append(pages, lines)
+
+	// Objects are the catalog, the page tree, the two fonts and the document
+	// information, followed by each page and its contents
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title %s /Producer (autoping) /CreationDate (D:%s) >>", pdfString(title),
+			time.Now().UTC().Format("20060102150405Z")))
+	for i, page := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT /F2 %d Tf %d %d Td %s Tj ET\n", pdfTitleSize, pdfMargin,
+			pdfPageHeight-pdfMargin-pdfTitleSize, pdfString(title))
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLeading, pdfMargin,
+			pdfPageHeight-pdfMargin-pdfTitleSize-2*pdfLeading)
+		for _, line := range page {
+			fmt.Fprintf(&content, "%s Tj T*\n", pdfString(line))
+		}
+		fmt.Fprintf(&content, "ET\nBT /F1 %d Tf %d %d Td %s Tj ET\n", pdfFontSize, pdfMargin, pdfMargin/2,
+			pdfString(fmt.Sprintf("Page %d of %d", i+1, len(pages))))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 7+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	// The cross-reference table gives where each object starts
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// Returns the supplied text as a PDF string in WinAnsiEncoding
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		c, ok := pdfWinAnsi[r]
+		switch {
+		case ok:
+		case r == '\t':
+			c = ' '
+		case r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		default:
+			c = '?'
+		}
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x80:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
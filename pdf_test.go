@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A PDF report has a page for every pdfPageLines lines, every object where
+// the cross-reference table says it is, and its text escaped
+func TestWritePDF(t *testing.T) {
+	var text strings.Builder
+	text.WriteString("autoping digest (test) \\ 99.5 %\n")
+	for i := 0; i < 2*pdfPageLines; i++ {
+		fmt.Fprintf(&text, "  line %d – %s\n", i, strings.Repeat("x", i))
+	}
+	var out bytes.Buffer
+	if err := writePDF(&out, "autoping monthly report for June 2018", []byte(text.String())); err != nil {
+		t.Fatal(err)
+	}
+	pdf := out.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("not a PDF")
+	}
+	if got := strings.Count(pdf, "/Type /Page "); got != 3 {
+		t.Errorf("%d pages, want 3 for %d lines with long ones wrapped", got, 2*pdfPageLines+1)
+	}
+	if !strings.Contains(pdf, `(autoping digest \(test\) \\ 99.5 %) Tj`) {
+		t.Error("brackets and backslashes aren't escaped")
+	}
+	if !strings.Contains(pdf, `(  line 0 \226) Tj`) {
+		t.Error("dashes aren't written in WinAnsiEncoding")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(m[1])
+	if !strings.HasPrefix(pdf[xref:], "xref\n") {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[xref:], -1)
+	for i, e := range entries {
+		off, _ := strconv.Atoi(e[1])
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !strings.HasPrefix(pdf[off:], want) {
+			t.Errorf("object %d isn't at offset %d", i+1, off)
+		}
+	}
+	for _, s := range regexp.MustCompile(`<< /Length (\d+) >>\nstream\n`).FindAllStringSubmatchIndex(pdf, -1) {
+		n, _ := strconv.Atoi(pdf[s[2]:s[3]])
+		if !strings.HasPrefix(pdf[s[1]+n:], "endstream") {
+			t.Errorf("stream at %d isn't %d bytes long", s[1], n)
+		}
+	}
+}
+
+// A PDF is attached in base64, so it arrives as it was written
+func TestReportMessagePDF(t *testing.T) {
+	report := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n" + strings.Repeat("stream\x00\xff", 40))
+	msg, err := reportMessage("a@example.com", []string{"b@example.com"}, "autoping monthly report",
+		"application/pdf", "autoping-sla.pdf", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Get("Content-Type") != "application/pdf" || p.Header.Get("Content-Transfer-Encoding") != "base64" {
+		t.Errorf("attached as %s in %s", p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"))
+	}
+	body, _ := ioutil.ReadAll(p)
+	got, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+	if err != nil || !bytes.Equal(got, report) {
+		t.Errorf("the PDF didn't come back as it was: %v", err)
+	}
+}
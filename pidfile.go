@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Two instances of autoping logging to the same file would interleave their
+// lines and read each other's pings back as their own. On startup autoping
+// takes a lock on its log file, held until it exits, and refuses to start if
+// another instance has it. With -pidfile it also writes its process ID to a
+// file, removed when it shuts down, which "autoping stop" reads to tell it to
+// shut down
+
+var pidFileFlag = flag.String("pidfile", "", "file to write the process ID to, for 'autoping stop'")
+
+var errLocked = errors.New("the log file is locked by another autoping")
+
+// How long autoping stop waits for the instance to shut down
+const stopWait = 30 * time.Second
+
+// Write the process ID to the file set with -pidfile, if any. A file left
+// behind by an instance that didn't shut down cleanly is overwritten, since
+// the lock on the log file says that instance is gone
+func writePidFile() error {
+	if len(*pidFileFlag) == 0 {
+		return nil
+	}
+	return ioutil.WriteFile(*pidFileFlag, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// Remove the file written by writePidFile, if any
+func removePidFile() {
+	if len(*pidFileFlag) == 0 {
+		return
+	}
+	if err := os.Remove(*pidFileFlag); err != nil {
+		eLog.Printf("Removing pid file: %v", err)
+	}
+}
+
+// Returns the process ID in the supplied pid file
+func readPidFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid < 1 {
+		return 0, fmt.Errorf("%s doesn't hold a process ID", path)
+	}
+	return pid, nil
+}
+
+// Tell the instance of autoping whose process ID is in the pid file to shut
+// down, and wait for it to remove the file as it does
+func stopCommand(args []string) error {
+	fs := subcommandFlags("stop", "pidfile")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || len(*pidFileFlag) == 0 {
+		return errors.New("usage: autoping stop -pidfile <file>")
+	}
+	pid, err := readPidFile(*pidFileFlag)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s doesn't exist. Is autoping running with -pidfile?", *pidFileFlag)
+	} else if err != nil {
+		return err
+	}
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = stopProcess(p)
+	}
+	if err != nil {
+		return fmt.Errorf("stopping autoping (pid %d): %v. If it isn't running, remove %s",
+			pid, err, *pidFileFlag)
+	}
+
+	for deadline := time.Now().Add(stopWait); time.Now().Before(deadline); {
+		if _, err := os.Stat(*pidFileFlag); os.IsNotExist(err) {
+			fmt.Printf("Stopped autoping (pid %d)\n", pid)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("autoping (pid %d) is still running %v after being told to stop", pid, stopWait)
+}
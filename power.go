@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// On a laptop running on battery, autoping pings less often and skips the
+// latency profile check, to keep the CPU asleep for longer. Low-power mode
+// can also be forced with -low-power
+
+const lowPowerInterval = 5 * time.Minute // Time between pings in low-power mode
+
+// Returns true if the machine is running on battery. Only Linux reports its
+// power state, through sysfs. Anywhere else, or if there is no battery, this
+// returns false
+func onBattery() bool {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+	battery, mains := false, false
+	for _, s := range supplies {
+		kind, err := ioutil.ReadFile(filepath.Join(s, "type"))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(string(kind)) {
+		case "Battery":
+			battery = true
+		case "Mains", "USB":
+			online, err := ioutil.ReadFile(filepath.Join(s, "online"))
+			if err == nil && strings.TrimSpace(string(online)) == "1" {
+				mains = true
+			}
+		}
+	}
+	return battery && !mains
+}
+
+// Returns true if autoping should save power
+func lowPower() bool {
+	return *lowPowerFlag || onBattery()
+}
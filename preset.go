@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Links differ in what is normal for them: a satellite link drops out for a
+// few seconds now and then and has RTTs all over the place, while a second of
+// silence in a datacenter is already news. -profile picks the interval,
+// timeout, latency tier thresholds and outage thresholds suited to a kind of
+// link. Settings given as flags or in the config file win over the profile's
+
+var presetFlag = flag.String("profile", "home", "tuning for the kind of link: "+strings.Join(presetNames(), ", ")+
+	". Flags and the config file win over it")
+
+type preset struct {
+	interval    time.Duration  // Time between pings
+	timeout     time.Duration  // Time to wait for a pong. Half the interval, up to 30s, if 0
+	minOutage   time.Duration  // Time without a pong before an outage is declared
+	outageAfter int            // Missed pings before an outage is declared, instead of minOutage, if more than 0
+	latency     config.Latency // Latency tier thresholds
+}
+
+var presets = map[string]preset{
+	// What autoping did before there were profiles
+	"home": {interval: defaultInterval, minOutage: 2 * time.Minute, latency: config.DefaultLatency},
+
+	// RTTs well under a millisecond that double on their own, but every missed
+	// ping counts
+	"datacenter": {interval: 10 * time.Second, timeout: time.Second, minOutage: 30 * time.Second, outageAfter: 3,
+		latency: config.Latency{Mild: 3, Severe: 5, Extreme: 20}},
+
+	// Handovers between satellites drop a few pings, and RTTs of a
+	// geostationary link reach seconds under load
+	"satellite": {interval: 30 * time.Second, timeout: 10 * time.Second, minOutage: 3 * time.Minute,
+		latency: config.Latency{Mild: 3, Severe: 5, Extreme: 15}},
+}
+
+// Returns the names of the profiles, sorted
+func presetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the profile picked with -profile
+func currentPreset() (preset, error) {
+	p, ok := presets[*presetFlag]
+	if !ok {
+		return preset{}, fmt.Errorf("unknown profile '%s'. Use one of %s", *presetFlag,
+			strings.Join(presetNames(), ", "))
+	}
+	return p, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	ping "github.com/go-ping/ping"
+	"github.com/kurankat/autoping-go/config"
+)
+
+// A target given as an http:// or https:// URL is probed with an HTTP request
+// instead of pinged, so one autoping can tell the network being down from a
+// web service or API being down. The time to the first byte of the response
+// stands in for the RTT, and is logged, sampled and put in latency tiers like
+// one. A response that isn't 2xx counts as a missed pong with the failure
+// http_error, and so does a request that can't connect. A request that times
+// out is a missed pong like any other. The request is a GET, or a HEAD if
+// set under overrides, on a new connection every time, so the time includes
+// looking the name up, connecting and the TLS handshake
+
+const probeBodyLimit = 64 << 10 // Bytes of the response read, to free the server's side
+
+var probeClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true}}
+
+type httpProber struct{} // Probes http:// and https:// targets with requests
+
+// Method to check that the supplied HTTP target has a host
+func (httpProber) check(s *settings, addr string) error {
+	if u, err := url.Parse(addr); err != nil || len(u.Host) == 0 {
+		return fmt.Errorf("target %s must be http:// or https:// and a host", addr)
+	}
+	return nil
+}
+
+// Method to return the host of the supplied HTTP target
+func (httpProber) host(s *settings, addr string) (string, error) {
+	return urlHost(addr)
+}
+
+// Method to return the options of HTTP targets set in the supplied overrides
+func (httpProber) options(o config.Override) string {
+	if o.HTTPOptions != (config.HTTPOptions{}) {
+		return "a method"
+	}
+	return ""
+}
+
+// Returns the host of the supplied target given as a URL
+func urlHost(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
+
+// Returns a new random ID for a probe, to tell its answer from others
+func probeID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// An error that is down to running out of time
+type timeoutError struct {
+	error
+}
+
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Method to record a probe fired at the supplied time that failed with the
+// supplied error. Running out of time is a missed pong like any other, and
+// errors that aren't put down to the network or the local machine are put
+// down to the service probed, with the supplied category
+func (tg *target) probeFailed(t time.Time, service string, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketLoss: 100})
+		return
+	}
+	category := classifyFailure(err)
+	if category == failSocket {
+		// Refused connections and answers turned down are the service's doing
+		category = service
+	}
+	tg.pingFailed(t, category, err)
+}
+
+// Method to return the HTTP method the target is probed with
+func (tg *target) probeMethod() string {
+	if m := currentSettings().overrides[tg.addr].Method; len(m) > 0 {
+		return m
+	}
+	return http.MethodGet
+}
+
+// Method to probe the supplied target with an HTTP request, in place of a ping
+func (httpProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the request was sent
+	ctx, cancel := context.WithTimeout(context.Background(), tg.timeout())
+	defer cancel()
+	req, err := http.NewRequest(tg.probeMethod(), tg.addr, nil)
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+	var ttfb time.Duration
+	trace := &httptrace.ClientTrace{GotFirstResponseByte: func() { ttfb = time.Since(t) }}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+	req.Header.Set("User-Agent", "autoping/"+version)
+
+	tg.logf(tLog, "Sending %s %s", req.Method, tg.addr)
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		tg.probeFailed(t, failHTTP, err)
+		return
+	}
+	if ttfb == 0 {
+		ttfb = time.Since(t)
+	}
+	n, _ := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, probeBodyLimit))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		tg.pingFailed(t, failHTTP, fmt.Errorf("%s %s: %s", req.Method, tg.addr, resp.Status))
+		return
+	}
+
+	if tg.samplePong(ttfb) {
+		tg.logf(pLog, "%d bytes from %s: status=%d time=%v", n, tg.addr, resp.StatusCode, ttfb)
+	}
+	tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketsRecv: 1, Addr: tg.addr,
+		Rtts: []time.Duration{ttfb}, MinRtt: ttfb, MaxRtt: ttfb, AvgRtt: ttfb})
+}
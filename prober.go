@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Targets given as a URL are probed over the protocol its scheme names
+// instead of pinged. Each kind of probe is a prober, found by the scheme, so
+// pinging, checking the settings and -check go through the same few methods
+// whatever the kind, and a new kind only has to be added to probers. Settings
+// only one kind of target can have are kept in options of that kind, and a
+// target of another kind given them is turned down
+
+type prober interface {
+	// Probe the supplied target once, in place of a ping, and record the
+	// result as a ping's
+	probe(tg *target)
+
+	// Check that the supplied target, which is of the prober's kind, can be
+	// probed as it is set up in the supplied settings
+	check(s *settings, addr string) error
+
+	// Returns the host the probes of the supplied target go to, which -check
+	// resolves
+	host(s *settings, addr string) (string, error)
+
+	// Returns the options of the prober's kind set in the supplied overrides,
+	// as they go by in errors, or "" if none are
+	options(o config.Override) string
+}
+
+// Embedded in probers of targets that have no options of their own
+type noOptions struct{}
+
+// Method to return the options of the prober's kind set in the supplied
+// overrides, of which there are none
+func (noOptions) options(o config.Override) string {
+	return ""
+}
+
+// Probers by the scheme of the targets they probe
+var probers = map[string]prober{
+	"http":  httpProber{},
+	"https": httpProber{},
+	"mail":  mailProber{},
+	"udp":   udpProber{},
+	"sip":   sipProber{},
+	"tls":   tlsProber{},
+	"quic":  quicReachProber{},
+	"rtp":   rtpProber{},
+}
+
+// Returns the prober of the supplied target, or nil if it is pinged. Its
+// scheme is what comes before the first colon, which sip: URIs have no //
+// after. Addresses and hostnames pinged have no scheme, and no prober has
+// the first part of an IPv6 address for one
+func proberFor(addr string) prober {
+	i := strings.IndexByte(addr, ':')
+	if i < 0 {
+		return nil
+	}
+	return probers[addr[:i]]
+}
+
+// Returns the schemes of the supplied prober, or of every prober if it is nil,
+// sorted and written as a list
+func proberSchemes(p prober) string {
+	var schemes []string
+	for scheme, q := range probers {
+		if p == nil || q == p {
+			schemes = append(schemes, scheme)
+		}
+	}
+	sort.Strings(schemes)
+	if len(schemes) < 2 {
+		return strings.Join(schemes, "")
+	}
+	return strings.Join(schemes[:len(schemes)-1], ", ") + " or " + schemes[len(schemes)-1]
+}
+
+// Check that every target in the supplied settings that looks like a URL is
+// one autoping can probe, that each can be probed as it is set up, and that
+// only targets of a kind have the options of that kind
+func checkProbes(s *settings) error {
+	for _, addr := range s.pingAddrs() {
+		p := proberFor(addr)
+		if p == nil {
+			if strings.Contains(addr, "://") {
+				return fmt.Errorf("target %s must be a hostname, an IP address or a URL with the scheme %s",
+					addr, proberSchemes(nil))
+			}
+			continue
+		}
+		if err := p.check(s, addr); err != nil {
+			return err
+		}
+	}
+	for addr, o := range s.overrides {
+		own := proberFor(addr)
+		for _, p := range probers {
+			if opts := p.options(o); len(opts) > 0 && p != own {
+				return fmt.Errorf("%s has %s, but its scheme isn't %s", addr, opts, proberSchemes(p))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Targets are probed by the prober of their scheme, and pinged if they have
+// none
+func TestProberFor(t *testing.T) {
+	for addr, want := range map[string]prober{
+		"https://example.com/health": httpProber{},
+		"mail://probe@example.com":   mailProber{},
+		"sip:pbx.example.com:5080":   sipProber{},
+		"rtp://office.example.com:1": rtpProber{},
+		"example.com":                nil,
+		"2001:db8::1":                nil,
+		"ftp://example.com":          nil,
+	} {
+		if got := proberFor(addr); got != want {
+			t.Errorf("prober of %s is %T, want %T", addr, got, want)
+		}
+	}
+}
+
+// URLs of no prober are turned down, and so are options set for a target of
+// another kind
+func TestCheckProbes(t *testing.T) {
+	for _, c := range []struct {
+		addr     string
+		override config.Override
+		want     string
+	}{
+		{"ftp://example.com", config.Override{}, "a URL with the scheme http, https, mail, quic, rtp, sip, tls or udp"},
+		{"https://", config.Override{}, "must be http:// or https:// and a host"},
+		{"udp://example.com:53", config.Override{HTTPOptions: config.HTTPOptions{Method: "HEAD"}},
+			"has a method, but its scheme isn't http or https"},
+		{"https://example.com", config.Override{UDPOptions: config.UDPOptions{Payload: config.Payload("x")}},
+			"has a payload or an answer to expect, but its scheme isn't udp"},
+	} {
+		s := &settings{targets: []string{c.addr}, timeout: time.Second,
+			overrides: map[string]config.Override{c.addr: c.override}}
+		if err := checkProbes(s); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %v, want %s", c.addr, err, c.want)
+		}
+	}
+
+	s := &settings{targets: []string{"udp://example.com:53"}, overrides: map[string]config.Override{
+		"udp://example.com:53": {UDPOptions: config.UDPOptions{Payload: config.Payload("x")}}}}
+	if err := checkProbes(s); err != nil {
+		t.Errorf("a udp:// target with a payload is turned down: %v", err)
+	}
+}
+
+// The options of each kind of target are set alongside the rest of its
+// overrides in the file
+func TestOverrideOptions(t *testing.T) {
+	for name, text := range map[string]string{
+		"options.yaml": "targets: [udp://example.com:53]\noverrides:\n  https://example.com:\n    method: HEAD\n" +
+			"  udp://example.com:53:\n    interval: 5s\n    payload: ping\n",
+		"options.toml": "targets = [\"udp://example.com:53\"]\n[overrides.\"https://example.com\"]\nmethod = \"HEAD\"\n" +
+			"[overrides.\"udp://example.com:53\"]\ninterval = \"5s\"\npayload = \"ping\"\n",
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if m := cfg.Overrides["https://example.com"].Method; m != "HEAD" {
+			t.Errorf("%s: method %q, want HEAD", name, m)
+		}
+		o := cfg.Overrides["udp://example.com:53"].Merge(config.Override{
+			UDPOptions: config.UDPOptions{Expect: config.Payload("pong")}})
+		if string(o.Payload) != "ping" || string(o.Expect) != "pong" || time.Duration(o.Interval) != 5*time.Second {
+			t.Errorf("%s: merged overrides %+v", name, o)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// The profile detector compares the distribution of today's RTTs against the
+// distribution of the previous days using a two-sample Kolmogorov-Smirnov test.
+// This catches a link that has become slower overall even when no single ping
+// crosses the dodgy latency cutoff.
+
+const (
+	profileDays   = 7         // Number of previous days kept as the baseline
+	profilePeriod = time.Hour // Time of samples needed today before comparing
+	profileAlpha  = 1.63      // KS coefficient for a significance level of 0.01
+)
+
+type rttProfile struct {
+	day      int         // Day of the year the samples in today belong to
+	today    []float64   // RTTs of successful pings since midnight
+	baseline [][]float64 // RTTs of previous days, oldest first
+	changed  bool        // Has a change already been logged today?
+}
+
+// Method to add the RTT of a successful ping to the target's samples for
+// today. Every hour's worth of samples, today's distribution is tested against
+// the baseline
+func (tg *target) checkProfile(t time.Time, rtt time.Duration) {
+	p := &tg.profile
+	p.load(t, rtt)
+	if p.changed || len(p.baseline) == 0 || len(p.today)%tg.pingsIn(profilePeriod) != 0 {
+		return
+	}
+
+	var base []float64
+	for _, d := range p.baseline {
+		base = append(base, d...)
+	}
+	d, crit := ksTest(p.today, base)
+	tg.logf(tLog, "KS statistic for today's RTTs is %.3f (critical value %.3f)", d, crit)
+	if d > crit {
+		p.changed = true
+		tg.logf(oLog, "Latency profile changed. Median RTT today %v, baseline %v (D=%.3f)",
+			time.Duration(median(p.today)), time.Duration(median(base)), d)
+	}
+}
+
+// Method to add the RTT of a successful ping to today's samples without
+// testing them. When the day rolls over, today's samples become part of the
+// baseline
+func (p *rttProfile) load(t time.Time, rtt time.Duration) {
+	day := t.In(timeZone).YearDay()
+	if p.day != day {
+		if len(p.today) > 0 {
+			p.baseline = append(p.baseline, p.today)
+			if len(p.baseline) > profileDays {
+				p.baseline = p.baseline[1:]
+			}
+		}
+		p.day = day
+		p.today = nil
+		p.changed = false
+	}
+	p.today = append(p.today, float64(rtt.Nanoseconds()))
+}
+
+// Two-sample Kolmogorov-Smirnov test. Returns the D statistic (the largest
+// distance between the two empirical distribution functions) and the critical
+// value above which the two samples are considered to differ
+func ksTest(a, b []float64) (d, crit float64) {
+	x := append([]float64(nil), a...)
+	y := append([]float64(nil), b...)
+	sort.Float64s(x)
+	sort.Float64s(y)
+
+	n, m := float64(len(x)), float64(len(y))
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		v := math.Min(x[i], y[j])
+		for i < len(x) && x[i] <= v {
+			i++
+		}
+		for j < len(y) && y[j] <= v {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/n-float64(j)/m))
+	}
+	crit = profileAlpha * math.Sqrt((n+m)/(n*m))
+	return d, crit
+}
+
+// Returns the median of the supplied samples
+func median(s []float64) float64 {
+	c := append([]float64(nil), s...)
+	sort.Float64s(c)
+	if len(c)%2 == 0 {
+		return (c[len(c)/2-1] + c[len(c)/2]) / 2
+	}
+	return c[len(c)/2]
+}
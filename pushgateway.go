@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Runs with -once are over before Prometheus could scrape them, so their
+// results can be pushed to a Pushgateway with -pushgateway instead. They go in
+// the group of job "autoping" and this host's name as instance, replacing the
+// last run's, so each run from cron updates the same series
+
+var pushgatewayFlag = flag.String("pushgateway", "", "Prometheus Pushgateway to push the results of -once to, e.g. http://pushgateway:9091")
+
+const pushgatewayJob = "autoping" // Job the results are grouped under
+
+var pushgatewayClient = &http.Client{Timeout: 30 * time.Second}
+
+// Escapes label values in the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Push whether each target answered since the supplied time, and how fast, to
+// the Pushgateway set with -pushgateway
+func pushResults(since time.Time) error {
+	instance, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	var up, rtt bytes.Buffer
+	fmt.Fprintln(&up, "# HELP autoping_up Whether the target answered the last ping.")
+	fmt.Fprintln(&up, "# TYPE autoping_up gauge")
+	fmt.Fprintln(&rtt, "# HELP autoping_rtt_seconds RTT of the last pong of the target.")
+	fmt.Fprintln(&rtt, "# TYPE autoping_rtt_seconds gauge")
+	for _, tg := range currentTargets() {
+		labels := tg.metricLabels()
+		if r, ok := tg.hist.latest(); ok && r.ok && !r.t.Before(since) {
+			fmt.Fprintf(&up, "autoping_up{%s} 1\n", labels)
+			fmt.Fprintf(&rtt, "autoping_rtt_seconds{%s} %g\n", labels, r.rtt.Seconds())
+			continue
+		}
+		fmt.Fprintf(&up, "autoping_up{%s} 0\n", labels)
+	}
+	body := io.MultiReader(&up, &rtt, strings.NewReader(fmt.Sprintf(
+		"# HELP autoping_last_run_timestamp_seconds Time of the last run.\n"+
+			"# TYPE autoping_last_run_timestamp_seconds gauge\n"+
+			"autoping_last_run_timestamp_seconds %d\n", since.Unix())))
+
+	// PUT replaces the whole group, so targets no longer pinged drop out
+	u := strings.TrimRight(*pushgatewayFlag, "/") + "/metrics/job/" + url.PathEscape(pushgatewayJob) +
+		"/instance/" + url.PathEscape(instance)
+	req, err := http.NewRequest("PUT", u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := pushgatewayClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Method to return the target's labels in the Prometheus text format, the
+// target label first
+func (tg *target) metricLabels() string {
+	pairs := []string{fmt.Sprintf(`target="%s"`, labelEscaper.Replace(tg.addr))}
+	labels := currentSettings().labels[tg.addr]
+	var names []string
+	for name := range labels {
+		if name != "target" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name])))
+	}
+	return strings.Join(pairs, ",")
+}
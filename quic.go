@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	ping "github.com/go-ping/ping"
+)
+
+// A target given as quic:// and a host and port, e.g. quic://example.com:443,
+// gets a QUIC reachability probe: whether UDP gets through to its QUIC server,
+// and how quickly, to compare with an https:// target of the same host, as
+// middleboxes often treat UDP on port 443 differently from TCP. The probe is
+// a QUIC Initial packet of a version no server speaks, which every QUIC server
+// has to answer with the versions it does speak. That takes one round trip
+// and no QUIC stack, so the time to the answer stands in for the RTT of the
+// QUIC path. An answer that isn't a version negotiation for the packet sent,
+// or a port nothing listens on, is a missed pong with the failure quic_error.
+//
+// It is not an HTTP/3 probe. It doesn't complete a handshake or make a
+// request, which needs a QUIC stack the standard library doesn't have, so
+// HTTP/3 requests are left to a request of their own
+
+const (
+	quicPort        = "443"      // Port probed when the target has none
+	quicInitialSize = 1200       // Bytes an Initial packet is padded to, the least servers answer
+	quicGreaseVer   = 0x1a2a3a4a // Version reserved to force version negotiation
+)
+
+type quicReachProber struct{ noOptions } // Probes whether quic:// targets can be reached, with version negotiation
+
+// Returns the host and port of the supplied QUIC target
+func quicHostPort(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if len(u.Hostname()) == 0 {
+		return "", fmt.Errorf("target %s must be quic:// and a host, and a port if it isn't 443", addr)
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = quicPort
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// Method to check that the supplied QUIC target has a host
+func (quicReachProber) check(s *settings, addr string) error {
+	_, err := quicHostPort(addr)
+	return err
+}
+
+// Method to return the host of the supplied QUIC target
+func (quicReachProber) host(s *settings, addr string) (string, error) {
+	return urlHost(addr)
+}
+
+// Method to probe the supplied target with a QUIC packet forcing version
+// negotiation, in place of a ping
+func (quicReachProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the packet was sent
+	deadline := t.Add(tg.timeout())
+	hostport, err := quicHostPort(tg.addr)
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+	packet, dcid, scid, err := quicInitial()
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+
+	tg.logf(tLog, "Sending QUIC version negotiation to %s", hostport)
+	conn, err := net.DialTimeout("udp", hostport, time.Until(deadline))
+	if err != nil {
+		tg.probeFailed(t, failQUIC, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(packet); err != nil {
+		tg.probeFailed(t, failQUIC, err)
+		return
+	}
+	answer := make([]byte, udpAnswerLimit)
+	n, err := conn.Read(answer)
+	rtt := time.Since(t)
+	if err != nil {
+		tg.probeFailed(t, failQUIC, err)
+		return
+	}
+	versions, err := parseVersionNegotiation(answer[:n], dcid, scid)
+	if err != nil {
+		tg.pingFailed(t, failQUIC, fmt.Errorf("answer from %s: %v", conn.RemoteAddr(), err))
+		return
+	}
+
+	if tg.samplePong(rtt) {
+		tg.logf(pLog, "%d bytes from %s: versions=%s time=%v", n, conn.RemoteAddr(), strings.Join(versions, ","), rtt)
+	}
+	tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketsRecv: 1, Addr: tg.addr,
+		Rtts: []time.Duration{rtt}, MinRtt: rtt, MaxRtt: rtt, AvgRtt: rtt})
+}
+
+// Returns a QUIC Initial packet of a version no server speaks, padded to the
+// size servers answer, along with the random connection IDs it carries
+func quicInitial() (packet, dcid, scid []byte, err error) {
+	ids := make([]byte, 16)
+	if _, err := rand.Read(ids); err != nil {
+		return nil, nil, nil, err
+	}
+	dcid, scid = ids[:8], ids[8:]
+	packet = make([]byte, 0, quicInitialSize)
+	packet = append(packet, 0xc0) // Long header, fixed bit, Initial
+	packet = append(packet, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(packet[1:], quicGreaseVer)
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	return packet[:quicInitialSize], dcid, scid, nil
+}
+
+// Returns the versions offered by the supplied version negotiation packet,
+// as hex, or an error if it isn't one answering the packet with the supplied
+// connection IDs
+func parseVersionNegotiation(p, dcid, scid []byte) ([]string, error) {
+	if len(p) < 7 || p[0]&0x80 == 0 || binary.BigEndian.Uint32(p[1:5]) != 0 {
+		return nil, fmt.Errorf("%d bytes that aren't a QUIC version negotiation", len(p))
+	}
+	// The server's IDs are the other way round from the client's
+	rest := p[5:]
+	var ids [2][]byte
+	for i := range ids {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, errors.New("QUIC version negotiation cut short")
+		}
+		ids[i], rest = rest[1:1+int(rest[0])], rest[1+int(rest[0]):]
+	}
+	if !bytes.Equal(ids[0], scid) || !bytes.Equal(ids[1], dcid) {
+		return nil, errors.New("QUIC version negotiation for another connection")
+	}
+	var versions []string
+	for ; len(rest) >= 4; rest = rest[4:] {
+		versions = append(versions, fmt.Sprintf("%08x", binary.BigEndian.Uint32(rest)))
+	}
+	if len(versions) == 0 {
+		return nil, errors.New("QUIC version negotiation offering no versions")
+	}
+	return versions, nil
+}
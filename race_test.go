@@ -0,0 +1,7 @@
+//go:build race
+
+package main
+
+// The race detector allocates for its own bookkeeping, so allocations aren't
+// counted under it
+const raceEnabled = true
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// From sites where Prometheus can't scrape autoping, behind NAT or on a
+// dynamic IP, the result of every ping can be pushed to a remote-write
+// receiver such as Mimir, Thanos or VictoriaMetrics set under remote_write in
+// the config file. Each ping is an autoping_up sample of 1 or 0, and each pong
+// an autoping_rtt_seconds sample, both at the time the ping was fired and
+// labelled with the target and its labels. Samples that can't be pushed, as
+// during an outage of the link itself, are kept and pushed with the next
+// batch, so the receiver still gets to see the outage
+
+const (
+	remoteWriteInterval = time.Minute // Time between pushes unless set
+	maxPendingSamples   = 100000      // Samples kept while the receiver can't be reached
+)
+
+type rwLabel struct {
+	name, value string
+}
+
+type rwSample struct {
+	labels []rwLabel // Labels of the series, sorted by name
+	t      time.Time // Time the ping was fired
+	value  float64
+}
+
+var (
+	remoteMu      sync.Mutex
+	remoteWrite   config.RemoteWrite // Receiver to push to. No URL if none
+	remoteSamples []rwSample         // Samples not pushed yet, oldest first
+	remoteDropped int                // Samples dropped since the last push because too many were pending
+	remoteFailing bool               // Did the last push fail?
+)
+
+var remoteClient = &http.Client{Timeout: 30 * time.Second}
+
+// Push samples to the receiver set in the supplied settings from now on, if
+// any. Samples already pending are kept
+func setRemoteWrite(cfg *config.Config) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	remoteWrite = cfg.RemoteWrite
+}
+
+// Method to queue the result of a ping for the remote-write receiver, if there
+// is one
+func (tg *target) queueSample(t time.Time, ok bool, rtt time.Duration) {
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	if len(remoteWrite.URL) == 0 {
+		return
+	}
+	up := 0.0
+	if ok {
+		up = 1
+		remoteQueue(rwSample{tg.series("autoping_rtt_seconds"), t, rtt.Seconds()})
+	}
+	remoteQueue(rwSample{tg.series("autoping_up"), t, up})
+}
+
+// Method to return the labels of the target's series of the supplied metric,
+// sorted by name as remote write requires. The target label wins over labels
+// of the same name set for the target or the receiver
+func (tg *target) series(metric string) []rwLabel {
+	labels := map[string]string{}
+	for name, value := range remoteWrite.Labels {
+		labels[name] = value
+	}
+	for name, value := range currentSettings().labels[tg.addr] {
+		labels[name] = value
+	}
+	labels["target"] = tg.addr
+	labels["__name__"] = metric
+
+	var series []rwLabel
+	for name, value := range labels {
+		series = append(series, rwLabel{name, value})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].name < series[j].name })
+	return series
+}
+
+// Add the supplied samples to those to push, dropping the oldest if too many
+// are pending. The caller holds remoteMu
+func remoteQueue(samples ...rwSample) {
+	remoteSamples = append(remoteSamples, samples...)
+	if n := len(remoteSamples) - maxPendingSamples; n > 0 {
+		remoteSamples = remoteSamples[n:]
+		remoteDropped += n
+	}
+}
+
+// Push the pending samples every remote-write interval until the supplied
+// context is done
+func pushSamples(ctx context.Context) {
+	defer crashed("remote write")
+	for {
+		remoteMu.Lock()
+		every := time.Duration(remoteWrite.Interval)
+		remoteMu.Unlock()
+		if every == 0 {
+			every = remoteWriteInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(every):
+			pushRemote()
+		}
+	}
+}
+
+// Push the pending samples to the receiver, if there is one. Samples are kept
+// for the next push if the receiver couldn't be reached or had trouble, and
+// dropped if it turned them down
+func pushRemote() {
+	remoteMu.Lock()
+	rw, samples, dropped := remoteWrite, remoteSamples, remoteDropped
+	remoteSamples, remoteDropped = nil, 0
+	remoteMu.Unlock()
+	if len(rw.URL) == 0 || len(samples) == 0 {
+		return
+	}
+	if dropped > 0 {
+		eLog.Printf("Remote write: dropped the %d oldest samples, more than %d were waiting to be pushed",
+			dropped, maxPendingSamples)
+	}
+
+	retry, err := postWriteRequest(rw, encodeWriteRequest(samples))
+	remoteMu.Lock()
+	defer remoteMu.Unlock()
+	switch {
+	case err != nil && retry:
+		if !remoteFailing {
+			eLog.Printf("Remote write to %s failed: %v. Keeping samples to push later", rw.URL, err)
+		}
+		remoteFailing = true
+		queued := remoteSamples
+		remoteSamples = nil
+		remoteQueue(append(samples, queued...)...)
+	case err != nil:
+		eLog.Printf("Remote write to %s turned down %d samples: %v", rw.URL, len(samples), err)
+	case remoteFailing:
+		remoteFailing = false
+		tLog.Printf("Remote write to %s working again, pushed %d samples", rw.URL, len(samples))
+	}
+}
+
+// Send the supplied encoded write request to the supplied receiver. Returns
+// whether it is worth sending again if it fails, as when the receiver can't be
+// reached or answers with a 5xx or 429 status
+func postWriteRequest(rw config.RemoteWrite, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", rw.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, value := range rw.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "autoping/"+version)
+	if len(rw.Username) > 0 {
+		req.SetBasicAuth(rw.Username, rw.Password)
+	}
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Returns a remote-write request holding the supplied samples, as a protobuf
+// WriteRequest compressed with snappy. Samples of the same series go together,
+// in time order
+func encodeWriteRequest(samples []rwSample) []byte {
+	var keys []string
+	bySeries := make(map[string][]rwSample)
+	for _, s := range samples {
+		var key strings.Builder
+		for _, l := range s.labels {
+			fmt.Fprintf(&key, "%s=%q,", l.name, l.value)
+		}
+		if _, ok := bySeries[key.String()]; !ok {
+			keys = append(keys, key.String())
+		}
+		bySeries[key.String()] = append(bySeries[key.String()], s)
+	}
+
+	var req pbuf
+	for _, key := range keys {
+		ss := bySeries[key]
+		sort.SliceStable(ss, func(i, j int) bool { return ss[i].t.Before(ss[j].t) })
+		var ts pbuf
+		for _, l := range ss[0].labels {
+			var label pbuf
+			label.bytes(1, []byte(l.name))
+			label.bytes(2, []byte(l.value))
+			ts.bytes(1, label)
+		}
+		for _, s := range ss {
+			var sample pbuf
+			sample.fixed64(1, math.Float64bits(s.value))
+			sample.varint(2, uint64(s.t.UnixNano()/int64(time.Millisecond)))
+			ts.bytes(2, sample)
+		}
+		req.bytes(1, ts)
+	}
+	return snappyBlock(req)
+}
+
+// A protocol buffer message being encoded
+type pbuf []byte
+
+// Method to append a varint
+func (p *pbuf) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	*p = append(*p, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// Method to append a varint field
+func (p *pbuf) varint(field int, v uint64) {
+	p.uvarint(uint64(field << 3))
+	p.uvarint(v)
+}
+
+// Method to append a 64-bit field
+func (p *pbuf) fixed64(field int, v uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	p.uvarint(uint64(field<<3 | 1))
+	*p = append(*p, buf[:]...)
+}
+
+// Method to append a field of bytes, such as a string or an embedded message
+func (p *pbuf) bytes(field int, b []byte) {
+	p.uvarint(uint64(field<<3 | 2))
+	p.uvarint(uint64(len(b)))
+	*p = append(*p, b...)
+}
+
+// Returns the supplied data in the snappy block format remote write uses. The
+// data is stored as literals rather than compressed, which every snappy
+// decoder reads and which keeps autoping free of a compression library
+func snappyBlock(data []byte) []byte {
+	var out pbuf
+	out.uvarint(uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 65536 {
+			n = 65536
+		}
+		// A literal of up to 65536 bytes: tag 61 followed by its length less one
+		// in two bytes
+		out = append(out, 61<<2, byte((n-1)&0xff), byte((n-1)>>8))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
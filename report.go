@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The daily report summarises the log file with one row per day, as CSV that
+// can be pasted straight into a spreadsheet tracking the ISP's SLA. It covers
+// a month at a time with -m, or any run of days with "autoping report". The
+// digest sums up the same figures per target in a few lines of text
+
+var (
+	pongLine   = regexp.MustCompile(`bytes from .*time=(\S+)$`)
+	cycleLine  = regexp.MustCompile(`^Cycle of (\d+)/(\d+) packets`)
+	failedLine = regexp.MustCompile(`^Ping failed \((\w+)\)`)
+	blipLine   = regexp.MustCompile(`^Blip\. \d+ missed pongs, recovered after (\S+)$`)
+)
+
+// More events of one kind than this in a day are summarised in a digest as one
+// line
+const digestRepeats = 3
+
+// Outages are counted in buckets by duration, since one long outage and many
+// short ones call for very different conversations with the ISP
+var outageBuckets = []struct {
+	name  string        // Name of the bucket, as used in CSV headers
+	label string        // Human readable name of the bucket
+	below time.Duration // Outages shorter than this fall in the bucket
+}{
+	{"outages_lt_2m", "< 2 min", 2 * time.Minute},
+	{"outages_2_10m", "2-10 min", 10 * time.Minute},
+	{"outages_10_60m", "10-60 min", time.Hour},
+	{"outages_gt_1h", "> 1 h", math.MaxInt64},
+}
+
+// Returns the index of the bucket an outage of the supplied duration falls in
+func outageBucket(d time.Duration) int {
+	for i, b := range outageBuckets {
+		if d < b.below {
+			return i
+		}
+	}
+	return len(outageBuckets) - 1
+}
+
+type daySummary struct {
+	recv     int             // Echo replies received
+	lost     int             // Echo requests that got no reply
+	outages  int             // Outages that finished this day
+	blips    int             // Missed pings too short to count as outages
+	downtime time.Duration   // Total duration of those outages
+	buckets  []int           // Number of those outages in each duration bucket
+	rtts     []time.Duration // RTT of every reply
+	maxLoss  float64         // Worst packet loss of a single ping cycle, in %
+	tiers    []int           // Flakey latency periods by worst latency tier
+	failures map[string]int  // Failed pings by category
+}
+
+// Returns an empty summary
+func newDaySummary() *daySummary {
+	return &daySummary{buckets: make([]int, len(outageBuckets)),
+		tiers: make([]int, len(latencyTiers)), failures: make(map[string]int)}
+}
+
+// Method to add a line of the log file to the summary
+func (d *daySummary) add(prefix, msg string) {
+	switch {
+	case prefix == "PING" && pongLine.MatchString(msg):
+		rtt, err := time.ParseDuration(pongLine.FindStringSubmatch(msg)[1])
+		if err == nil {
+			d.recv++
+			d.rtts = append(d.rtts, rtt)
+		}
+	case prefix == "PING" && sampledLine.MatchString(msg):
+		n, _ := strconv.Atoi(sampledLine.FindStringSubmatch(msg)[1])
+		d.recv += n
+	case prefix == "PING" && cycleLine.MatchString(msg):
+		m := cycleLine.FindStringSubmatch(msg)
+		recv, _ := strconv.Atoi(m[1])
+		sent, _ := strconv.Atoi(m[2])
+		// A cycle with none back has its missed pong line too, which counts
+		// one of its packets
+		if recv == 0 {
+			d.lost += sent - 1
+		} else if sent > 0 {
+			d.lost += sent - recv
+			d.maxLoss = math.Max(d.maxLoss, 100*float64(sent-recv)/float64(sent))
+		}
+	case prefix == "OUTAGE" && msg == "Timeout - Missed pong":
+		d.lost++
+		d.maxLoss = 100
+		d.failures[failTimeout]++
+	case prefix == "ERROR" && failedLine.MatchString(msg):
+		d.failures[failedLine.FindStringSubmatch(msg)[1]]++
+	case prefix == "OUTAGE" && outageEndLine.MatchString(msg):
+		dur, err := time.ParseDuration(outageEndLine.FindStringSubmatch(msg)[1])
+		if err == nil {
+			d.outages++
+			d.downtime += dur
+			d.buckets[outageBucket(dur)]++
+		}
+	case prefix == "OUTAGE" && blipLine.MatchString(msg):
+		d.blips++
+	case prefix == "OUTAGE" && flakeyLine.MatchString(msg):
+		// Periods logged before tiers existed were all over 3 times the mean
+		tier := flakeyLine.FindStringSubmatch(msg)[1]
+		if len(tier) == 0 {
+			tier = "severe"
+		}
+		for i, lt := range latencyTiers {
+			if lt.name == tier {
+				d.tiers[i]++
+			}
+		}
+	}
+}
+
+// Write a CSV summary of every day of the supplied month ("2006-01") of the
+// supplied log file to w
+func monthlyReport(w io.Writer, path, month string, only []string) error {
+	start, err := time.ParseInLocation("2006-01", month, timeZone)
+	if err != nil {
+		return fmt.Errorf("month must look like 2006-01: %v", err)
+	}
+	return dailyReport(w, path, start, start.AddDate(0, 1, 0), only)
+}
+
+// Read the supplied log file and write a CSV summary of every day from start
+// up to end to w. If any targets are supplied, only their pings are
+// summarised
+func dailyReport(out io.Writer, path string, start, end time.Time, only []string) error {
+	days := make(map[string]*daySummary)
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if t.Before(start) || !t.Before(end) || !inTargets(name, only) {
+			return
+		}
+		key := t.Format("2006-01-02")
+		if days[key] == nil {
+			days[key] = newDaySummary()
+		}
+		days[key].add(prefix, msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(out)
+	header := []string{"date", "availability_pct", "outages", "blips", "downtime_min",
+		"mean_rtt_ms", "p95_rtt_ms", "max_loss_pct"}
+	for _, b := range outageBuckets {
+		header = append(header, b.name)
+	}
+	for _, lt := range latencyTiers {
+		header = append(header, "latency_"+lt.name)
+	}
+	for _, c := range failCategories {
+		header = append(header, "failed_"+c)
+	}
+	w.Write(header)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		d, ok := days[key]
+		if !ok || d.recv+d.lost == 0 {
+			w.Write(append([]string{key}, make([]string, len(header)-1)...))
+			continue
+		}
+		row := []string{
+			key,
+			fmt.Sprintf("%.3f", 100*float64(d.recv)/float64(d.recv+d.lost)),
+			strconv.Itoa(d.outages),
+			strconv.Itoa(d.blips),
+			fmt.Sprintf("%.1f", d.downtime.Minutes()),
+			msString(meanRtt(d.rtts)),
+			msString(percentileRtt(d.rtts, 95)),
+			fmt.Sprintf("%.0f", d.maxLoss),
+		}
+		for _, n := range append(d.buckets, d.tiers...) {
+			row = append(row, strconv.Itoa(n))
+		}
+		for _, c := range failCategories {
+			row = append(row, strconv.Itoa(d.failures[c]))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Read the supplied log file and write a few lines of text summing up each
+// target from start up to end to w. If any targets are supplied, only they
+// are summed up
+func digest(w io.Writer, path string, start, end time.Time, only []string) error {
+	var names []string
+	sums := make(map[string]*daySummary)
+	events := make(map[string][]incident) // Outages, blips and flakey latency periods by target
+	var jumps []string                    // Jumps of the clock, as they are written
+	certs := make(map[string]string)      // Last warning of a certificate expiring, by target
+	scores := make(map[string][]float64)  // MOS estimates of rtp:// targets, by target
+	err := scanLog(path, func(prefix, name string, t time.Time, msg string) {
+		if t.Before(start) || !t.Before(end) {
+			return
+		}
+		if m := clockLine.FindStringSubmatch(msg); prefix == "PING" && m != nil {
+			if d, err := time.ParseDuration(m[2]); err == nil {
+				jumps = append(jumps, fmt.Sprintf("    %s  Clock jumped %s %s", lc.timestamp(t), m[1], lc.duration(d)))
+			}
+			return
+		}
+		if !inTargets(name, only) {
+			return
+		}
+		if sums[name] == nil {
+			sums[name] = newDaySummary()
+			names = append(names, name)
+		}
+		sums[name].add(prefix, msg)
+		if m := certLine.FindStringSubmatch(msg); prefix == "ERROR" && m != nil {
+			certs[name] = fmt.Sprintf("%s expires on %s", m[1], m[3])
+		}
+		if m := mosLine.FindStringSubmatch(msg); prefix == "PING" && m != nil {
+			if mos, err := strconv.ParseFloat(m[1], 64); err == nil {
+				scores[name] = append(scores[name], mos)
+			}
+		}
+		if prefix != "OUTAGE" {
+			return
+		}
+		if inc, ok := parseIncident(msg, t); ok {
+			events[name] = append(events[name], inc)
+		} else if m := blipLine.FindStringSubmatch(msg); m != nil {
+			if d, err := time.ParseDuration(m[1]); err == nil {
+				events[name] = append(events[name], incident{kind: "Blip", start: t.Add(-d), end: t})
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "autoping digest from %s to %s\n", lc.timestamp(start), lc.timestamp(end))
+	sort.Strings(names)
+	shown := 0
+	for _, name := range names {
+		d, evs, cert, mos := sums[name], events[name], certs[name], scores[name]
+		if d.recv+d.lost == 0 {
+			continue
+		}
+		shown++
+		// Lines logged before autoping could ping several targets don't name one
+		if len(name) == 0 {
+			name = "Untagged lines"
+		} else if labels := currentSettings().labelString(name); len(labels) > 0 {
+			name += "  " + labels
+		}
+		fmt.Fprintf(w, "\n%s\n", name)
+		fmt.Fprintf(w, "  Availability    %s\n", lc.pct(100*float64(d.recv)/float64(d.recv+d.lost)))
+		fmt.Fprintf(w, "  Outages         %d, down for %s\n", d.outages, lc.duration(d.downtime))
+		fmt.Fprintf(w, "  Blips           %d\n", d.blips)
+		fmt.Fprintf(w, "  RTT             mean %s ms, p95 %s ms\n", msString(meanRtt(d.rtts)),
+			msString(percentileRtt(d.rtts, 95)))
+		var tiers, failures []string
+		for i, lt := range latencyTiers {
+			tiers = append(tiers, fmt.Sprintf("%s %d", lt.name, d.tiers[i]))
+		}
+		fmt.Fprintf(w, "  Flakey latency  %s\n", strings.Join(tiers, ", "))
+		for _, c := range failCategories {
+			if d.failures[c] > 0 {
+				failures = append(failures, fmt.Sprintf("%s %d", c, d.failures[c]))
+			}
+		}
+		if len(failures) > 0 {
+			fmt.Fprintf(w, "  Failed pings    %s\n", strings.Join(failures, ", "))
+		}
+		if len(cert) > 0 {
+			fmt.Fprintf(w, "  Certificate     %s\n", cert)
+		}
+		if len(mos) > 0 {
+			sum, lowest := 0.0, mos[0]
+			for _, m := range mos {
+				sum += m
+				lowest = math.Min(lowest, m)
+			}
+			fmt.Fprintf(w, "  Call quality    MOS mean %.2f, lowest %.2f\n", sum/float64(len(mos)), lowest)
+		}
+		if len(evs) > 0 {
+			fmt.Fprintln(w, "  Events")
+			writeEvents(w, evs)
+		}
+	}
+	if shown == 0 {
+		fmt.Fprintln(w, "\nNo pings were logged")
+	}
+
+	// Times in the log around a jump of the clock are off by it, but the
+	// durations aren't
+	if len(jumps) > 0 {
+		fmt.Fprintln(w, "\nClock changes, which aren't outages")
+		fmt.Fprintln(w, strings.Join(jumps, "\n"))
+	}
+	return nil
+}
+
+// Write the supplied events of a target to w in the order they started. On
+// bad days a target can have dozens of events of one kind, so more than
+// digestRepeats of a kind in one day are written as a single line with their
+// number, total duration and the longest of them
+func writeEvents(w io.Writer, events []incident) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].start.Before(events[j].start) })
+	type group struct {
+		day, kind string
+		events    []incident
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	for _, ev := range events {
+		day := ev.start.Format("2006-01-02")
+		g := byKey[day+ev.kind]
+		if g == nil {
+			g = &group{day: day, kind: ev.kind}
+			byKey[day+ev.kind] = g
+			groups = append(groups, g)
+		}
+		g.events = append(g.events, ev)
+	}
+
+	for _, g := range groups {
+		if len(g.events) <= digestRepeats {
+			for _, ev := range g.events {
+				fmt.Fprintf(w, "    %s  %s, %s\n", lc.timestamp(ev.start), ev.kind,
+					lc.duration(ev.end.Sub(ev.start)))
+			}
+			continue
+		}
+		var total time.Duration
+		worst := g.events[0]
+		for _, ev := range g.events {
+			total += ev.end.Sub(ev.start)
+			if ev.end.Sub(ev.start) > worst.end.Sub(worst.start) {
+				worst = ev
+			}
+		}
+		fmt.Fprintf(w, "    %s  %s x %d, %s in total, longest %s at %s\n", g.day, g.kind, len(g.events),
+			lc.duration(total), lc.duration(worst.end.Sub(worst.start)), lc.timestamp(worst.start))
+	}
+}
+
+// Returns the mean of the supplied RTTs, or 0 if there are none
+func meanRtt(rtts []time.Duration) time.Duration {
+	if len(rtts) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, r := range rtts {
+		total += r
+	}
+	return total / time.Duration(len(rtts))
+}
+
+// Returns the pth percentile of the supplied RTTs (nearest rank), or 0 if
+// there are none
+func percentileRtt(rtts []time.Duration, p float64) time.Duration {
+	if len(rtts) == 0 {
+		return 0
+	}
+	s := append([]time.Duration(nil), rtts...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	rank := int(math.Ceil(p/100*float64(len(s)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return s[rank]
+}
+
+// Format a duration as milliseconds with one decimal place
+func msString(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+}
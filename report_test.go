@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+// Every packet of a ping cycle counts towards the loss of the day, whether
+// some of the cycle came back or none of it did
+func TestCycleLoss(t *testing.T) {
+	d := newDaySummary()
+	for _, line := range [][2]string{
+		{"PING", "64 bytes from 192.0.2.1: icmp_seq=1 time=10ms"},
+		{"PING", "Cycle of 2/3 packets, 33% lost: min/avg/max/stddev = 10ms/10ms/10ms/0s"},
+		{"OUTAGE", "Timeout - Missed pong"},
+		{"PING", "Cycle of 0/3 packets, 100% lost"},
+		{"OUTAGE", "Timeout - Missed pong"}, // Logged before -count, with one packet a cycle
+	} {
+		d.add(line[0], line[1])
+	}
+	if d.lost != 5 || d.maxLoss != 100 || d.failures[failTimeout] != 2 {
+		t.Errorf("%d packets lost, worst cycle %.0f%% and %d timeouts, want 5, 100%% and 2", d.lost, d.maxLoss,
+			d.failures[failTimeout])
+	}
+}
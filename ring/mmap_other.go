@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package ring
+
+import (
+	"errors"
+	"os"
+)
+
+// Ring files need mmap, which this package only uses on Unix systems
+func mmap(f *os.File, size int, writable bool) ([]byte, error) {
+	return nil, errors.New("ring: ring files aren't supported on this system")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
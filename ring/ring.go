@@ -0,0 +1,273 @@
+// Package ring reads and writes the fixed-size sample files autoping keeps
+// with -ring. Each file holds the last 24 hours of ping results of one target
+// in a memory-mapped ring buffer, so dashboards and scripts can read recent
+// data instantly without going through the log file or the status page.
+//
+// A file starts with a 32 byte header: the magic "APRING01", the ping
+// interval in nanoseconds, the number of slots and the number of samples ever
+// written, all little-endian 64-bit integers. Then come the slots, 16 bytes
+// each: the time of the ping in Unix nanoseconds and its RTT in nanoseconds,
+// or -1 if the ping was missed. Sample n goes in slot n modulo the number of
+// slots.
+//
+// A file made for one interval is never resized in place. One for a new
+// interval is written beside it and renamed over it, so readers keep reading
+// the old one until they open the file again
+package ring
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	magic      = "APRING01"
+	headerSize = 32
+	slotSize   = 16
+	window     = 24 * time.Hour // Time covered by a ring
+)
+
+// Sample is the result of a single ping
+type Sample struct {
+	Time time.Time
+	RTT  time.Duration // 0 if the ping was missed
+	OK   bool          // False if the ping was missed
+}
+
+// Ring is an open ring file
+type Ring struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	data  []byte // Nil once the ring is closed
+	slots uint64
+}
+
+// Returns the number of slots needed for 24 hours of pings at the supplied
+// interval
+func slotsFor(interval time.Duration) uint64 {
+	if slots := uint64(window / interval); slots > 0 {
+		return slots
+	}
+	return 1
+}
+
+// Create opens the ring file at the supplied path for writing, creating it
+// with enough slots for 24 hours of pings at the supplied interval. An
+// existing file made for another interval is made again for this one, keeping
+// the samples it has room for
+func Create(path string, interval time.Duration) (*Ring, error) {
+	old, err := Open(path)
+	if err != nil {
+		return build(path, interval, nil)
+	}
+	samples := old.Samples(time.Time{})
+	same := old.interval() == interval && old.slots == slotsFor(interval)
+	old.Close()
+	if !same {
+		return build(path, interval, samples)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	size := headerSize + slotsFor(interval)*slotSize
+	data, err := mmap(f, int(size), true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Ring{path: path, f: f, data: data, slots: slotsFor(interval)}, nil
+}
+
+// Write a new ring file for the supplied interval holding the supplied
+// samples, or as many of the newest as it has room for, and put it in place
+// of the file at the supplied path. The file is written beside it and renamed
+// over it, so processes reading the old one carry on reading it rather than
+// a file changing size under them
+func build(path string, interval time.Duration, samples []Sample) (*Ring, error) {
+	slots := slotsFor(interval)
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	data, err := func() ([]byte, error) {
+		if err := f.Chmod(0644); err != nil {
+			return nil, err
+		}
+		if err := f.Truncate(int64(headerSize + slots*slotSize)); err != nil {
+			return nil, err
+		}
+		return mmap(f, int(headerSize+slots*slotSize), true)
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	r := &Ring{path: path, f: f, data: data, slots: slots}
+	copy(data, magic)
+	binary.LittleEndian.PutUint64(data[8:], uint64(interval))
+	binary.LittleEndian.PutUint64(data[16:], slots)
+	if uint64(len(samples)) > slots {
+		samples = samples[uint64(len(samples))-slots:]
+	}
+	for _, s := range samples {
+		r.add(s)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		r.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open opens the ring file at the supplied path for reading
+func Open(path string) (*Ring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() < headerSize {
+		f.Close()
+		return nil, errors.New("ring: " + path + " is too short to be a ring file")
+	}
+	data, err := mmap(f, int(fi.Size()), false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &Ring{path: path, f: f, data: data, slots: binary.LittleEndian.Uint64(data[16:])}
+	if string(data[:8]) != magic || r.slots == 0 || int64(headerSize+r.slots*slotSize) != fi.Size() {
+		r.Close()
+		return nil, errors.New("ring: " + path + " isn't a ring file")
+	}
+	return r, nil
+}
+
+// Resize makes the ring file again for the supplied interval, keeping the
+// samples it has room for, if it was made for another one
+func (r *Ring) Resize(interval time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil || r.interval() == interval {
+		return nil
+	}
+	nr, err := build(r.path, interval, r.samples(time.Time{}))
+	if err != nil {
+		return err
+	}
+	r.close()
+	r.f, r.data, r.slots = nr.f, nr.data, nr.slots
+	return nil
+}
+
+// Add writes a sample to the ring, overwriting the oldest one once the ring is
+// full. Samples added once the ring is closed are dropped
+func (r *Ring) Add(s Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data != nil {
+		r.add(s)
+	}
+}
+
+func (r *Ring) add(s Sample) {
+	n := atomic.LoadUint64(r.written())
+	slot := r.data[headerSize+(n%r.slots)*slotSize:]
+	rtt := int64(-1)
+	if s.OK {
+		rtt = int64(s.RTT)
+	}
+	binary.LittleEndian.PutUint64(slot, uint64(s.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(slot[8:], uint64(rtt))
+	atomic.StoreUint64(r.written(), n+1)
+}
+
+// Samples returns the samples in the ring taken at or after the supplied
+// time, oldest first. A closed ring has none
+func (r *Ring) Samples(since time.Time) []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil {
+		return nil
+	}
+	return r.samples(since)
+}
+
+func (r *Ring) samples(since time.Time) []Sample {
+	n := atomic.LoadUint64(r.written())
+	first := uint64(0)
+	if n > r.slots {
+		first = n - r.slots
+	}
+	var samples []Sample
+	for i := first; i < n; i++ {
+		slot := r.data[headerSize+(i%r.slots)*slotSize:]
+		t := time.Unix(0, int64(binary.LittleEndian.Uint64(slot)))
+		if t.Before(since) {
+			continue
+		}
+		rtt := int64(binary.LittleEndian.Uint64(slot[8:]))
+		s := Sample{Time: t, OK: rtt >= 0}
+		if s.OK {
+			s.RTT = time.Duration(rtt)
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// Interval returns the ping interval the ring was made for
+func (r *Ring) Interval() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil {
+		return 0
+	}
+	return r.interval()
+}
+
+// Close unmaps and closes the ring file. Closing it again does nothing
+func (r *Ring) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil {
+		return nil
+	}
+	return r.close()
+}
+
+func (r *Ring) close() error {
+	err := munmap(r.data)
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.data = nil
+	return err
+}
+
+// Returns the ping interval the ring was made for
+func (r *Ring) interval() time.Duration {
+	return time.Duration(binary.LittleEndian.Uint64(r.data[8:]))
+}
+
+// Returns the counter of samples written, which other processes may be
+// reading at the same time. The mapping is page aligned, so the counter is
+// aligned for atomic access
+func (r *Ring) written() *uint64 {
+	return (*uint64)(unsafe.Pointer(&r.data[24]))
+}
@@ -0,0 +1,134 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+// +build linux darwin freebsd openbsd netbsd dragonfly
+
+package ring
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns the supplied number of samples a minute apart from the supplied
+// time, every third one missed
+func minutes(start time.Time, n int) []Sample {
+	var samples []Sample
+	for i := 0; i < n; i++ {
+		s := Sample{Time: start.Add(time.Duration(i) * time.Minute)}
+		if i%3 != 0 {
+			s.RTT, s.OK = time.Duration(i+1)*time.Millisecond, true
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// Samples written come back as they were, to the process writing them and to
+// one reading the file, and survive the ring being created again
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.ring")
+	w, err := Create(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1780000000, 0)
+	want := minutes(start, 100)
+	for _, s := range want {
+		w.Add(s)
+	}
+	if got := w.Samples(time.Time{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("written ring has %v, want %v", got, want)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Samples(start.Add(90 * time.Minute)); !reflect.DeepEqual(got, want[90:]) {
+		t.Errorf("read the last 10 minutes as %v, want %v", got, want[90:])
+	}
+	if r.Interval() != time.Minute {
+		t.Errorf("interval %v, want 1m", r.Interval())
+	}
+	r.Close()
+	w.Close()
+
+	w, err = Create(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := w.Samples(time.Time{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ring created again has %d samples, want %d", len(got), len(want))
+	}
+}
+
+// Once the ring is full, the newest samples overwrite the oldest
+func TestWraparound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.ring")
+	w, err := Create(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	all := minutes(time.Unix(1780000000, 0), 60)
+	for _, s := range all {
+		w.Add(s)
+	}
+	if got := w.Samples(time.Time{}); !reflect.DeepEqual(got, all[36:]) {
+		t.Errorf("full ring has %d samples from %v, want the last 24", len(got), got[0].Time)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() != headerSize+24*slotSize {
+		t.Errorf("ring file of %v bytes, want room for 24 samples", fi.Size())
+	}
+}
+
+// Resizing the ring for a new interval keeps the newest samples it has room
+// for, while a reader of the old file carries on reading it
+func TestResize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.com.ring")
+	w, err := Create(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := minutes(time.Unix(1780000000, 0), 50)
+	for _, s := range all {
+		w.Add(s)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := w.Resize(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Samples(time.Time{}); !reflect.DeepEqual(got, all[26:]) {
+		t.Errorf("resized ring has %d samples, want the last 24", len(got))
+	}
+	if got := r.Samples(time.Time{}); !reflect.DeepEqual(got, all) {
+		t.Errorf("reader of the old file sees %d samples, want %d", len(got), len(all))
+	}
+	w.Close()
+	w.Add(all[0])
+	if w.Samples(time.Time{}) != nil || w.Close() != nil {
+		t.Error("closed ring still in use")
+	}
+
+	// A ring created for another interval than its file's keeps its samples
+	w, err = Create(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := w.Samples(time.Time{}); !reflect.DeepEqual(got, all[26:]) {
+		t.Errorf("ring created for a shorter interval has %d samples, want 24", len(got))
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) > 0 {
+		t.Errorf("files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kurankat/autoping-go/ring"
+)
+
+// "autoping status" says how each target has been doing lately from the ring
+// files kept with -ring, without reading the log or asking the status page.
+// The files are read while the autoping writing them carries on, so it
+// answers at once however large the log has grown, and even when the status
+// page isn't served
+
+// Columns of the status of a target
+var ringStatusHeader = []string{"target", "last_ping", "last_rtt", "answered_1h", "mean_rtt_1h", "answered_24h"}
+
+// Print the recent pings of the targets in the ring directory, or of those
+// given as arguments
+func statusCommand(args []string) error {
+	fs := subcommandFlags("status", "ring", "c", "locale", "timezone")
+	format := fs.String("format", "table", "table, or csv for a spreadsheet")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: autoping status -ring <dir> [flags] [target ...]")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "csv" {
+		return fmt.Errorf("unknown status format '%s'. Use table or csv", *format)
+	}
+	if len(*ringFlag) == 0 {
+		return usageError{errors.New("status reads the ring files of -ring, which isn't set")}
+	}
+	if err := configure(fs); err != nil {
+		return err
+	}
+	rows, err := ringStatus(*ringFlag, fs.Args(), time.Now())
+	if err != nil {
+		return err
+	}
+	if *format == "csv" {
+		return writeCSV(os.Stdout, ringStatusHeader, rows)
+	}
+	return writeTable(os.Stdout, ringStatusHeader, rows)
+}
+
+// Returns a row for each ring file in the supplied directory, or for those
+// of the supplied targets, sorted by target, as of the supplied time
+func ringStatus(dir string, only []string, now time.Time) ([][]string, error) {
+	var paths []string
+	if len(only) > 0 {
+		for _, addr := range only {
+			paths = append(paths, filepath.Join(dir, url.PathEscape(addr)+".ring"))
+		}
+	} else {
+		var err error
+		if paths, err = filepath.Glob(filepath.Join(dir, "*.ring")); err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("there are no ring files in %s", dir)
+		}
+	}
+	sort.Strings(paths)
+
+	var rows [][]string
+	for _, path := range paths {
+		r, err := ring.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		samples := r.Samples(now.Add(-24 * time.Hour))
+		r.Close()
+		addr, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".ring"))
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, ringStatusRow(addr, samples, now))
+	}
+	return rows, nil
+}
+
+// Returns the status of the target with the supplied address from its
+// samples of the last 24 hours
+func ringStatusRow(addr string, samples []ring.Sample, now time.Time) []string {
+	row := []string{addr, lc.timestamp(time.Time{}), "-", "-", "-", "-"}
+	if len(samples) == 0 {
+		return row
+	}
+	last := samples[len(samples)-1]
+	row[1] = lc.timestamp(last.Time)
+	if last.OK {
+		row[2] = last.RTT.Round(100 * time.Microsecond).String()
+	} else {
+		row[2] = "missed"
+	}
+
+	hour := now.Add(-time.Hour)
+	var sent, recv, sentHour, recvHour int
+	var rtts time.Duration
+	for _, s := range samples {
+		sent++
+		inHour := !s.Time.Before(hour)
+		if inHour {
+			sentHour++
+		}
+		if !s.OK {
+			continue
+		}
+		recv++
+		if inHour {
+			recvHour++
+			rtts += s.RTT
+		}
+	}
+	if sentHour > 0 {
+		row[3] = lc.pct(100 * float64(recvHour) / float64(sentHour))
+	}
+	if recvHour > 0 {
+		row[4] = (rtts / time.Duration(recvHour)).Round(100 * time.Microsecond).String()
+	}
+	row[5] = lc.pct(100 * float64(recv) / float64(sent))
+	return row
+}
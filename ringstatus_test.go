@@ -0,0 +1,78 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+// +build linux darwin freebsd openbsd netbsd dragonfly
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kurankat/autoping-go/client"
+)
+
+// The ring files of targets are opened as they are added, made again when
+// their interval changes and closed when they are removed, and the status
+// command and API read recent pings back from them
+func TestRingFiles(t *testing.T) {
+	*ringFlag = t.TempDir()
+	old := currentSettings()
+	t.Cleanup(func() {
+		setTargets(nil)
+		useSettings(old)
+		*ringFlag = ""
+		ringsOpened = false
+	})
+	s := *old
+	s.interval, s.timeout, s.overrides = time.Minute, 10*time.Second, nil
+	s.targets, s.fileTargets, s.tenants = []string{"192.0.2.1", "https://example.com/health"}, nil, nil
+	useSettings(&s)
+	setTargets(s.pingAddrs())
+	openRings()
+
+	now := time.Now().Truncate(time.Second)
+	tg := findTarget("https://example.com/health")
+	for i := 0; i < 120; i++ {
+		tg.record(now.Add(time.Duration(i-119)*time.Minute), i%4 != 0, 20*time.Millisecond)
+	}
+
+	rows, err := ringStatus(*ringFlag, nil, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://example.com/health", lc.timestamp(now), "20ms", lc.pct(75), "20ms", lc.pct(75)}
+	if len(rows) != 2 || !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("status rows %v, want the second to be %v", rows, want)
+	}
+	if rows[0][0] != "192.0.2.1" || rows[0][2] != "-" {
+		t.Errorf("status of a target not pinged yet is %v", rows[0])
+	}
+
+	rec := httptest.NewRecorder()
+	apiSamplesHandler(rec, httptest.NewRequest("GET", "/api/samples?target=https://example.com/health&since="+
+		now.Add(-9*time.Minute).Format(time.RFC3339), nil))
+	var samples []client.Sample
+	if err := json.Unmarshal(rec.Body.Bytes(), &samples); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 10 || samples[9].RTT != 20 || samples[9].Lost || !samples[9].Time.Equal(now) {
+		t.Errorf("API served %v, want the last 10 pings", samples)
+	}
+
+	// A longer interval keeps the ring's newest pings, and a removed target's
+	// ring is closed
+	s.interval = time.Hour
+	s.timeout = 30 * time.Second
+	s.targets = []string{"https://example.com/health"}
+	useSettings(&s)
+	setTargets(s.pingAddrs())
+	if tg.ring.Interval() != time.Hour || len(tg.ring.Samples(time.Time{})) != 24 {
+		t.Errorf("ring for an interval of %v has %d pings, want an hour and 24", tg.ring.Interval(),
+			len(tg.ring.Samples(time.Time{})))
+	}
+	if rows, err := ringStatus(*ringFlag, []string{"https://example.com/health"}, now); err != nil || len(rows) != 1 {
+		t.Errorf("status of one target %v, %v", rows, err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	ping "github.com/go-ping/ping"
+)
+
+// Pings say whether a link is up, but not how a call over it would sound. A
+// target given as rtp:// and the address of another autoping started with
+// -rtp-reflect, e.g. rtp://office.example.com:5004, is probed with a second
+// of synthetic voice: 50 RTP packets the size of G.711 ones, 20ms apart,
+// which the other autoping sends straight back. The packets lost, the jitter
+// of their round trips and the latency give an estimate of the MOS a call
+// would get, by the simplified E-model, logged with the pong. Packets that
+// take more than a second to come back are too late for a call, and count as
+// lost like those that never do
+
+const (
+	rtpPackets     = 50                    // Packets in a stream
+	rtpSpacing     = 20 * time.Millisecond // Time between packets
+	rtpPayloadSize = 160                   // Bytes of audio in a packet, as G.711 sends every 20ms
+	rtpHeaderSize  = 12
+	rtpLate        = time.Second // RTT past which a packet is too late for a call
+)
+
+var rtpReflectFlag = flag.String("rtp-reflect", "", "UDP address to send back the synthetic voice streams of rtp:// targets of other autopings from, e.g. :5004")
+
+// Mean opinion score in a pong line
+var mosLine = regexp.MustCompile(`mos=(\S+) time=`)
+
+type rtpProber struct{ noOptions } // Probes rtp:// targets with a synthetic voice stream
+
+// Method to check that the supplied RTP target has a host and port, and a
+// timeout longer than its stream takes to send
+func (rtpProber) check(s *settings, addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil || len(u.Hostname()) == 0 || len(u.Port()) == 0 {
+		return fmt.Errorf("target %s must be rtp:// and a host and port", addr)
+	}
+	if stream, timeout := rtpPackets*rtpSpacing, s.targetTimeout(addr); timeout <= stream {
+		return fmt.Errorf("timeout %v of %s must be longer than the %v its stream takes", timeout, addr, stream)
+	}
+	return nil
+}
+
+// Method to return the host of the supplied RTP target
+func (rtpProber) host(s *settings, addr string) (string, error) {
+	return urlHost(addr)
+}
+
+// Method to probe the supplied target with a synthetic voice stream, in place
+// of a ping
+func (rtpProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the stream was started
+	u, err := url.Parse(tg.addr)
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+	var ssrc [4]byte
+	if _, err := rand.Read(ssrc[:]); err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+
+	tg.logf(tLog, "Sending %d RTP packets to %s", rtpPackets, u.Host)
+	conn, err := net.DialTimeout("udp", u.Host, tg.timeout())
+	if err != nil {
+		tg.probeFailed(t, failUDP, err)
+		return
+	}
+	defer conn.Close()
+
+	// Answers are read while the stream is still going out
+	var sent [rtpPackets]time.Time
+	answers := make(chan rtpAnswer, rtpPackets+1)
+	go readRTPAnswers(conn, ssrc, answers)
+	packet := make([]byte, rtpHeaderSize+rtpPayloadSize)
+	for i := range packet[rtpHeaderSize:] {
+		packet[rtpHeaderSize+i] = 0xff // Silence in G.711 µ-law
+	}
+	for seq := range sent {
+		rtpHeader(packet, ssrc, seq)
+		sent[seq] = time.Now()
+		if _, err := conn.Write(packet); err != nil {
+			tg.probeFailed(t, failUDP, err)
+			return
+		}
+		time.Sleep(rtpSpacing - time.Since(sent[seq]))
+	}
+	late := time.Now().Add(rtpLate)
+	if deadline := t.Add(tg.timeout()); deadline.Before(late) {
+		late = deadline
+	}
+	conn.SetReadDeadline(late)
+
+	var rtts []time.Duration // In the order the answers came back
+	var readErr error
+	for a := range answers {
+		if a.err != nil {
+			readErr = a.err
+			break
+		}
+		if rtt := a.at.Sub(sent[a.seq]); rtt <= rtpLate {
+			rtts = append(rtts, rtt)
+		}
+	}
+	if len(rtts) == 0 {
+		// A port nothing listens on is refused, once the ICMP error comes back
+		tg.probeFailed(t, failUDP, readErr)
+		return
+	}
+
+	// The stream counts as one answered ping, with the packets lost going into
+	// the MOS, so a line that loses some is still up
+	s := rtpStatistics(rtts)
+	jitter := rtpJitter(rtts)
+	mos := estimateMOS(s.AvgRtt/2, jitter, s.PacketLoss)
+	if tg.samplePong(s.AvgRtt) {
+		tg.logf(pLog, "%d bytes from %s: lost=%.0f%% jitter=%v mos=%.2f time=%v", len(packet), conn.RemoteAddr(),
+			s.PacketLoss, jitter, mos, s.AvgRtt)
+	}
+	s.Addr, s.PacketsSent, s.PacketsRecv = tg.addr, 1, 1
+	tg.cycleFinished(t, s)
+}
+
+// Answer to a packet of a stream
+type rtpAnswer struct {
+	seq int
+	at  time.Time // Time the answer came back
+	err error     // Why no more answers can be read
+}
+
+// Read the answers to the stream with the supplied SSRC from the supplied
+// connection and send them to the supplied channel, passing over duplicates,
+// until reading fails. The channel is closed once reading fails
+func readRTPAnswers(conn net.Conn, ssrc [4]byte, answers chan<- rtpAnswer) {
+	defer close(answers)
+	var seen [rtpPackets]bool
+	buf := make([]byte, udpAnswerLimit)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			answers <- rtpAnswer{err: err}
+			return
+		}
+		at := time.Now()
+		if n < rtpHeaderSize || buf[0]>>6 != 2 || !bytes.Equal(buf[8:12], ssrc[:]) {
+			continue
+		}
+		seq := int(binary.BigEndian.Uint16(buf[2:4]))
+		if seq >= rtpPackets || seen[seq] {
+			continue
+		}
+		seen[seq] = true
+		answers <- rtpAnswer{seq: seq, at: at}
+	}
+}
+
+// Write the RTP header of the packet with the supplied sequence number of
+// the stream with the supplied SSRC to the start of the supplied packet
+func rtpHeader(packet []byte, ssrc [4]byte, seq int) {
+	packet[0] = 0x80 // Version 2
+	packet[1] = 0    // G.711 µ-law
+	binary.BigEndian.PutUint16(packet[2:], uint16(seq))
+	binary.BigEndian.PutUint32(packet[4:], uint32(seq*rtpPayloadSize))
+	copy(packet[8:12], ssrc[:])
+}
+
+// Returns the statistics of a stream with the supplied RTTs of the packets
+// that came back, as those of a cycle of pings
+func rtpStatistics(rtts []time.Duration) *ping.Statistics {
+	s := &ping.Statistics{PacketsSent: rtpPackets, PacketsRecv: len(rtts), Rtts: rtts,
+		PacketLoss: 100 * float64(rtpPackets-len(rtts)) / rtpPackets, MinRtt: rtts[0], MaxRtt: rtts[0]}
+	var sum, sumSq float64
+	for _, rtt := range rtts {
+		if rtt < s.MinRtt {
+			s.MinRtt = rtt
+		}
+		if rtt > s.MaxRtt {
+			s.MaxRtt = rtt
+		}
+		sum += float64(rtt)
+		sumSq += float64(rtt) * float64(rtt)
+	}
+	mean := sum / float64(len(rtts))
+	s.AvgRtt = time.Duration(mean)
+	s.StdDevRtt = time.Duration(math.Sqrt(math.Max(sumSq/float64(len(rtts))-mean*mean, 0)))
+	return s
+}
+
+// Returns the jitter of a stream with the supplied RTTs, in the order the
+// packets came back, as RTP measures it: a running mean of the differences
+// between the transit times of packets in a row. The RTT stands in for the
+// transit time, as it is measured on one clock
+func rtpJitter(rtts []time.Duration) time.Duration {
+	var j float64
+	for i := 1; i < len(rtts); i++ {
+		d := math.Abs(float64(rtts[i] - rtts[i-1]))
+		j += (d - j) / 16
+	}
+	return time.Duration(j).Round(time.Microsecond)
+}
+
+// Returns the MOS a call would get with the supplied one-way latency, jitter
+// and percentage of packets lost, by the simplified E-model: an R factor of
+// 93.2 less what latency, made worse by jitter, and loss take off it, turned
+// into a score from 1 to 4.5
+func estimateMOS(latency, jitter time.Duration, loss float64) float64 {
+	effective := float64(latency+2*jitter)/float64(time.Millisecond) + 10
+	r := 93.2 - effective/40
+	if effective >= 160 {
+		r = 93.2 - (effective-120)/10
+	}
+	r -= 2.5 * loss
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + 7e-6*r*(r-60)*(100-r)
+}
+
+// Send the RTP packets that come in on the supplied address straight back,
+// for the rtp:// targets of other autopings, until the supplied context is
+// done. Only packets that look like RTP are sent back, and no bigger than they
+// came, so the reflector can't be used to make floods bigger. Errors are
+// logged, but don't stop the pings
+func reflectRTP(ctx context.Context, addr string) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		eLog.Printf("RTP reflector stopped: %v", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	go func() {
+		defer crashed("rtp reflector")
+		buf := make([]byte, udpAnswerLimit)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					eLog.Printf("RTP reflector stopped: %v", err)
+				}
+				return
+			}
+			if n >= rtpHeaderSize && buf[0]>>6 == 2 {
+				pc.WriteTo(buf[:n], from)
+			}
+		}
+	}()
+}
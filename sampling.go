@@ -0,0 +1,55 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// At short intervals or with many targets, most of the log is pong lines
+// saying nothing new. With sampling, only 1 in every so many pongs of a target
+// is logged, set with -sample or under sampling in the config file, where
+// targets can have rates of their own. Failures are always logged, and so are
+// pongs in a latency tier and the first pong after missed pings. The pongs
+// that aren't logged are counted in a line logged before the next one that is,
+// so reports and the history read back on startup still add up
+
+var sampledLine = regexp.MustCompile(`^Sampled out (\d+) pongs, mean RTT (\S+)$`)
+
+type sampler struct {
+	skipped int           // Pongs not logged since the last one that was
+	rttSum  time.Duration // Total RTT of those pongs
+}
+
+// Method to return how many pongs of the supplied target make one logged
+func (s *settings) sampleEvery(addr string) int {
+	if n, ok := s.sampled[addr]; ok {
+		return n
+	}
+	return s.sample
+}
+
+// Method to decide whether a pong with the supplied RTT is logged. Returns
+// false if it is sampled out
+func (tg *target) samplePong(rtt time.Duration) bool {
+	every := currentSettings().sampleEvery(tg.addr)
+	mean := time.Duration(tg.latSlice.mean())
+	notable := tg.connInfo.missed > 0 || len(tg.latSlice) > 0 && tg.latencyTier(rtt, mean) >= 0
+	if every > 1 && !notable && tg.sample.skipped+1 < every {
+		tg.sample.skipped++
+		tg.sample.rttSum += rtt
+		return false
+	}
+	tg.flushSampled()
+	return true
+}
+
+// Method to log how many pongs have been sampled out since the last one that
+// was logged, if any
+func (tg *target) flushSampled() {
+	if tg.sample.skipped == 0 {
+		return
+	}
+	tg.logf(pLog, "Sampled out %d pongs, mean RTT %v", tg.sample.skipped,
+		tg.sample.rttSum/time.Duration(tg.sample.skipped))
+	tg.sample = sampler{}
+}
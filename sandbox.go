@@ -0,0 +1,7 @@
+package main
+
+// autoping usually runs as root for its raw sockets, so once it has set up
+// its log, read back its history and started the status page, it gives up
+// what it no longer needs: pledge and unveil on OpenBSD, and a seccomp filter
+// on Linux that blocks running programs, loading kernel modules, mounting,
+// tracing and the like. Pass -sandbox=false to turn this off when debugging
@@ -0,0 +1,80 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package main
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seccompSetModeFilter   = 1          // SECCOMP_SET_MODE_FILTER
+	seccompFilterFlagTsync = 1          // Apply the filter to every thread
+	seccompRetAllow        = 0x7fff0000 // SECCOMP_RET_ALLOW
+	seccompRetErrno        = 0x00050000 // SECCOMP_RET_ERRNO
+	seccompRetKill         = 0x80000000 // SECCOMP_RET_KILL_PROCESS
+	x32SyscallBit          = 0x40000000 // __X32_SYSCALL_BIT, set in the numbers of x32 system calls
+
+	bpfLoad   = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJumpEq = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJumpGe = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfReturn = 0x06 // BPF_RET | BPF_K
+)
+
+// System calls autoping never needs once it is running. They fail with EPERM
+var deniedSyscalls = []uint32{
+	unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD, unix.SYS_REBOOT,
+	unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY, unix.SYS_KEYCTL,
+}
+
+// Install a seccomp filter on every thread that makes the denied system calls
+// fail, and kills the process if a system call comes from another
+// architecture's calling convention. x32 system calls come in as x86-64 ones
+// with x32SyscallBit set, so execve and the rest would get past the
+// comparisons under their x32 numbers. Go never makes them, so they kill the
+// process too
+func sandbox() error {
+	arch := uint32(unix.AUDIT_ARCH_X86_64)
+	if runtime.GOARCH == "arm64" {
+		arch = unix.AUDIT_ARCH_AARCH64
+	}
+
+	// seccomp_data holds the system call number at offset 0 and the
+	// architecture at offset 4
+	filter := []unix.SockFilter{
+		{Code: bpfLoad, K: 4},
+		{Code: bpfJumpEq, Jt: 1, K: arch},
+		{Code: bpfReturn, K: seccompRetKill},
+		{Code: bpfLoad, K: 0},
+		{Code: bpfJumpGe, Jf: 1, K: x32SyscallBit},
+		{Code: bpfReturn, K: seccompRetKill},
+	}
+	for i, nr := range deniedSyscalls {
+		// Jump to the EPERM return after the last comparison
+		filter = append(filter, unix.SockFilter{Code: bpfJumpEq,
+			Jt: uint8(len(deniedSyscalls) - i), K: nr})
+	}
+	filter = append(filter,
+		unix.SockFilter{Code: bpfReturn, K: seccompRetAllow},
+		unix.SockFilter{Code: bpfReturn, K: seccompRetErrno | uint32(unix.EPERM)})
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync,
+		uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
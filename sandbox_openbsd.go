@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Limit the files autoping can see to its log, config, pid and Google service
+// account key files, the directories of the files of each class of log lines,
+// of the targets file and of crash reports, the resolver's configuration and
+// the CA certificates the mail server is checked against, and its system calls
+// to those needed to ping, resolve names, serve the status page and send
+// reports
+func sandbox() error {
+	unveils := map[string]string{
+		"/etc/resolv.conf": "r",
+		"/etc/hosts":       "r",
+		"/etc/ssl":         "r",
+	}
+	if !logToStream(*logFlag) {
+		unveils[*logFlag] = "rwc"
+	}
+	for _, l := range logRoutes {
+		unveils[filepath.Dir(l.File)] = "rwc" // Rotated files are renamed
+	}
+	if len(eventsFile.File) > 0 {
+		unveils[filepath.Dir(eventsFile.File)] = "rwc" // Rotated like the class files
+	}
+	if len(*ringFlag) > 0 {
+		unveils[*ringFlag] = "rwc" // Targets added on SIGHUP get ring files
+	}
+	if len(*configFlag) > 0 {
+		unveils[*configFlag] = "r" // Reloaded on SIGHUP
+	}
+	if len(*targetsFileFlag) > 0 {
+		unveils[filepath.Dir(*targetsFileFlag)] = "r" // Watched for the file being replaced
+	}
+	if len(*pidFileFlag) > 0 {
+		unveils[*pidFileFlag] = "c" // Removed on shutdown
+	}
+	crashMu.Lock()
+	crashDir := crashCfg.Dir
+	crashMu.Unlock()
+	if len(crashDir) > 0 {
+		// Made now, as unveil needs the directories above it to exist
+		if err := os.MkdirAll(crashDir, 0700); err != nil {
+			return err
+		}
+		unveils[crashDir] = "rwc"
+	}
+	sheetsMu.Lock()
+	if len(sheetsCfg.SpreadsheetID) > 0 {
+		unveils[sheetsCfg.Credentials] = "r" // Read before the first rows are added
+	}
+	sheetsMu.Unlock()
+	for path, perms := range unveils {
+		if err := unix.Unveil(path, perms); err != nil {
+			return err
+		}
+	}
+	if err := unix.UnveilBlock(); err != nil {
+		return err
+	}
+	return unix.PledgePromises("stdio rpath wpath cpath inet dns")
+}
@@ -0,0 +1,10 @@
+//go:build !openbsd && !(linux && (amd64 || arm64))
+// +build !openbsd
+// +build !linux !amd64,!arm64
+
+package main
+
+// There is no sandbox on other systems
+func sandbox() error {
+	return nil
+}
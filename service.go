@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// "autoping service install [flags]" sets autoping up to start at boot with
+// the supplied flags, and "autoping service uninstall" undoes it. macOS uses launchd, and FreeBSD (including pfSense and OPNsense) and OpenBSD use
+// rc.d scripts. On Linux, use the example systemd unit in autoping.service
+
+const serviceLabel = "com.github.kurankat.autoping" // launchd label of the service
+
+// Run the service subcommand with the supplied arguments
+func serviceCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: autoping service install [flags] | uninstall")
+	}
+	switch args[0] {
+	case "install":
+		// Check the flags before they go into the service definition
+		if err := parseFlags(flag.CommandLine, args[1:]); err != nil {
+			return err
+		}
+		if len(importFlag) == 0 && len(*configFlag) == 0 {
+			return errors.New("give the targets to ping with -i or a config file with -c")
+		}
+		return installService(args[1:])
+	case "uninstall":
+		return uninstallService()
+	}
+	return errors.New("unknown service command " + args[0] + ". Use install or uninstall")
+}
+
+// Returns the path of this binary followed by the supplied flags, each quoted
+// for the shell
+func serviceCommandLine(args []string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, err
+	}
+	quoted := []string{shellQuote(exe)}
+	for _, a := range args {
+		quoted = append(quoted, shellQuote(a))
+	}
+	return quoted, nil
+}
+
+// Quote the supplied string for the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Run the supplied service management command
+func runService(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const launchdPlist = "/Library/LaunchDaemons/" + serviceLabel + ".plist"
+
+// Write a launchd plist that runs this binary with the supplied flags at boot
+// and restarts it if it dies, and load it
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	var progArgs strings.Builder
+	for _, a := range append([]string{exe}, args...) {
+		progArgs.WriteString("\n\t\t<string>")
+		xml.EscapeText(&progArgs, []byte(a))
+		progArgs.WriteString("</string>")
+	}
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>%s
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+</dict>
+</plist>
+`, serviceLabel, progArgs.String())
+	if err := ioutil.WriteFile(launchdPlist, []byte(plist), 0644); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", launchdPlist)
+	return runService("launchctl", "bootstrap", "system", launchdPlist)
+}
+
+// Unload the service and remove its plist
+func uninstallService() error {
+	if err := runService("launchctl", "bootout", "system/"+serviceLabel); err != nil {
+		fmt.Println("Unloading service:", err)
+	}
+	return os.Remove(launchdPlist)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const rcScript = "/usr/local/etc/rc.d/autoping"
+
+// Write an rc.d script that runs this binary with the supplied flags under
+// daemon(8), which restarts it if it dies, then enable and start it. This
+// also covers pfSense and OPNsense
+func installService(args []string) error {
+	command, err := serviceCommandLine(args)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+
+# PROVIDE: autoping
+# REQUIRE: NETWORKING
+# KEYWORD: shutdown
+
+. /etc/rc.subr
+
+name="autoping"
+rcvar="autoping_enable"
+pidfile="/var/run/${name}.pid"
+command="/usr/sbin/daemon"
+command_args="-r -P ${pidfile} %s"
+
+load_rc_config $name
+: ${autoping_enable:="NO"}
+
+run_rc_command "$1"
+`, strings.Replace(strings.Join(command, " "), `"`, `\"`, -1))
+	if err := ioutil.WriteFile(rcScript, []byte(script), 0755); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", rcScript)
+	if err := runService("sysrc", "autoping_enable=YES"); err != nil {
+		return err
+	}
+	return runService("service", "autoping", "start")
+}
+
+// Stop and disable the service and remove its rc.d script
+func uninstallService() error {
+	if err := runService("service", "autoping", "stop"); err != nil {
+		fmt.Println("Stopping service:", err)
+	}
+	if err := runService("sysrc", "-x", "autoping_enable"); err != nil {
+		fmt.Println("Disabling service:", err)
+	}
+	return os.Remove(rcScript)
+}
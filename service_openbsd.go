@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const rcScript = "/etc/rc.d/autoping"
+
+// Write an rc.d script for this binary, then enable it with the supplied
+// flags and start it
+func installService(args []string) error {
+	command, err := serviceCommandLine(args)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(`#!/bin/ksh
+
+daemon=%s
+
+. /etc/rc.d/rc.subr
+
+rc_bg=YES
+rc_reload=NO
+
+rc_cmd $1
+`, command[0])
+	if err := ioutil.WriteFile(rcScript, []byte(script), 0555); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", rcScript)
+	if err := runService("rcctl", "enable", "autoping"); err != nil {
+		return err
+	}
+	if err := runService("rcctl", "set", "autoping", "flags", strings.Join(command[1:], " ")); err != nil {
+		return err
+	}
+	return runService("rcctl", "start", "autoping")
+}
+
+// Stop and disable the service and remove its rc.d script
+func uninstallService() error {
+	if err := runService("rcctl", "stop", "autoping"); err != nil {
+		fmt.Println("Stopping service:", err)
+	}
+	if err := runService("rcctl", "disable", "autoping"); err != nil {
+		fmt.Println("Disabling service:", err)
+	}
+	return os.Remove(rcScript)
+}
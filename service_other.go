@@ -0,0 +1,19 @@
+//go:build !darwin && !freebsd && !openbsd
+// +build !darwin,!freebsd,!openbsd
+
+package main
+
+import (
+	"errors"
+)
+
+var errNoService = errors.New("autoping service is only available on macOS, FreeBSD and OpenBSD. On Linux, " +
+	"copy autoping.service to /etc/systemd/system and enable it")
+
+func installService(args []string) error {
+	return errNoService
+}
+
+func uninstallService() error {
+	return errNoService
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// SIGHUP and changes to the targets file reload settings that pings, the
+// loggers and the HTTP handlers are reading at that very moment. Rather than
+// changing them one by one, a reload works all of them out into a new settings
+// value, checks it and swaps it in whole, and a settings value is never
+// changed once it is in use. Readers take the settings in use once and read
+// everything from them, so they never see half of one reload and half of
+// another. Only the main loop swaps them, so reloads don't race each other
+
+type settings struct {
+	targets       []string                     // Targets given with -i or in the config file
+	interval      time.Duration                // Time between pings
+	timeout       time.Duration                // Time to wait for a pong
+	sample        int                          // Log 1 in this many pongs
+	minOutage     time.Duration                // Time without a pong before an outage is declared
+	outageAfter   int                          // Missed pings in a row before an outage is declared, if more than 0
+	thresholds    [3]float64                   // Threshold of each latency tier
+	labels        map[string]map[string]string // Labels of targets, by address
+	maintenance   []config.Maintenance         // Maintenance windows of the targets
+	mailboxes     map[string]config.Mailbox    // Mailboxes of mail:// targets, by address
+	tenants       []tenant                     // Tenants by name
+	operatorToken string                       // Token that sees every tenant
+
+	// Settings of targets, which the targets file's win over the config file's
+	configSample    map[string]int                   // Sampling rates of targets in the config file, by address
+	configOverrides map[string]config.Override       // Overrides of targets in the config file, by address
+	fileTargets     []string                         // Targets listed in the targets file
+	fileSettings    map[string]config.TargetSettings // Settings of targets in the targets file, by address
+	sampled         map[string]int                   // Sampling rates of targets with their own, by address
+	overrides       map[string]config.Override       // Settings of targets that differ, by address
+}
+
+var liveSettings atomic.Pointer[settings] // Settings in use
+
+// Until the config is applied, the settings are the defaults of the flags
+func init() {
+	liveSettings.Store(&settings{interval: *intervalFlag, timeout: autoTimeout(*intervalFlag), sample: *sampleFlag,
+		minOutage: *minOutageFlag, outageAfter: *outageAfterFlag,
+		thresholds: [3]float64{config.DefaultLatency.Mild, config.DefaultLatency.Severe, config.DefaultLatency.Extreme}})
+}
+
+// Returns the settings in use. They must not be changed
+func currentSettings() *settings {
+	return liveSettings.Load()
+}
+
+// Start using the supplied settings
+func useSettings(s *settings) {
+	liveSettings.Store(s)
+}
+
+// Method to return a copy of the settings with the targets and settings of
+// the supplied targets file, or none if it is nil
+func (s *settings) withTargetsFile(ts *config.Targets) *settings {
+	next := *s
+	next.fileTargets, next.fileSettings = nil, nil
+	if ts != nil {
+		next.fileTargets, next.fileSettings = ts.Addrs, ts.Settings
+	}
+	next.mergeTargetSettings()
+	return &next
+}
+
+// Method to work out the settings of targets from those of the config file and
+// the targets file. A setting given on a target's line wins over the config
+// file's
+func (s *settings) mergeTargetSettings() {
+	s.sampled = make(map[string]int, len(s.configSample)+len(s.fileSettings))
+	for addr, n := range s.configSample {
+		s.sampled[addr] = n
+	}
+	for addr, ts := range s.fileSettings {
+		if ts.Sample > 0 {
+			s.sampled[addr] = ts.Sample
+		}
+	}
+
+	s.overrides = make(map[string]config.Override, len(s.configOverrides)+len(s.fileSettings))
+	for addr, o := range s.configOverrides {
+		s.overrides[addr] = o
+	}
+	for addr, ts := range s.fileSettings {
+		if !ts.Override.IsZero() {
+			s.overrides[addr] = s.overrides[addr].Merge(ts.Override)
+		}
+	}
+}
+
+// Method to return the addresses to ping: those given with -i or in the config
+// file, followed by those in the targets file and those of every tenant
+func (s *settings) pingAddrs() []string {
+	addrs := append(append([]string(nil), s.targets...), s.fileTargets...)
+	for _, t := range s.tenants {
+		addrs = append(addrs, t.targets...)
+	}
+	return addrs
+}
+
+// Returns the addresses to ping with the settings in use
+func pingAddrs() []string {
+	return currentSettings().pingAddrs()
+}
+
+// Method to check that the interval and timeout work together, so pings to
+// the same target don't overlap, and that every target can be pinged as it is
+// set up to be
+func (s *settings) check() error {
+	if s.interval < time.Second {
+		return fmt.Errorf("interval %v is too short. Use 1s or more", s.interval)
+	}
+	if *countFlag < 1 {
+		return fmt.Errorf("count %d must be 1 or more", *countFlag)
+	}
+	if *certDaysFlag < 0 {
+		return fmt.Errorf("cert-days %d must be 0 or more", *certDaysFlag)
+	}
+	if s.sample < 1 {
+		return fmt.Errorf("sample %d must be 1 or more", s.sample)
+	}
+	if s.timeout <= 0 {
+		return fmt.Errorf("timeout %v must be more than 0", s.timeout)
+	} else if s.timeout >= s.interval {
+		return fmt.Errorf("timeout %v must be shorter than the interval %v", s.timeout, s.interval)
+	}
+	if err := checkProbes(s); err != nil {
+		return err
+	}
+	return checkOverrides(s)
+}
+
+// Method to check the settings as check does, and that there are targets to
+// ping, before they replace those in use
+func (s *settings) checkReload() error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	if len(s.pingAddrs()) == 0 {
+		return errors.New("there are no targets to ping")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Reload two configs over and over while pings and requests read the
+// settings, which the race detector checks, and check that what is read
+// always comes from one of them, never half of each
+func TestReloadWhileReading(t *testing.T) {
+	dir := t.TempDir()
+	configs := []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")}
+	for i, text := range []string{
+		"targets: [192.0.2.1]\ninterval: 10s\ntimeout: 2s\nlabels:\n  192.0.2.1: {site: a}\n",
+		"targets: [192.0.2.1]\ninterval: 20s\ntimeout: 4s\nlabels:\n  192.0.2.1: {site: b}\n",
+	} {
+		if err := ioutil.WriteFile(configs[i], []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loggers := []*log.Logger{pLog, eLog}
+	before := currentSettings()
+	t.Cleanup(func() {
+		pLog, eLog = loggers[0], loggers[1]
+		useSettings(before)
+		setTargets(nil)
+	})
+	pLog = newLogger(ioutil.Discard, "PING - ")
+	eLog = newLogger(ioutil.Discard, "ERROR - ")
+	if err := reloadConfig(configs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tg := &target{addr: "192.0.2.1"}
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s := currentSettings()
+				site := s.labels["192.0.2.1"]["site"]
+				if s.timeout*5 != s.interval || site == "a" && s.interval != 10*time.Second ||
+					site == "b" && s.interval != 20*time.Second {
+					t.Errorf("interval %v, timeout %v and site %s aren't of one config", s.interval, s.timeout, site)
+					return
+				}
+				tg.interval()
+				tg.timeout()
+				tg.tag()
+				tg.outageRule()
+				inMaintenance(tg.addr, time.Now())
+				currentTenants()
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if err := reloadConfig(configs[i%2]); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// A reload with settings that don't work together changes nothing
+func TestReloadRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.yaml")
+	if err := ioutil.WriteFile(path, []byte("targets: [192.0.2.1]\ninterval: 10s\ntimeout: 20s\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before := currentSettings()
+	if err := reloadConfig(path); err == nil {
+		t.Error("a timeout longer than the interval was taken")
+	}
+	if currentSettings() != before {
+		t.Error("the settings changed after a rejected reload")
+	}
+}
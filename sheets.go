@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Small offices that keep track of their ISP's failures in a spreadsheet can
+// have a row added to a Google Sheet for every outage, blip and period of
+// flakey latency as it finishes, with when it started, how long it lasted,
+// its kind and the target. autoping adds them as a service account, whose
+// JSON key is set under google_sheets in the config file and which the
+// spreadsheet has to be shared with as an editor. Rows that can't be added
+// are kept and added with the next ones
+
+const (
+	sheetsScope   = "https://www.googleapis.com/auth/spreadsheets"
+	sheetsEvery   = 10 * time.Second // Time between batches of rows
+	maxSheetsRows = 1000             // Rows kept while the spreadsheet can't be reached
+)
+
+// Key of a Google service account, as downloaded from the Cloud console
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	key         *rsa.PrivateKey
+}
+
+var (
+	sheetsMu   sync.Mutex
+	sheetsCfg  config.GoogleSheets // Spreadsheet to add rows to. No ID if none
+	sheetsRows [][]string          // Rows not added yet, oldest first
+)
+
+// Only one batch of rows is sent at a time, by whoever holds sheetsSendMu
+var (
+	sheetsSendMu   sync.Mutex
+	sheetsAccount  *serviceAccount // Account rows are added as, once read
+	sheetsKeyErr   string          // Why the account couldn't be read, as last logged
+	sheetsToken    string          // Access token of the account, once fetched
+	sheetsTokenEnd time.Time       // Time the token expires
+)
+
+// Add rows to the spreadsheet set in the supplied settings from now on, if
+// any. Rows already waiting are kept. The service account is read again
+// before the next rows are sent
+func setSheets(cfg *config.Config) {
+	sheetsMu.Lock()
+	sheetsCfg = cfg.GoogleSheets
+	sheetsMu.Unlock()
+	sheetsSendMu.Lock()
+	sheetsAccount, sheetsKeyErr, sheetsToken, sheetsTokenEnd = nil, "", "", time.Time{}
+	sheetsSendMu.Unlock()
+}
+
+// Read and check the JSON key of a service account from the supplied file
+func loadServiceAccount(path string) (*serviceAccount, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("%s isn't the JSON key of a service account: %v", path, err)
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if len(sa.ClientEmail) == 0 || block == nil {
+		return nil, fmt.Errorf("%s has no client_email or private_key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private_key of %s: %v", path, err)
+	}
+	var ok bool
+	if sa.key, ok = key.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("private_key of %s isn't an RSA key", path)
+	}
+	if len(sa.TokenURI) == 0 {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &sa, nil
+}
+
+// Method to queue a row for the spreadsheet about an incident of the target of
+// the supplied kind, if there is a spreadsheet
+func (tg *target) exportIncident(kind string, start, end time.Time) {
+	sheetsMu.Lock()
+	defer sheetsMu.Unlock()
+	if len(sheetsCfg.SpreadsheetID) == 0 {
+		return
+	}
+	d := end.Sub(start).Round(time.Second)
+	sheetsRows = append(sheetsRows, []string{
+		start.In(timeZone).Format("2006-01-02 15:04:05"),
+		fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60),
+		kind,
+		tg.addr,
+	})
+	if n := len(sheetsRows) - maxSheetsRows; n > 0 {
+		sheetsRows = sheetsRows[n:]
+	}
+}
+
+// Add the waiting rows to the spreadsheet every so often, until the supplied
+// context is done
+func appendSheetRows(ctx context.Context) {
+	defer crashed("spreadsheet")
+	tick := time.NewTicker(sheetsEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			sendSheetRows()
+		}
+	}
+}
+
+// Add the waiting rows to the spreadsheet, if there is one. If they can't be
+// added, they are kept for next time
+func sendSheetRows() {
+	sheetsSendMu.Lock()
+	defer sheetsSendMu.Unlock()
+	sheetsMu.Lock()
+	gs, rows := sheetsCfg, sheetsRows
+	sheetsRows = nil
+	sheetsMu.Unlock()
+	if len(rows) == 0 || len(gs.SpreadsheetID) == 0 {
+		return
+	}
+
+	err := appendRows(gs, rows)
+	if err == nil {
+		return
+	}
+	if msg := err.Error(); msg != sheetsKeyErr {
+		eLog.Printf("Adding %d rows to Google Sheets: %v. Trying again later", len(rows), err)
+		if sheetsAccount == nil {
+			sheetsKeyErr = msg // Logged once until the config is reloaded
+		}
+	}
+	sheetsMu.Lock()
+	sheetsRows = append(rows, sheetsRows...)
+	if n := len(sheetsRows) - maxSheetsRows; n > 0 {
+		sheetsRows = sheetsRows[n:]
+	}
+	sheetsMu.Unlock()
+}
+
+// Add the supplied rows to the end of the sheet of the supplied spreadsheet.
+// The caller holds sheetsSendMu
+func appendRows(gs config.GoogleSheets, rows [][]string) error {
+	if sheetsAccount == nil {
+		sa, err := loadServiceAccount(gs.Credentials)
+		if err != nil {
+			return err
+		}
+		sheetsAccount = sa
+	}
+	token, err := sheetsAccessToken()
+	if err != nil {
+		return fmt.Errorf("getting an access token: %v", err)
+	}
+	sheet := gs.Sheet
+	if len(sheet) == 0 {
+		sheet = "Sheet1"
+	}
+	rng := "'" + strings.Replace(sheet, "'", "''", -1) + "'!A:D"
+	u := "https://sheets.googleapis.com/v4/spreadsheets/" + url.PathEscape(gs.SpreadsheetID) +
+		"/values/" + url.PathEscape(rng) + ":append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS"
+	body, err := json.Marshal(map[string][][]string{"values": rows})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doGoogle(req, nil)
+}
+
+// Returns an access token of the service account, kept until shortly before
+// it expires. The caller holds sheetsSendMu
+func sheetsAccessToken() (string, error) {
+	if time.Until(sheetsTokenEnd) > credsMinTTL {
+		return sheetsToken, nil
+	}
+	sa := sheetsAccount
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": sa.ClientEmail, "scope": sheetsScope, "aud": sa.TokenURI,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", unsigned+"."+enc.EncodeToString(sig))
+	req, err := http.NewRequest("POST", sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var answer struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doGoogle(req, &answer); err != nil {
+		return "", err
+	}
+	if len(answer.AccessToken) == 0 {
+		return "", errors.New("no access_token in the answer")
+	}
+	sheetsToken, sheetsTokenEnd = answer.AccessToken, now.Add(time.Duration(answer.ExpiresIn)*time.Second)
+	return sheetsToken, nil
+}
+
+// Send the supplied request to a Google API, reading the JSON answer into the
+// supplied value if it isn't nil
+func doGoogle(req *http.Request, v interface{}) error {
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// On SIGINT or SIGTERM autoping stops sending pings and waits for those in
+// flight. An outage or period of flakey latency that is still going is closed
+// as "ongoing at shutdown", so reports and the incident history keep it, and
+// a summary of each target since startup is logged before autoping exits,
+// with status 4 if an outage was still going and 0 if not. A second signal
+// stops it straight away
+
+var pings sync.WaitGroup // Pings in flight
+
+// Method to close the target's outage or period of flakey latency at the
+// supplied time, if either is still going, and log a summary of the target
+// since startup, which is returned
+func (tg *target) shutdown(now time.Time) string {
+	tg.flushSampled()
+	tg.closeIncidents(now, "at shutdown")
+
+	// Incidents read back from the log finished before startup
+	since := tg.hist.start
+	sent, recv := tg.hist.counts(since)
+	outages, flakey := 0, 0
+	for _, inc := range tg.hist.allIncidents() {
+		switch {
+		case inc.end.Before(since):
+		case inc.kind == "Outage":
+			outages++
+		default:
+			flakey++
+		}
+	}
+	answered := 100.0
+	if sent > 0 {
+		answered = 100 * float64(recv) / float64(sent)
+	}
+	summary := fmt.Sprintf("Summary of %v since startup: %d pings, %.3f%% answered, %d outages, "+
+		"%d blips, %d flakey latency periods", now.Sub(since).Round(time.Second), sent, answered,
+		outages, tg.hist.blipCount(since), flakey)
+	tg.logf(pLog, "%s", summary)
+	tg.closeRing()
+	return summary
+}
+
+// Method to close the target's outage or period of flakey latency at the
+// supplied time, if either is still going, saying they were ongoing at the
+// supplied moment, e.g. "at shutdown"
+func (tg *target) closeIncidents(now time.Time, when string) {
+	ended, maint := tg.connInfo.closeOutage(now)
+	if ended.kind == "Outage" && maint {
+		tg.logf(oLog, "Outage during maintenance ongoing %s. Total outage duration %v", when,
+			now.Sub(ended.start))
+	} else if ended.kind == "Outage" {
+		tg.logf(oLog, "Outage ongoing %s. Total outage duration %v", when, now.Sub(ended.start))
+		tg.hist.addIncident("Outage", ended.start, now)
+		tg.exportIncident("Outage", ended.start, now)
+	}
+	if len(tg.spl) > 2 {
+		tg.endFlakeyPeriod("ongoing " + when)
+		tg.spl = nil
+	}
+	tg.publish()
+}
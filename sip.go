@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	ping "github.com/go-ping/ping"
+)
+
+// A VoIP line needs the PBX or trunk provider to answer as well as the link
+// to be up. A target given as a SIP URI, e.g. sip:pbx.example.com or
+// sip:trunk.example.net:5080, is probed by sending it a SIP OPTIONS request
+// over UDP and waiting for the answer, so its latency and jitter are tracked
+// like a pinged host's. Any final answer counts as a pong, even one turning
+// the request down, as that still shows the service is there, except a
+// 5xx or 6xx, which counts as a missed pong with the failure sip_error
+
+const sipPort = "5060" // Port SIP requests go to when the URI has none
+
+type sipProber struct{ noOptions } // Probes sip: targets with OPTIONS requests
+
+// Returns the host and port of the supplied SIP target, leaving out the user
+// and any parameters of its URI
+func sipHostPort(addr string) string {
+	hp := strings.TrimPrefix(addr, "sip:")
+	if i := strings.LastIndexByte(hp, '@'); i >= 0 {
+		hp = hp[i+1:]
+	}
+	if i := strings.IndexAny(hp, ";?"); i >= 0 {
+		hp = hp[:i]
+	}
+	if _, _, err := net.SplitHostPort(hp); err != nil {
+		hp = net.JoinHostPort(strings.Trim(hp, "[]"), sipPort)
+	}
+	return hp
+}
+
+// Method to check that the supplied SIP target has a host, and a port if it
+// gives one, and doesn't ask for a transport other than UDP
+func (sipProber) check(s *settings, addr string) error {
+	host, port, err := net.SplitHostPort(sipHostPort(addr))
+	if n, perr := strconv.Atoi(port); err != nil || len(host) == 0 || perr != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("target %s must be sip: and a host, and a port if it isn't 5060", addr)
+	}
+	lower := strings.ToLower(addr)
+	if strings.Contains(lower, ";transport=") && !strings.Contains(lower, ";transport=udp") {
+		return fmt.Errorf("target %s can only be probed over UDP", addr)
+	}
+	return nil
+}
+
+// Method to return the host of the supplied SIP target
+func (sipProber) host(s *settings, addr string) (string, error) {
+	host, _, err := net.SplitHostPort(sipHostPort(addr))
+	return host, err
+}
+
+// Method to probe the supplied target with a SIP OPTIONS request, in place of
+// a ping
+func (sipProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the request was sent
+	deadline := t.Add(tg.timeout())
+	id, err := probeID()
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+
+	conn, err := net.DialTimeout("udp", sipHostPort(tg.addr), time.Until(deadline))
+	if err != nil {
+		tg.probeFailed(t, failSIP, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	tg.logf(tLog, "Sending SIP OPTIONS to %s", conn.RemoteAddr())
+	if _, err := conn.Write(sipOptions(tg.addr, conn.LocalAddr().String(), id)); err != nil {
+		tg.probeFailed(t, failSIP, err)
+		return
+	}
+
+	// Wait for the final answer to this request, passing over provisional
+	// answers and those to earlier requests that came back late
+	buf := make([]byte, udpAnswerLimit)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			tg.probeFailed(t, failSIP, err)
+			return
+		}
+		rtt := time.Since(t)
+		code, status, callID, err := parseSIPAnswer(buf[:n])
+		if err != nil {
+			tg.logf(tLog, "Passing over what %s sent: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		if callID != id+"@autoping" || code < 200 {
+			continue
+		}
+		if code >= 500 {
+			tg.pingFailed(t, failSIP, fmt.Errorf("OPTIONS %s: %s", tg.addr, status))
+			return
+		}
+
+		if tg.samplePong(rtt) {
+			tg.logf(pLog, "%d bytes from %s: status=%d time=%v", n, conn.RemoteAddr(), code, rtt)
+		}
+		tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketsRecv: 1, Addr: tg.addr,
+			Rtts: []time.Duration{rtt}, MinRtt: rtt, MaxRtt: rtt, AvgRtt: rtt})
+		return
+	}
+}
+
+// Returns a SIP OPTIONS request for the supplied target, sent from the
+// supplied local address, with a Call-ID, branch and tag made from the
+// supplied ID
+func sipOptions(addr, local, id string) []byte {
+	uri := "sip:" + strings.TrimPrefix(addr, "sip:")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "OPTIONS %s SIP/2.0\r\n", uri)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP %s;branch=z9hG4bK%s;rport\r\n", local, id)
+	fmt.Fprintf(&b, "Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "From: <sip:autoping@%s>;tag=%s\r\n", local, id)
+	fmt.Fprintf(&b, "To: <%s>\r\n", uri)
+	fmt.Fprintf(&b, "Call-ID: %s@autoping\r\n", id)
+	fmt.Fprintf(&b, "CSeq: 1 OPTIONS\r\n")
+	fmt.Fprintf(&b, "Contact: <sip:autoping@%s>\r\n", local)
+	fmt.Fprintf(&b, "Accept: application/sdp\r\n")
+	fmt.Fprintf(&b, "User-Agent: autoping/%s\r\n", version)
+	fmt.Fprintf(&b, "Content-Length: 0\r\n\r\n")
+	return b.Bytes()
+}
+
+// Returns the status code, status line and Call-ID of the supplied SIP
+// answer, or an error if it isn't one
+func parseSIPAnswer(data []byte) (code int, status, callID string, err error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	line, err := r.ReadLine()
+	if err != nil {
+		return 0, "", "", err
+	}
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || fields[0] != "SIP/2.0" {
+		return 0, "", "", fmt.Errorf("%q isn't a SIP status line", line)
+	}
+	if code, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, "", "", fmt.Errorf("%q isn't a SIP status line", line)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return 0, "", "", err
+	}
+	callID = header.Get("Call-Id")
+	if len(callID) == 0 {
+		callID = header.Get("I") // Compact form
+	}
+	return code, strings.TrimPrefix(line, "SIP/2.0 "), strings.TrimSpace(callID), nil
+}
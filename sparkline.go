@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// A sparkline gives an at-a-glance picture of the RTTs around an incident. The
+// window is split into a fixed number of columns whatever its length, so each
+// column covers more pings as the window grows. Pings are put in columns by
+// the minute they were fired in, which is as fine as the history keeps them.
+// Columns where every ping was missed are shown as a dot, and columns without
+// pings as a space
+
+const sparkColumns = 60 // Number of columns in a sparkline
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Method to return a sparkline of the RTTs between the supplied times, each
+// column showing the longest RTT of the pings in it
+func (h *pingHistory) sparkline(from, to time.Time) string {
+	if !to.After(from) {
+		return ""
+	}
+	h.mu.Lock()
+	cols := make([]time.Duration, sparkColumns)
+	missed := make([]bool, sparkColumns)
+	seen := make([]bool, sparkColumns)
+	width := to.Sub(from) / sparkColumns
+	for _, b := range h.buckets {
+		t := time.Unix(b.start, 0)
+		if t.Before(from.Truncate(historyBucket)) || !t.Before(to) {
+			continue
+		}
+		i := 0
+		if t.After(from) {
+			i = int(t.Sub(from) / width)
+		}
+		if i >= sparkColumns {
+			i = sparkColumns - 1
+		}
+		if !seen[i] {
+			missed[i] = true
+		}
+		seen[i] = true
+		if b.recv > 0 {
+			missed[i] = false
+			if b.maxRTT > cols[i] {
+				cols[i] = b.maxRTT
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	var lo, hi time.Duration
+	for i, c := range cols {
+		if !seen[i] || missed[i] {
+			continue
+		}
+		if lo == 0 || c < lo {
+			lo = c
+		}
+		if c > hi {
+			hi = c
+		}
+	}
+
+	var sb strings.Builder
+	for i, c := range cols {
+		switch {
+		case !seen[i]:
+			sb.WriteRune(' ')
+		case missed[i]:
+			sb.WriteRune('·')
+		case hi == lo:
+			sb.WriteRune(sparkBlocks[0])
+		default:
+			sb.WriteRune(sparkBlocks[int(c-lo)*(len(sparkBlocks)-1)/int(hi-lo)])
+		}
+	}
+	return sb.String()
+}
+
+// Return a sparkline of the RTTs of the incident's target from an hour before
+// the supplied incident to an hour after it, or until now if that is sooner.
+// Incidents of targets that aren't being pinged have no sparkline
+func incidentSparkline(inc incident) string {
+	tg := findTarget(inc.target)
+	if tg == nil {
+		return ""
+	}
+	end := inc.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	to := end.Add(time.Hour)
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	return tg.hist.sparkline(inc.start.Add(-time.Hour), to)
+}
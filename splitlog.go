@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Each class of log lines - pings, outages, errors and status page requests -
+// can be written to a file of its own, set under logs in the config file,
+// which is rotated on its own schedule or once it reaches a size. Everything
+// else stays in the log file. That way the outage file holds the incident
+// history on its own and stays small enough to keep and grep for years, while
+// the ping file is rotated every day. Reading the log back reads the log file
+// and the file of every class, rotated ones included, in time order
+
+// Files of the classes of log lines that have their own, by class
+var logRoutes map[string]config.LogFile
+
+const defaultKeep = 7 // Rotated files kept unless the config file says otherwise
+
+// Returns the file the supplied class of log lines is written to
+func logFileFor(class string) string {
+	if l, ok := logRoutes[class]; ok {
+		return l.File
+	}
+	return *logFlag
+}
+
+// Returns the number of rotated files kept of the supplied log file
+func keptFiles(l config.LogFile) int {
+	if l.Keep == 0 {
+		return defaultKeep
+	}
+	return l.Keep
+}
+
+// Returns the name the supplied file gets when it is rotated for the nth time
+func rotatedName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Point the loggers of the classes of log lines that have a file of their own
+// at it. Returns the files, to be closed when autoping stops
+func openClassLogs() ([]io.Closer, error) {
+	loggers := map[string]*log.Logger{"ping": pLog, "outage": oLog, "error": eLog, "access": aLog}
+	var files []io.Closer
+	for _, class := range config.LogClasses {
+		l, ok := logRoutes[class]
+		if !ok {
+			continue
+		}
+		rf, err := openRotating(l)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("%s log: %v", class, err)
+		}
+		setLogOutput(loggers[class], rf)
+		files = append(files, rf)
+	}
+	return files, nil
+}
+
+// Returns the files the log written to the supplied file is read back from, a
+// list for each file written to, each list oldest first. When the supplied
+// file is the log file, the files of the classes of log lines that have their
+// own follow it, with their rotated files. Files that don't exist yet are
+// left out, except for the supplied one
+func logSources(path string) [][]string {
+	sources := [][]string{{path}}
+	if path != *logFlag {
+		return sources
+	}
+	for _, class := range config.LogClasses {
+		if l, ok := logRoutes[class]; ok {
+			sources = append(sources, writtenFiles(l))
+		}
+	}
+	return sources
+}
+
+// Returns the files of the supplied log file that exist, its rotated files
+// oldest first and then the file itself
+func writtenFiles(l config.LogFile) []string {
+	exists := func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	}
+	var files []string
+	for n := keptFiles(l); n >= 1; n-- {
+		if exists(rotatedName(l.File, n)) {
+			files = append(files, rotatedName(l.File, n))
+		}
+	}
+	if exists(l.File) {
+		files = append(files, l.File)
+	}
+	return files
+}
+
+// A log file that is rotated when its period is over or it grows too big
+type rotatingFile struct {
+	mu     sync.Mutex
+	cfg    config.LogFile
+	f      *os.File
+	size   int64     // Bytes in the file
+	period time.Time // Start of the rotation period the file was last written in
+}
+
+// Open the supplied log file for appending
+func openRotating(l config.LogFile) (*rotatingFile, error) {
+	rf := &rotatingFile{cfg: l}
+	return rf, rf.open()
+}
+
+// Method to open the file, carrying on from where it was left
+func (rf *rotatingFile) open() error {
+	f, err := openLog(rf.cfg.File)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.period = f, fi.Size(), rotationPeriod(rf.cfg.Rotate, fi.ModTime())
+	return nil
+}
+
+// Returns the start of the rotation period of the supplied schedule that the
+// supplied time is in, or the zero time if the file isn't rotated on a
+// schedule
+func rotationPeriod(rotate string, t time.Time) time.Time {
+	if len(rotate) == 0 {
+		return time.Time{}
+	}
+	_, start := reportPeriod(rotate, t)
+	return start
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	full := rf.cfg.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.cfg.MaxSize<<20
+	if full || !rotationPeriod(rf.cfg.Rotate, time.Now()).Equal(rf.period) {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Rotating %s: %v\n", rf.cfg.File, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Method to move the file to .1, and the rotated files before it one place
+// along, dropping the oldest, and start a new file
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	keep := keptFiles(rf.cfg)
+	os.Remove(rotatedName(rf.cfg.File, keep))
+	for n := keep - 1; n >= 1; n-- {
+		os.Rename(rotatedName(rf.cfg.File, n), rotatedName(rf.cfg.File, n+1))
+	}
+	err := os.Rename(rf.cfg.File, rotatedName(rf.cfg.File, 1))
+	if oerr := rf.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The status page is a read-only view of the current state of each target
+// and its recent availability. It has no controls, so it is safe to share
+// with whoever depends on the link
+
+const (
+	historyLength = 30 * 24 * time.Hour // How far back ping results are kept
+	historyBucket = time.Minute         // Time ping results are counted together over
+)
+
+type pingResult struct {
+	t   time.Time     // Time the ping was fired
+	ok  bool          // Did a pong come back?
+	rtt time.Duration // RTT of the pong, if one came back
+}
+
+// Ping results are kept as counts of the pings fired in each minute rather
+// than one by one, so 30 days of them take the same memory whatever the
+// interval. Availability is worked out to the minute, and sparklines, whose
+// columns are a minute or wider, show the longest RTT of each
+type pingBucket struct {
+	start  int64         // Unix time of the minute the pings were fired in
+	sent   int32         // Pings fired
+	recv   int32         // Pongs that came back
+	maxRTT time.Duration // Longest RTT of the pongs
+}
+
+type pingHistory struct {
+	mu        sync.Mutex
+	start     time.Time    // Time monitoring started
+	buckets   []pingBucket // Counts of recent pings by minute, oldest first
+	last      pingResult   // Result of the latest ping
+	pinged    bool         // Has a ping been recorded?
+	incidents []incident   // Finished outages and flakey latency periods
+	blips     []incident   // Missed pings too short to count as outages
+}
+
+// Method to record the result of a ping, dropping results that are too old
+// to be of interest
+func (h *pingHistory) record(t time.Time, ok bool, rtt time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.pinged || !t.Before(h.last.t) {
+		h.last, h.pinged = pingResult{t: t, ok: ok, rtt: rtt}, true
+	}
+
+	// Results come in the order their pings were fired, bar the odd one of a
+	// slow ping, so the bucket is looked for from the newest
+	start := t.Truncate(historyBucket).Unix()
+	i := len(h.buckets)
+	for i > 0 && h.buckets[i-1].start > start {
+		i--
+	}
+	if i == 0 || h.buckets[i-1].start != start {
+		h.buckets = append(h.buckets, pingBucket{})
+		copy(h.buckets[i+1:], h.buckets[i:])
+		h.buckets[i] = pingBucket{start: start}
+		i++
+	}
+	b := &h.buckets[i-1]
+	b.sent++
+	if ok {
+		b.recv++
+		if rtt > b.maxRTT {
+			b.maxRTT = rtt
+		}
+	}
+
+	oldest := h.last.t.Add(-historyLength).Truncate(historyBucket).Unix()
+	i = 0
+	for i < len(h.buckets) && h.buckets[i].start < oldest {
+		i++
+	}
+	h.buckets = h.buckets[i:]
+}
+
+// Method to record a blip, dropping blips that are too old to be of interest
+func (h *pingHistory) addBlip(start, end time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.blips = append(h.blips, incident{kind: "Blip", start: start, end: end})
+	i := 0
+	for i < len(h.blips) && end.Sub(h.blips[i].end) > historyLength {
+		i++
+	}
+	h.blips = h.blips[i:]
+}
+
+// Method to return the number of blips since the supplied time
+func (h *pingHistory) blipCount(since time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, b := range h.blips {
+		if !b.start.Before(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// Method to return the number of blips since the supplied time that started
+// in each hour of the day
+func (h *pingHistory) blipsByHour(since time.Time) []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hours := make([]int, 24)
+	for _, b := range h.blips {
+		if !b.start.Before(since) {
+			hours[b.start.In(timeZone).Hour()]++
+		}
+	}
+	return hours
+}
+
+// Method to return the number of pings sent since the minute of the supplied
+// time, and the number of those that got a pong back
+func (h *pingHistory) counts(since time.Time) (sent, recv int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	from := since.Truncate(historyBucket).Unix()
+	for _, b := range h.buckets {
+		if b.start < from {
+			continue
+		}
+		sent += int(b.sent)
+		recv += int(b.recv)
+	}
+	return sent, recv
+}
+
+// Method to return the percentage of pings since the supplied time that got
+// a pong back. Returns 100 if no pings have been sent in that time
+func (h *pingHistory) availability(since time.Time) float64 {
+	sent, recv := h.counts(since)
+	if sent == 0 {
+		return 100
+	}
+	return 100 * float64(recv) / float64(sent)
+}
+
+var statusTmpl = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>autoping</title>
+</head>
+<body>
+{{range .}}<h1>{{.Target}}</h1>
+<p>Current state: <strong>{{.State}}</strong></p>
+<p>RTT over the last hour: <code>{{.Spark}}</code></p>
+<p>Last 24h availability: {{.Day}}</p>
+<p>Blips in the last 24h: {{.Blips}}</p>
+<p>Blips in the last 30 days by hour of day:</p>
+<table>
+<tr>{{range $h, $n := .BlipHours}}<td>{{printf "%02d" $h}}</td>{{end}}</tr>
+<tr>{{range .BlipHours}}<td>{{.}}</td>{{end}}</tr>
+</table>
+<p>Last successful ping: {{.LastPing}}</p>
+<p>Outages in the last 30 days by duration:</p>
+<table>
+{{range .Buckets}}<tr><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<p>Failed pings since startup by cause:</p>
+<table>
+{{range .Failures}}<tr><td>{{.Label}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<p>Monitoring since {{.Since}}</p>
+{{end}}</body>
+</html>
+`))
+
+// Start serving the status page on the supplied address, allowing each client
+// the supplied number of requests per minute, until the supplied context is
+// done. Errors are logged, but don't stop the pings
+func serveStatus(ctx context.Context, addr string, perMin int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", statusHandler)
+	mux.HandleFunc("/badge/", badgeHandler)
+	mux.HandleFunc("/calendar.ics", calendarHandler)
+	mux.HandleFunc("/feed.atom", feedHandler)
+	mux.HandleFunc("/api/status", apiStatusHandler)
+	mux.HandleFunc("/api/targets", apiTargetsHandler)
+	mux.HandleFunc("/api/incidents", apiIncidentsHandler)
+	mux.HandleFunc("/api/samples", apiSamplesHandler)
+	mux.HandleFunc("/api/openapi.json", apiSpecHandler)
+	srv := &http.Server{Addr: addr, Handler: crashReporting(accessControl(tenantScope(mux), perMin))}
+	go func() {
+		defer crashed("status page")
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			eLog.Printf("Status page stopped: %v", err)
+		}
+	}()
+	go func() {
+		defer crashed("status page")
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+}
+
+// Method to return a short description of the current state of the target
+func (tg *target) state() string {
+	live := tg.liveState()
+	if !monitoringPaused().IsZero() {
+		return "Paused"
+	} else if live.outage {
+		return "Outage"
+	} else if live.degraded {
+		return "Degraded latency"
+	}
+	return "Up"
+}
+
+type bucket struct {
+	Label string
+	Count int
+}
+
+type targetStatus struct {
+	Target    string
+	State     string
+	Day       string
+	LastPing  string
+	Since     string
+	Buckets   []bucket
+	Failures  []bucket
+	Blips     int
+	BlipHours []int
+	Spark     string
+}
+
+// Method to gather what the status page shows about the target
+func (tg *target) status() targetStatus {
+	now := time.Now()
+	counts := tg.failures.snapshot()
+	failures := make([]bucket, len(failCategories))
+	for i, c := range failCategories {
+		failures[i] = bucket{Label: c, Count: counts[c]}
+	}
+	buckets := make([]bucket, len(outageBuckets))
+	for i, b := range outageBuckets {
+		buckets[i].Label = b.label
+	}
+	for _, inc := range tg.hist.allIncidents() {
+		if inc.kind == "Outage" && !inc.end.IsZero() {
+			buckets[outageBucket(inc.end.Sub(inc.start))].Count++
+		}
+	}
+
+	return targetStatus{
+		Target:    tg.addr,
+		State:     tg.state(),
+		Day:       lc.pct(tg.hist.availability(now.Add(-24 * time.Hour))),
+		LastPing:  lc.timestamp(tg.liveState().lastPong),
+		Since:     lc.timestamp(tg.hist.start),
+		Buckets:   buckets,
+		Failures:  failures,
+		Blips:     tg.hist.blipCount(now.Add(-24 * time.Hour)),
+		BlipHours: tg.hist.blipsByHour(now.Add(-30 * 24 * time.Hour)),
+		Spark:     tg.hist.sparkline(now.Add(-time.Hour), now),
+	}
+}
+
+// Render the status page, with a section for each target
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var statuses []targetStatus
+	for _, tg := range requestTargets(r) {
+		statuses = append(statuses, tg.status())
+	}
+	if err := statusTmpl.Execute(w, statuses); err != nil {
+		eLog.Printf("Rendering status page: %v", err)
+	}
+}
+
+var badgeTmpl = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Colour}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.MessageX}}" y="14">{{.Message}}</text>
+</g>
+</svg>
+`))
+
+// Render a shields.io style badge with the current state of a target and its
+// availability over the last 30 days. The badge lives at /badge/<target>.svg
+func badgeHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/badge/")
+	tg := requestTarget(r, strings.TrimSuffix(name, ".svg"))
+	if !strings.HasSuffix(name, ".svg") || tg == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := tg.state()
+	avail := tg.hist.availability(time.Now().Add(-30 * 24 * time.Hour))
+	colour := "#4c1"
+	switch {
+	case state == "Outage" || avail < 99:
+		colour = "#e05d44"
+	case state != "Up" || avail < 99.9:
+		colour = "#dfb317"
+	}
+
+	// Approximate text widths, as shields.io does, at 7 pixels per character
+	label := tg.addr
+	message := fmt.Sprintf("%s | %s", strings.ToLower(state), lc.pct(avail))
+	lw, mw := 7*len(label)+10, 7*len(message)+10
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := badgeTmpl.Execute(w, struct {
+		Label, Message, Colour          string
+		Width, LabelWidth, MessageWidth int
+		LabelX, MessageX                float64
+	}{label, message, colour, lw + mw, lw, mw, float64(lw) / 2, float64(lw) + float64(mw)/2})
+	if err != nil {
+		eLog.Printf("Rendering badge: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	ping "github.com/go-ping/ping"
+)
+
+// The history keeps a bucket a minute however often targets are pinged, drops
+// those older than 30 days, and counts and draws from them as it did from the
+// results one by one
+func TestHistoryBuckets(t *testing.T) {
+	h := &pingHistory{}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(historyLength + 24*time.Hour)
+	n := 0
+	for at := start; at.Before(end); at = at.Add(5 * time.Second) {
+		// Every pong of the last hour but the final 10 minutes comes back
+		ok := at.Before(end.Add(-10 * time.Minute))
+		h.record(at, ok, time.Duration(10+n%3)*time.Millisecond)
+		n++
+	}
+	if max := int(historyLength/historyBucket) + 1; len(h.buckets) > max {
+		t.Errorf("%d buckets kept, over the %d of 30 days", len(h.buckets), max)
+	}
+	if first := h.firstRecord(); first.Before(end.Add(-historyLength - historyBucket)) {
+		t.Errorf("results from %v kept, over 30 days before %v", first, end)
+	}
+	if sent, recv := h.counts(end.Add(-time.Hour)); sent != 720 || recv != 600 {
+		t.Errorf("counted %d pings and %d pongs in the last hour, want 720 and 600", sent, recv)
+	}
+	if got, want := h.availability(end.Add(-time.Hour)), 100*600/720.0; got != want {
+		t.Errorf("availability %v, want %v", got, want)
+	}
+	if r, ok := h.latest(); !ok || !r.t.Equal(end.Add(-5*time.Second)) || r.ok {
+		t.Errorf("latest result %+v, want the missed ping at %v", r, end.Add(-5*time.Second))
+	}
+	spark := []rune(h.sparkline(end.Add(-time.Hour), end))
+	if len(spark) != sparkColumns || spark[0] != sparkBlocks[0] || spark[sparkColumns-1] != '·' {
+		t.Errorf("sparkline %q doesn't end in missed pings", string(spark))
+	}
+
+	// A result of a slow ping that comes after those of later ones still
+	// counts in its own minute
+	h.record(end.Add(-30*time.Minute), false, 0)
+	if sent, recv := h.counts(end.Add(-time.Hour)); sent != 721 || recv != 600 {
+		t.Errorf("counted %d pings and %d pongs after a late result, want 721 and 600", sent, recv)
+	}
+	if r, _ := h.latest(); !r.t.Equal(end.Add(-5 * time.Second)) {
+		t.Errorf("latest result moved back to %v", r.t)
+	}
+}
+
+// The status page and API can be served while pings are being handled, and
+// show the state the last ping handled left the target in. Run with -race to
+// check they read it safely
+func TestStatusDuringPings(t *testing.T) {
+	w, _ := newBenchWalk(t, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			w.next(true)
+		}
+	}()
+	for polling := true; polling; {
+		select {
+		case <-done:
+			polling = false
+		default:
+		}
+		for _, tg := range currentTargets() {
+			tg.status()
+			tg.apiStatus()
+			tg.incidents()
+		}
+	}
+
+	tg := w.tgs[0]
+	w.stats = ping.Statistics{PacketsSent: 1, PacketLoss: 100}
+	tg.connInfo.isOutage = true
+	tg.cycleFinished(w.start.Add(time.Hour), &w.stats)
+	if state := tg.state(); state != "Outage" {
+		t.Errorf("state %s after a missed pong in an outage, want Outage", state)
+	}
+	if last := tg.apiStatus().LastSuccessfulPing; !last.Equal(tg.connInfo.lastSuccessfulPing) {
+		t.Errorf("last successful ping %v, want %v", last, tg.connInfo.lastSuccessfulPing)
+	}
+}
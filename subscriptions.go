@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Reports set in the config file are emailed on their own schedules: daily
+// ones just after midnight covering the day before, weekly ones on Monday
+// covering the week before and monthly ones on the 1st covering the month
+// before. Each is sent on its own, so a text digest can go to one person
+// every day while a CSV goes to a customer every month, and one that fails
+// doesn't hold up the others
+
+var (
+	reportsMu   sync.Mutex
+	reportSubs  []config.Report      // Reports to send
+	reportSMTP  config.SMTP          // Mail server to send them, and mail probes, through
+	reportsSent map[string]time.Time // End of the last period sent, by report
+)
+
+// Set the reports to send and the mail server from the supplied settings.
+// Reports that were already set carry on from the last period sent, and new
+// ones start with the next period
+func setReports(cfg *config.Config) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	if reportsSent == nil {
+		reportsSent = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for _, r := range cfg.Reports {
+		if _, ok := reportsSent[reportKey(r)]; !ok {
+			_, reportsSent[reportKey(r)] = reportPeriod(r.Schedule, now)
+		}
+	}
+	reportSubs, reportSMTP = cfg.Reports, cfg.SMTP
+}
+
+// Returns the key the supplied report is kept under in reportsSent, so that
+// changing its schedule starts it afresh
+func reportKey(r config.Report) string {
+	return r.Name + "/" + r.Schedule
+}
+
+// Returns the start and end of the last full period of the supplied schedule
+// before the supplied time
+func reportPeriod(schedule string, now time.Time) (start, end time.Time) {
+	today := startOfDay(now)
+	switch schedule {
+	case "weekly":
+		end = today.AddDate(0, 0, -(int(today.Weekday())+6)%7) // Monday
+		return end.AddDate(0, 0, -7), end
+	case "monthly":
+		end = today.AddDate(0, 0, 1-today.Day())
+		return end.AddDate(0, -1, 0), end
+	}
+	return today.AddDate(0, 0, -1), today
+}
+
+// Send each report once its period is over, checking every minute until the
+// supplied context is done
+func sendReports(ctx context.Context) {
+	defer crashed("reports")
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-tick.C:
+		}
+		reportsMu.Lock()
+		for _, r := range reportSubs {
+			start, end := reportPeriod(r.Schedule, now)
+			if !end.After(reportsSent[reportKey(r)]) {
+				continue
+			}
+			reportsSent[reportKey(r)] = end
+			go func(r config.Report, s config.SMTP) {
+				defer crashed("report " + r.Name)
+				if err := sendReport(r, s, start, end); err != nil {
+					eLog.Printf("Sending report %s: %v", r.Name, err)
+				} else {
+					tLog.Printf("Sent report %s to %s", r.Name, strings.Join(r.To, ", "))
+				}
+			}(r, reportSMTP)
+		}
+		reportsMu.Unlock()
+	}
+}
+
+// Write the supplied report covering start up to end, and email it through
+// the supplied mail server. The text digest goes in the body of the email,
+// and other formats are attached
+func sendReport(r config.Report, s config.SMTP, start, end time.Time) error {
+	only := r.Targets
+	if len(r.Tenant) > 0 {
+		t := findTenant(r.Tenant)
+		if t == nil {
+			return fmt.Errorf("tenant %s is gone", r.Tenant)
+		}
+		only = t.targets
+	}
+
+	period := start.Format("2006-01-02")
+	switch r.Schedule {
+	case "weekly":
+		period = "the week of " + period
+	case "monthly":
+		period = start.Format("January 2006")
+	}
+	subject := fmt.Sprintf("autoping %s report for %s", r.Schedule, period)
+
+	var report bytes.Buffer
+	var err error
+	ext, contentType := "txt", "text/plain"
+	switch r.Format {
+	case "csv":
+		ext, contentType = "csv", "text/csv"
+		err = dailyReport(&report, *logFlag, start, end, only)
+	case "ics":
+		ext, contentType = "ics", "text/calendar"
+		err = exportCalendar(&report, *logFlag, start, end, only)
+	case "pdf":
+		ext, contentType = "pdf", "application/pdf"
+		var text bytes.Buffer
+		if err = digest(&text, *logFlag, start, end, only); err == nil {
+			err = writePDF(&report, subject, text.Bytes())
+		}
+	default:
+		err = digest(&report, *logFlag, start, end, only)
+	}
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("autoping-%s-%s.%s", r.Name, start.Format("2006-01-02"), ext)
+	msg, err := reportMessage(s.From, r.To, subject, contentType, filename, report.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if len(s.Username) > 0 {
+		host, _, err := net.SplitHostPort(s.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Server, auth, s.From, r.To, msg)
+}
+
+// Returns an email with the supplied report. Plain text reports go in the
+// body, and anything else is attached under the supplied file name: text in
+// quoted-printable and anything else, such as PDF, in base64
+func reportMessage(from string, to []string, subject, contentType, filename string,
+	report []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().In(timeZone).Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+
+	if contentType == "text/plain" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writeQuotedPrintable(&buf, report)
+		return buf.Bytes(), err
+	}
+
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	parts := []struct {
+		header textproto.MIMEHeader
+		body   []byte
+	}{
+		{textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}},
+			[]byte(subject + " attached.\n")},
+		{textproto.MIMEHeader{"Content-Type": {contentType},
+			"Content-Disposition": {mime.FormatMediaType("attachment",
+				map[string]string{"filename": filename})}}, report},
+	}
+	for _, p := range parts {
+		text := strings.HasPrefix(p.header.Get("Content-Type"), "text/")
+		if text && !strings.Contains(p.header.Get("Content-Type"), "charset") {
+			p.header.Set("Content-Type", p.header.Get("Content-Type")+"; charset=utf-8")
+		}
+		if text {
+			p.header.Set("Content-Transfer-Encoding", "quoted-printable")
+		} else {
+			p.header.Set("Content-Transfer-Encoding", "base64")
+		}
+		w, err := mw.CreatePart(p.header)
+		if err != nil {
+			return nil, err
+		}
+		if text {
+			err = writeQuotedPrintable(w, p.body)
+		} else {
+			err = writeBase64(w, p.body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := mw.Close()
+	return buf.Bytes(), err
+}
+
+// Write the supplied data to w as base64 in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		if _, err := io.WriteString(w, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err := io.WriteString(w, enc+"\r\n")
+	return err
+}
+
+// Write the supplied text to w as quoted-printable
+func writeQuotedPrintable(w io.Writer, text []byte) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write(text); err != nil {
+		return err
+	}
+	return qw.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Pings run in their own goroutines. A panic in one of them is recovered and
+// logged instead of taking the whole process down, and further pings are held
+// back for a while, doubling each time they keep panicking, so a persistent
+// bug doesn't flood the log. Each panic is written up in a crash report too
+
+const (
+	minBackoff = 1 * time.Minute  // Wait after the first panic
+	maxBackoff = 30 * time.Minute // Longest wait between panicking pings
+)
+
+type supervisor struct {
+	mu      sync.Mutex
+	name    string        // Name of what is being supervised, for the log
+	backoff time.Duration // Current wait after a panic. 0 if all is well
+	until   time.Time     // No pings are started before this time
+}
+
+// Method to report whether a ping may be started at the supplied time
+func (s *supervisor) ready(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !t.Before(s.until)
+}
+
+// Method to run the supplied function, recovering from any panic in it. A
+// panic holds back further runs; a clean run resets the backoff
+func (s *supervisor) run(f func()) {
+	defer func() {
+		r := recover()
+		s.mu.Lock()
+		if r == nil {
+			s.backoff = 0
+			s.mu.Unlock()
+			return
+		}
+		s.backoff *= 2
+		if s.backoff == 0 {
+			s.backoff = minBackoff
+		} else if s.backoff > maxBackoff {
+			s.backoff = maxBackoff
+		}
+		s.until = time.Now().Add(s.backoff)
+		backoff := s.backoff
+		s.mu.Unlock()
+		stack := debug.Stack()
+		eLog.Printf("[%s] Ping panicked: %v. Holding back pings for %v\n%s", s.name, r, backoff, stack)
+		reportCrash(s.name, r, stack, false)
+	}()
+	f()
+}
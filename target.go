@@ -0,0 +1,282 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kurankat/autoping-go/ring"
+)
+
+// Each target has its own outage and latency tracking, so one process can
+// watch the gateway, the ISP's first hop and a server on the internet at once
+// and tell where along the path things went wrong
+
+type target struct {
+	addr     string      // IP address or hostname to ping
+	connInfo connTracker // Outage tracking
+	spl      []dLatPing  // List of recent pings with dodgy latency
+	latSlice queue       // RTTs of recent normal pings
+	meanLat  time.Duration
+	profile  rttProfile     // RTTs of today and previous days
+	hist     *pingHistory   // Recent ping results and incidents
+	failures failureCounter // Failed pings by category
+	sup      supervisor     // Holds back pings after a panic
+	ring     *ring.Ring     // Last 24h of pings. Nil unless -ring is given
+	sample   sampler        // Pongs sampled out of the log
+	adapt    adaptiveState  // Events raised today with -adaptive
+	nextPing time.Time      // Time the next ping is due. Zero until the first
+
+	certWarned certWarning // Last warning of a tls:// target's certificate expiring
+
+	// The state above is only touched by the goroutine pinging the target,
+	// and by pausing and shutting down once the pings in flight are done. What
+	// the status page and API show of it is copied here as each ping is
+	// handled, for them to read under the lock
+	liveMu sync.Mutex
+	live   liveState
+}
+
+// What the status page and API show of the state of a target
+type liveState struct {
+	outage   bool      // Is an outage going on?
+	degraded bool      // Are pings coming back with dodgy latency?
+	lastPong time.Time // Time the last ping that came back was fired
+}
+
+var (
+	targetsMu   sync.RWMutex
+	targets     []*target // Targets being pinged, in the order they were given
+	ringsOpened bool      // Have the targets' ring files been opened? Set once at startup
+)
+
+// Returns a target to ping the supplied address
+func newTarget(addr string) *target {
+	return &target{
+		addr:     addr,
+		hist:     &pingHistory{start: time.Now()},
+		sup:      supervisor{name: addr},
+		failures: failureCounter{counts: make(map[string]int)},
+	}
+}
+
+// Returns the targets being pinged. The slice is replaced rather than changed
+// when the targets change, so it can be used without holding the lock
+func currentTargets() []*target {
+	targetsMu.RLock()
+	defer targetsMu.RUnlock()
+	return targets
+}
+
+// Ping the supplied addresses from now on. Targets that were already being
+// pinged keep their state, and their ring files are made again if their
+// interval changed. Once ring files have been opened, targets added have
+// theirs opened before they are pinged, and those removed have theirs closed.
+// Returns the addresses that were added and removed
+func setTargets(addrs []string) (added, removed []string) {
+	var next []*target
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		tg := findTarget(addr)
+		if tg == nil {
+			tg = newTarget(addr)
+			if ringsOpened {
+				tg.openRing()
+			}
+			added = append(added, addr)
+		} else {
+			tg.resizeRing()
+		}
+		next = append(next, tg)
+	}
+	var gone []*target
+	for _, tg := range currentTargets() {
+		if !seen[tg.addr] {
+			removed = append(removed, tg.addr)
+			gone = append(gone, tg)
+		}
+	}
+
+	targetsMu.Lock()
+	targets = next
+	targetsMu.Unlock()
+	for _, tg := range gone {
+		tg.closeRing()
+	}
+	return added, removed
+}
+
+// Ping the addresses set now from now on, logging the targets that were added
+// and removed
+func updateTargets() {
+	added, removed := setTargets(pingAddrs())
+	for _, addr := range added {
+		tg := findTarget(addr)
+		tg.logf(pLog, "Started pinging")
+	}
+	for _, addr := range removed {
+		pLog.Printf("[%s] Stopped pinging", addr)
+	}
+}
+
+// Returns the target with the supplied address, or nil if it isn't monitored
+func findTarget(addr string) *target {
+	for _, tg := range currentTargets() {
+		if tg.addr == addr {
+			return tg
+		}
+	}
+	return nil
+}
+
+// Open the ring files of the targets, if ring files were asked for, and of
+// those added from now on
+func openRings() {
+	ringsOpened = true
+	for _, tg := range currentTargets() {
+		tg.openRing()
+	}
+}
+
+// Method to open the target's ring file, if ring files were asked for
+func (tg *target) openRing() {
+	if len(*ringFlag) == 0 {
+		return
+	}
+	r, err := ring.Create(filepath.Join(*ringFlag, url.PathEscape(tg.addr)+".ring"), tg.interval())
+	if err != nil {
+		tg.logf(eLog, "Opening ring file: %v", err)
+		return
+	}
+	tg.ring = r
+}
+
+// Method to make the target's ring file again if the target's interval has
+// changed since it was made
+func (tg *target) resizeRing() {
+	if tg.ring == nil {
+		return
+	}
+	if err := tg.ring.Resize(tg.interval()); err != nil {
+		tg.logf(eLog, "Resizing ring file: %v", err)
+	}
+}
+
+// Method to close the target's ring file, if it has one. Pings still in
+// flight when it is closed aren't written to it
+func (tg *target) closeRing() {
+	if tg.ring == nil {
+		return
+	}
+	if err := tg.ring.Close(); err != nil {
+		tg.logf(eLog, "Closing ring file: %v", err)
+	}
+}
+
+// Method to record the result of a ping in the target's history and ring file,
+// and pass it on to the remote-write receiver and cloud metrics
+func (tg *target) record(t time.Time, ok bool, rtt time.Duration) {
+	tg.hist.record(t, ok, rtt)
+	tg.queueSample(t, ok, rtt)
+	tg.addCloudStats(ok, rtt)
+	if tg.ring != nil {
+		tg.ring.Add(ring.Sample{Time: t, RTT: rtt, OK: ok})
+	}
+}
+
+// Method to log a message about the target with the supplied logger. The
+// address of the target goes in square brackets at the start of the message,
+// so lines about different targets can be told apart
+func (tg *target) logf(l *log.Logger, format string, v ...interface{}) {
+	l.Printf("[%s] "+format, append([]interface{}{tg.tag()}, v...)...)
+}
+
+// Method to copy the state of the target the status page and API show, once
+// a ping has been handled or the state has changed otherwise
+func (tg *target) publish() {
+	tg.liveMu.Lock()
+	defer tg.liveMu.Unlock()
+	tg.live = liveState{
+		outage:   tg.connInfo.isOutage,
+		degraded: len(tg.spl) > 0,
+		lastPong: tg.connInfo.lastSuccessfulPing,
+	}
+}
+
+// Method to return the state of the target last published
+func (tg *target) liveState() liveState {
+	tg.liveMu.Lock()
+	defer tg.liveMu.Unlock()
+	return tg.live
+}
+
+// Method to return the target's incidents, plus the outage in progress if
+// there is one
+func (tg *target) incidents() []incident {
+	incs := tg.hist.allIncidents()
+	if live := tg.liveState(); live.outage {
+		incs = append(incs, incident{kind: "Outage", start: live.lastPong})
+	}
+	for i := range incs {
+		incs[i].target = tg.addr
+	}
+	return incs
+}
+
+// Returns the addresses of the supplied targets
+func targetNames(tgs []*target) []string {
+	var names []string
+	for _, tg := range tgs {
+		names = append(names, tg.addr)
+	}
+	return names
+}
+
+// Returns the incidents of every supplied target, oldest first
+func allIncidents(tgs []*target) []incident {
+	var incs []incident
+	for _, tg := range tgs {
+		incs = append(incs, tg.incidents()...)
+	}
+	sort.SliceStable(incs, func(i, j int) bool { return incs[i].start.Before(incs[j].start) })
+	return incs
+}
+
+// Returns true if lines about the supplied target should go in a report
+// limited to the supplied targets. Lines logged before autoping could ping
+// several targets don't name one, and are always included
+func inTargets(name string, only []string) bool {
+	if len(only) == 0 || len(name) == 0 {
+		return true
+	}
+	for _, o := range only {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The -i flag can be repeated, and each one can hold a comma-separated list
+type targetList []string
+
+func (tl *targetList) String() string {
+	return strings.Join(*tl, ",")
+}
+
+func (tl *targetList) Set(v string) error {
+	for _, addr := range strings.Split(v, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			*tl = append(*tl, addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Fleets of hosts are usually listed by another system, such as an inventory
+// or a DHCP server, and change too often to edit the config file and send
+// SIGHUP each time. -targets-file names a file listing targets one a line,
+// each optionally followed by settings of its own, that are pinged as well as
+// those given with -i or in the config file. autoping watches the file, and
+// starts pinging a target as soon as its line is added, and stops when it is
+// taken out, without restarting. Targets that stay keep their outage and
+// latency tracking
+
+const targetsSettle = 500 * time.Millisecond // Time the file has to go unchanged before it is read again
+
+var targetsFileFlag = flag.String("targets-file", "", "file listing more targets to ping, one a line with any settings of their own, e.g. \"10.0.0.1 interval=5s sample=10\". Watched for changes")
+
+// Returns the supplied settings with the targets and settings of the targets
+// file, if there is one, added to those of the flags and config file
+func loadTargetsFile(s *settings) (*settings, error) {
+	if len(*targetsFileFlag) == 0 {
+		return s, nil
+	}
+	ts, err := config.LoadTargets(*targetsFileFlag)
+	if err != nil {
+		return nil, err
+	}
+	return s.withTargetsFile(ts), nil
+}
+
+// Read the targets file again and ping the targets in it from now on. If
+// anything is wrong with it, nothing changes
+func reloadTargetsFile() error {
+	s, err := loadTargetsFile(currentSettings())
+	if err != nil {
+		return err
+	}
+	if err := s.checkReload(); err != nil {
+		return err
+	}
+	useSettings(s)
+	updateTargets()
+	return nil
+}
+
+// Send to the supplied channel whenever the targets file changes, until the
+// supplied context is done. The directory is watched rather than the file, as
+// the systems writing such files often replace them. Errors are logged, but
+// don't stop the pings
+func watchTargetsFile(ctx context.Context, changed chan<- struct{}) {
+	path := filepath.Clean(*targetsFileFlag)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		eLog.Printf("Watching targets file: %v", err)
+		return
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		eLog.Printf("Watching targets file: %v", err)
+		w.Close()
+		return
+	}
+	go func() {
+		defer crashed("targets file")
+		defer w.Close()
+		// Wait for the file to settle, so one written in pieces is read once
+		var settle <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == path {
+					settle = time.After(targetsSettle)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				eLog.Printf("Watching targets file: %v", err)
+			case <-settle:
+				settle = nil
+				select {
+				case changed <- struct{}{}:
+				default: // A reload is already waiting
+				}
+			}
+		}
+	}()
+}
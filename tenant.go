@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// For small MSPs, one autoping can watch the links of several customers. Each
+// tenant set in the config file has its own targets and token, and requests
+// carrying its token only see its targets on the status page, badges, feed,
+// calendar and API. The operator token sees every target, and can narrow the
+// view to one tenant with ?tenant=<name>. Once there are tenants, requests
+// without a token are turned away
+
+type tenant struct {
+	name    string
+	token   string
+	targets []string // Addresses pinged for the tenant
+}
+
+// Returns the tenants set in the supplied config, by name
+func configTenants(cfg *config.Config) []tenant {
+	var ts []tenant
+	for name, t := range cfg.Tenants {
+		ts = append(ts, tenant{name: name, token: t.Token, targets: t.Targets})
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].name < ts[j].name })
+	return ts
+}
+
+// Returns the tenants and operator token in use
+func currentTenants() ([]tenant, string) {
+	s := currentSettings()
+	return s.tenants, s.operatorToken
+}
+
+// Returns the tenant with the supplied name, or nil if there is none
+func findTenant(name string) *tenant {
+	ts, _ := currentTenants()
+	for i := range ts {
+		if ts[i].name == name {
+			return &ts[i]
+		}
+	}
+	return nil
+}
+
+type scopeKey struct{}
+
+// Wrap the supplied handler so it only sees the targets the request's token
+// allows. The token is taken from an "Authorization: Bearer" header or the
+// "token" query parameter, so badges and calendar subscriptions can carry it
+// in their URL. Without tenants, every request sees every target
+func tenantScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts, opToken := currentTenants()
+		if len(ts) == 0 || r.URL.Path == "/api/openapi.json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := requestToken(r)
+		name := r.URL.Query().Get("tenant")
+		var scope *tenant
+		switch {
+		case len(token) == 0:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="autoping"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		case tokenMatches(token, opToken):
+			if len(name) > 0 {
+				if scope = findTenant(name); scope == nil {
+					http.NotFound(w, r)
+					return
+				}
+			}
+		default:
+			for i := range ts {
+				if tokenMatches(token, ts[i].token) {
+					scope = &ts[i]
+				}
+			}
+			if scope == nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if len(name) > 0 && name != scope.name {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		if scope != nil {
+			r = r.WithContext(context.WithValue(r.Context(), scopeKey{}, scope.targets))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the token the supplied request carries, or "" if none
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Returns a name for the token the supplied request carries that doesn't give
+// it away: "operator" for the operator token and "tenant:" and the name of the
+// tenant for a tenant's, or "" if it carries neither
+func tokenID(r *http.Request) string {
+	token := requestToken(r)
+	if len(token) == 0 {
+		return ""
+	}
+	ts, opToken := currentTenants()
+	if tokenMatches(token, opToken) {
+		return "operator"
+	}
+	for _, t := range ts {
+		if tokenMatches(token, t.token) {
+			return "tenant:" + t.name
+		}
+	}
+	return ""
+}
+
+// Returns true if the supplied token is the expected one, taking the same time
+// whatever part of it is wrong
+func tokenMatches(token, expected string) bool {
+	return len(expected) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// Returns the target with the supplied address if the supplied request may see
+// it, or nil if not
+func requestTarget(r *http.Request, addr string) *target {
+	for _, tg := range requestTargets(r) {
+		if tg.addr == addr {
+			return tg
+		}
+	}
+	return nil
+}
+
+// Returns the targets the supplied request may see, in the order they are
+// pinged
+func requestTargets(r *http.Request) []*target {
+	only, ok := r.Context().Value(scopeKey{}).([]string)
+	if !ok {
+		return currentTargets()
+	}
+	var tgs []*target
+	for _, tg := range currentTargets() {
+		if inTargets(tg.addr, only) {
+			tgs = append(tgs, tg)
+		}
+	}
+	return tgs
+}
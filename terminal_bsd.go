@@ -0,0 +1,9 @@
+//go:build darwin || freebsd || openbsd || netbsd || dragonfly
+// +build darwin freebsd openbsd netbsd dragonfly
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// Requests that read and set the settings of a terminal
+const ioctlGetTermios, ioctlSetTermios = unix.TIOCGETA, unix.TIOCSETA
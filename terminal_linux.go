@@ -0,0 +1,6 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// Requests that read and set the settings of a terminal
+const ioctlGetTermios, ioctlSetTermios = unix.TCGETS, unix.TCSETS
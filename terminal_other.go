@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Raw mode isn't supported here, so "autoping incidents" reads commands a line
+// at a time
+func rawTerminal(f *os.File) (func(), error) {
+	return nil, errors.New("raw terminal mode isn't supported on this system")
+}
+
+// Returns the rows and columns of the supplied terminal, which are taken to
+// be 24 by 80 here
+func terminalSize(f *os.File) (rows, cols int) {
+	return 24, 80
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/kurankat/autoping-go/config"
+)
+
+// The machine autoping runs on often keeps UTC while the people reading its
+// reports don't. -timezone, or timezone in the config file, picks the zone
+// the log, digests, reports, calendar and status page write times in, and
+// whose midnight days, maintenance windows and scheduled reports roll over
+// at. The system's zone is left alone, so libraries and the rest of the
+// process keep it. Log lines carry the zone's offset after their time, so
+// they read back as the same moment whatever zone they are read in. Lines
+// logged before they did read as if they had been logged in the zone in use.
+// A new zone takes effect on restart
+
+var timezoneFlag = flag.String("timezone", "", "time zone to write times in and roll days over at, e.g. Australia/Sydney. The system's if not set")
+
+var timeZone = time.Local // Zone times are written in and days roll over at
+
+// Use the zone given with -timezone, or else in the supplied settings, as the
+// zone times are written in, if either is given. Called before anything else
+// reads the time
+func setTimezone(cfg *config.Config) error {
+	name := *timezoneFlag
+	if len(name) == 0 {
+		name = cfg.Timezone
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("-timezone: %v", err)
+	}
+	timeZone = loc
+	return nil
+}
+
+// Returns the midnight in the zone in use that starts the day of the supplied
+// time
+func startOfDay(t time.Time) time.Time {
+	t = t.In(timeZone)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, timeZone)
+}
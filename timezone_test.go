@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Lines are logged in the zone in use with its offset, and read back as the
+// same moment, while lines from before they carried it read as logged in it
+func TestLogLineZone(t *testing.T) {
+	before := timeZone
+	t.Cleanup(func() { timeZone = before })
+	timeZone = time.FixedZone("AEST", 10*60*60)
+
+	var b strings.Builder
+	newLogger(&b, "PING - ").Printf("[192.0.2.1] Started pinging")
+	line := strings.TrimSuffix(b.String(), "\n")
+	if !strings.Contains(line, " +1000 [192.0.2.1] ") {
+		t.Errorf("line %q doesn't carry the zone's offset", line)
+	}
+	l, ok := parseLogLine(line)
+	if !ok || l.target != "192.0.2.1" || l.msg != "Started pinging" || time.Since(l.t) > time.Minute {
+		t.Errorf("%q read back as %+v", line, l)
+	}
+
+	for _, test := range []struct {
+		line string
+		t    time.Time
+		msg  string
+	}{
+		{"PING - 2018/06/02 10:00:00 [192.0.2.1] Started pinging", time.Date(2018, 6, 2, 0, 0, 0, 0, time.UTC),
+			"Started pinging"},
+		{"PING - 2018/06/02 10:00:00 +1000 [192.0.2.1] Started pinging", time.Date(2018, 6, 2, 0, 0, 0, 0, time.UTC),
+			"Started pinging"},
+		{"PING - 2018/06/02 10:00:00 -0500 [192.0.2.1] Started pinging", time.Date(2018, 6, 2, 15, 0, 0, 0, time.UTC),
+			"Started pinging"},
+		{"PING - 2018/06/02 10:00:00 Stopped", time.Date(2018, 6, 2, 0, 0, 0, 0, time.UTC), "Stopped"},
+	} {
+		l, ok := parseLogLine(test.line)
+		if !ok || !l.t.Equal(test.t) || l.t.Location() != timeZone || l.msg != test.msg {
+			t.Errorf("%q read back as %q at %v, not %q at %v", test.line, l.msg, l.t, test.msg, test.t.In(timeZone))
+		}
+	}
+}
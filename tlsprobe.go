@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	ping "github.com/go-ping/ping"
+)
+
+// A target given as tls:// and a host and port, e.g. tls://example.com:443,
+// is probed by connecting to it and completing a TLS handshake, which covers
+// both whether the service can be reached and whether its certificate is
+// still good. The time to the end of the handshake stands in for the RTT. A
+// handshake that fails, as when the certificate has expired or doesn't match
+// the host, is a missed pong with the failure tls_error. Once a day, and
+// when the certificate changes, autoping warns of a certificate that expires
+// within -cert-days, and the digest shows when it does
+
+const tlsPort = "443" // Port connected to when the target has none
+
+var certDaysFlag = flag.Int("cert-days", 14, "warn when the certificate of a tls:// target expires within this many days")
+
+// Warning of a certificate expiring soon
+var certLine = regexp.MustCompile(`^Certificate of (.+) expires in (\d+) days?, on (\S+)$`)
+
+// Day the target's certificate was last warned of, and when it expires
+type certWarning struct {
+	day      string
+	notAfter time.Time
+}
+
+// Counts the bytes read from a connection
+type countingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n += n
+	return n, err
+}
+
+type tlsProber struct{ noOptions } // Probes tls:// targets with a handshake
+
+// Returns the host and port of the supplied TLS target
+func tlsHostPort(addr string) (host, hostport string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if len(u.Hostname()) == 0 {
+		return "", "", fmt.Errorf("target %s must be tls:// and a host, and a port if it isn't 443", addr)
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = tlsPort
+	}
+	return u.Hostname(), net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// Method to check that the supplied TLS target has a host
+func (tlsProber) check(s *settings, addr string) error {
+	_, _, err := tlsHostPort(addr)
+	return err
+}
+
+// Method to return the host of the supplied TLS target
+func (tlsProber) host(s *settings, addr string) (string, error) {
+	host, _, err := tlsHostPort(addr)
+	return host, err
+}
+
+// Method to probe the supplied target with a TLS handshake, in place of a
+// ping
+func (tlsProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the connection was started
+	deadline := t.Add(tg.timeout())
+	host, hostport, err := tlsHostPort(tg.addr)
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+
+	tg.logf(tLog, "Starting TLS handshake with %s", hostport)
+	raw, err := net.DialTimeout("tcp", hostport, time.Until(deadline))
+	if err != nil {
+		tg.probeFailed(t, failTLS, err)
+		return
+	}
+	conn := &countingConn{Conn: raw}
+	tc := tls.Client(conn, &tls.Config{ServerName: host})
+	defer tc.Close()
+	tc.SetDeadline(deadline)
+	if err := tc.Handshake(); err != nil {
+		tg.probeFailed(t, failTLS, err)
+		return
+	}
+	rtt := time.Since(t)
+
+	chain := tc.ConnectionState().PeerCertificates
+	cert := earliestExpiry(chain)
+	name := host
+	if cert != chain[0] && len(cert.Subject.CommonName) > 0 {
+		name = cert.Subject.CommonName // An intermediate expires first
+	}
+	tg.warnCertExpiry(name, cert.NotAfter, t)
+	if tg.samplePong(rtt) {
+		tg.logf(pLog, "%d bytes from %s: expires=%s time=%v", conn.n, raw.RemoteAddr(),
+			cert.NotAfter.In(timeZone).Format("2006-01-02"), rtt)
+	}
+	tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketsRecv: 1, Addr: tg.addr,
+		Rtts: []time.Duration{rtt}, MinRtt: rtt, MaxRtt: rtt, AvgRtt: rtt})
+}
+
+// Returns the certificate in the supplied chain that expires first, as the
+// chain is only good until then
+func earliestExpiry(chain []*x509.Certificate) *x509.Certificate {
+	first := chain[0]
+	for _, c := range chain[1:] {
+		if c.NotAfter.Before(first.NotAfter) {
+			first = c
+		}
+	}
+	return first
+}
+
+// Method to warn of the target's certificate of the supplied name, expiring
+// at the supplied time, if it expires within -cert-days. The warning is only
+// given once a day, unless the certificate changes
+func (tg *target) warnCertExpiry(name string, notAfter, t time.Time) {
+	left := notAfter.Sub(t)
+	if left > time.Duration(*certDaysFlag)*24*time.Hour {
+		tg.certWarned = certWarning{}
+		return
+	}
+	t = t.In(timeZone)
+	day := t.Format("2006-01-02")
+	if tg.certWarned.day == day && tg.certWarned.notAfter.Equal(notAfter) {
+		return
+	}
+	tg.certWarned = certWarning{day, notAfter}
+	// Days go by the calendar, so it reads right with the date it expires on
+	end := notAfter.In(timeZone)
+	days := int(time.Date(end.Year(), end.Month(), end.Day(), 12, 0, 0, 0, time.UTC).Sub(
+		time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+	unit := "days"
+	if days == 1 {
+		unit = "day"
+	}
+	tg.logf(eLog, "Certificate of %s expires in %d %s, on %s", name, days, unit,
+		end.Format("2006-01-02"))
+}
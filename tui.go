@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// In a terminal, "autoping incidents" is driven by single keys. The list
+// fills the screen with the selected incident highlighted, moved with the
+// arrow keys or j and k. Typing after / narrows the list down as each key is
+// pressed, Enter opens the selected incident and Esc goes back. a and n
+// acknowledge and add a note to the selected or opened incident. The screen is
+// redrawn whole after every key, which is plenty fast for a few screens of
+// text and never leaves half of an old screen behind
+
+// Keys that aren't characters
+const (
+	keyUp = iota + utf8.MaxRune + 1
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyEsc
+	keyBackspace
+	keyQuit // Ctrl-C or Ctrl-D
+	keyNone // A sequence of a key that isn't used
+)
+
+// What the screen shows
+const (
+	screenList   = iota // The incidents that pass the filters
+	screenDetail        // The detail of the opened incident
+	screenFilter        // The list, with the filter being typed
+	screenNote          // The list or detail, with a note being typed
+)
+
+type keyBrowser struct {
+	*browser
+	out        io.Writer
+	rows, cols int
+	screen     int
+	back       int              // Screen to go back to once a note is typed
+	cursor     int              // Selected incident in the list
+	top        int              // Incident at the top of the list on the screen
+	opened     *browsedIncident // Incident whose detail is shown
+	lines      []string         // Lines of the detail
+	scroll     int              // Line of the detail at the top of the screen
+	input      []rune           // Filter or note being typed
+	status     string           // Message shown at the bottom until the next key
+}
+
+// Browse the incidents of the supplied browser with the keys read from in,
+// drawing on out, a terminal of the supplied size, until q is pressed
+func browseKeys(b *browser, in io.Reader, out io.Writer, rows, cols int) error {
+	kb := &keyBrowser{browser: b, out: out, rows: rows, cols: cols}
+	kb.refresh()
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l") // Alternate screen without a cursor
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+	r := bufio.NewReader(in)
+	for {
+		kb.draw()
+		k, err := readKey(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if kb.key(k) {
+			return nil
+		}
+	}
+}
+
+// Read the next key pressed from the supplied terminal. Escape sequences of
+// special keys arrive together, so an Esc with nothing after it is the key
+func readKey(r *bufio.Reader) (rune, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 0x7f, 0x08:
+		return keyBackspace, nil
+	case 0x03, 0x04:
+		return keyQuit, nil
+	case 0x1b:
+	default:
+		return c, nil
+	}
+	if r.Buffered() == 0 {
+		return keyEsc, nil
+	}
+	c, _, _ = r.ReadRune()
+	if c != '[' && c != 'O' {
+		return keyNone, nil
+	}
+	var seq []rune
+	for r.Buffered() > 0 {
+		c, _, _ = r.ReadRune()
+		seq = append(seq, c)
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return keyUp, nil
+	case "B":
+		return keyDown, nil
+	case "C":
+		return keyRight, nil
+	case "D":
+		return keyLeft, nil
+	case "5~":
+		return keyPageUp, nil
+	case "6~":
+		return keyPageDown, nil
+	case "H", "1~", "7~":
+		return keyHome, nil
+	case "F", "4~", "8~":
+		return keyEnd, nil
+	}
+	return keyNone, nil
+}
+
+// Method to act on the supplied key. Returns true to quit
+func (kb *keyBrowser) key(k rune) bool {
+	kb.status = ""
+	if k == keyQuit {
+		return true
+	}
+	switch kb.screen {
+	case screenFilter:
+		kb.typeFilter(k)
+	case screenNote:
+		kb.typeNote(k)
+	case screenDetail:
+		return kb.detailKey(k)
+	default:
+		return kb.listKey(k)
+	}
+	return false
+}
+
+// Method to act on a key pressed on the list. Returns true to quit
+func (kb *keyBrowser) listKey(k rune) bool {
+	page := kb.listRows()
+	switch k {
+	case 'q':
+		return true
+	case keyUp, 'k':
+		kb.move(-1)
+	case keyDown, 'j':
+		kb.move(1)
+	case keyPageUp:
+		kb.move(-page)
+	case keyPageDown, ' ':
+		kb.move(page)
+	case keyHome, 'g':
+		kb.move(-len(kb.shown))
+	case keyEnd, 'G':
+		kb.move(len(kb.shown))
+	case keyEnter, keyRight, 'l':
+		if bi := kb.selected(); bi != nil {
+			kb.open(bi)
+		}
+	case '/':
+		kb.screen, kb.input = screenFilter, []rune(kb.filter)
+	case keyEsc:
+		kb.filter = ""
+		kb.refresh()
+	case 'u':
+		kb.unacked = !kb.unacked
+		kb.refresh()
+	case 'a':
+		if bi := kb.selected(); bi != nil {
+			kb.status = kb.annotate(bi, "")
+			kb.refresh()
+		}
+	case 'n':
+		if kb.selected() != nil {
+			kb.screen, kb.back, kb.input = screenNote, screenList, nil
+		}
+	}
+	return false
+}
+
+// Method to act on a key pressed on the detail of an incident. Returns true
+// to quit
+func (kb *keyBrowser) detailKey(k rune) bool {
+	page := kb.rows - 2
+	switch k {
+	case 'q':
+		return true
+	case keyEsc, keyLeft, keyBackspace, 'h':
+		kb.screen = screenList
+	case keyUp, 'k':
+		kb.scrollBy(-1)
+	case keyDown, 'j':
+		kb.scrollBy(1)
+	case keyPageUp:
+		kb.scrollBy(-page)
+	case keyPageDown, ' ':
+		kb.scrollBy(page)
+	case 'a':
+		kb.status = kb.annotate(kb.opened, "")
+		kb.open(kb.opened)
+	case 'n':
+		kb.screen, kb.back, kb.input = screenNote, screenDetail, nil
+	}
+	return false
+}
+
+// Method to type the supplied key into the filter, narrowing the list as it
+// goes. Enter keeps the filter and Esc drops it
+func (kb *keyBrowser) typeFilter(k rune) {
+	switch k {
+	case keyEnter:
+		kb.screen = screenList
+		return
+	case keyEsc:
+		kb.screen, kb.input = screenList, nil
+	default:
+		kb.edit(k)
+	}
+	kb.filter = strings.ToLower(string(kb.input))
+	kb.refresh()
+}
+
+// Method to type the supplied key into the note, adding it to the selected or
+// opened incident on Enter. Esc drops it
+func (kb *keyBrowser) typeNote(k rune) {
+	switch k {
+	case keyEnter:
+		kb.screen = kb.back
+		note := strings.TrimSpace(string(kb.input))
+		if len(note) == 0 {
+			return
+		}
+		if kb.back == screenDetail {
+			kb.status = kb.annotate(kb.opened, note)
+			kb.open(kb.opened)
+		} else if bi := kb.selected(); bi != nil {
+			kb.status = kb.annotate(bi, note)
+		}
+	case keyEsc:
+		kb.screen = kb.back
+	default:
+		kb.edit(k)
+	}
+}
+
+// Method to add the supplied key to the text being typed, or take the last
+// character off it on Backspace
+func (kb *keyBrowser) edit(k rune) {
+	switch {
+	case k == keyBackspace:
+		if len(kb.input) > 0 {
+			kb.input = kb.input[:len(kb.input)-1]
+		}
+	case k <= utf8.MaxRune && unicode.IsPrint(k):
+		kb.input = append(kb.input, k)
+	}
+}
+
+// Method to work out the incidents that pass the filters again, keeping the
+// selection on the screen
+func (kb *keyBrowser) refresh() {
+	kb.browser.refresh()
+	kb.move(0)
+}
+
+// Method to move the selection by the supplied number of incidents, scrolling
+// the list to keep it on the screen
+func (kb *keyBrowser) move(by int) {
+	kb.cursor += by
+	if kb.cursor >= len(kb.shown) {
+		kb.cursor = len(kb.shown) - 1
+	}
+	if kb.cursor < 0 {
+		kb.cursor = 0
+	}
+	if kb.cursor < kb.top {
+		kb.top = kb.cursor
+	}
+	page := kb.listRows()
+	if kb.cursor >= kb.top+page {
+		kb.top = kb.cursor - page + 1
+	}
+	if last := len(kb.shown) - page; kb.top > last {
+		kb.top = last
+	}
+	if kb.top < 0 {
+		kb.top = 0
+	}
+}
+
+// Method to return the selected incident, or nil if none pass the filters
+func (kb *keyBrowser) selected() *browsedIncident {
+	if kb.cursor < len(kb.shown) {
+		return kb.shown[kb.cursor]
+	}
+	return nil
+}
+
+// Method to show the detail of the supplied incident
+func (kb *keyBrowser) open(bi *browsedIncident) {
+	var buf bytes.Buffer
+	kb.detail(&buf, bi)
+	kb.opened, kb.lines, kb.screen = bi, strings.Split(strings.TrimRight(buf.String(), "\n"), "\n"), screenDetail
+	kb.scrollBy(0)
+}
+
+// Method to scroll the detail by the supplied number of lines
+func (kb *keyBrowser) scrollBy(by int) {
+	kb.scroll += by
+	if max := len(kb.lines) - (kb.rows - 2); kb.scroll > max {
+		kb.scroll = max
+	}
+	if kb.scroll < 0 {
+		kb.scroll = 0
+	}
+}
+
+// Method to return the number of incidents the list has room for
+func (kb *keyBrowser) listRows() int {
+	if n := kb.rows - 3; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// Method to draw the screen: a title line, the list or the detail, and a line
+// with what is being typed, the last message or the keys to press
+func (kb *keyBrowser) draw() {
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	line := func(s string, highlight bool) {
+		if r := []rune(s); len(r) > kb.cols {
+			s = string(r[:kb.cols])
+		}
+		if highlight {
+			s = "\x1b[7m" + s + "\x1b[0m"
+		}
+		sb.WriteString(s + "\x1b[K\n")
+	}
+
+	var hint string
+	if kb.screen == screenDetail || kb.screen == screenNote && kb.back == screenDetail {
+		line(fmt.Sprintf("autoping incidents - %s of %s", kb.opened.kind, kb.opened.target), true)
+		for i := kb.scroll; i < len(kb.lines) && i < kb.scroll+kb.rows-2; i++ {
+			line(kb.lines[i], false)
+		}
+		hint = "↑↓ scroll  Esc back  a acknowledge  n note  q quit"
+	} else {
+		title := fmt.Sprintf("autoping incidents - %d of %d", len(kb.shown), len(kb.incs))
+		if len(kb.filter) > 0 {
+			title += fmt.Sprintf(", matching %q", kb.filter)
+		}
+		if kb.unacked {
+			title += ", unacknowledged"
+		}
+		line(title, true)
+		line(fmt.Sprintf("  %-20s %-24s %-22s %s", "Target", "Kind", "Started", "Duration"), false)
+		if len(kb.shown) == 0 {
+			line("  No incidents", false)
+		}
+		for i := kb.top; i < len(kb.shown) && i < kb.top+kb.listRows(); i++ {
+			line(kb.row(kb.shown[i]), i == kb.cursor)
+		}
+		hint = "↑↓ move  Enter open  / filter  u unacknowledged  a acknowledge  n note  q quit"
+	}
+
+	// The bottom line stays at the bottom of the screen
+	fmt.Fprintf(&sb, "\x1b[%d;1H", kb.rows)
+	switch {
+	case kb.screen == screenFilter:
+		hint = "/" + string(kb.input) + "_"
+	case kb.screen == screenNote:
+		hint = "Note: " + string(kb.input) + "_"
+	case len(kb.status) > 0:
+		hint = kb.status
+	}
+	if r := []rune(hint); len(r) > kb.cols {
+		hint = string(r[:kb.cols])
+	}
+	sb.WriteString(hint + "\x1b[K")
+	io.WriteString(kb.out, sb.String())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("j\x1b[A\x1b[6~\r\x7fé\x03"))
+	for _, want := range []rune{'j', keyUp, keyPageDown, keyEnter, keyBackspace, 'é', keyQuit} {
+		if k, err := readKey(r); err != nil || k != want {
+			t.Errorf("read key %v, %v, want %v", k, err, want)
+		}
+	}
+
+	// An Esc on its own, with nothing after it, is the key itself
+	r = bufio.NewReader(strings.NewReader("\x1b"))
+	if k, _ := readKey(r); k != keyEsc {
+		t.Errorf("read key %v for a lone Esc", k)
+	}
+}
+
+// Keys move the selection within the list, narrow it down as a filter is
+// typed, and open and close incidents
+func TestKeyBrowser(t *testing.T) {
+	*logFlag = filepath.Join(t.TempDir(), "autoping.log")
+	t.Cleanup(func() { *logFlag = defaultLogPath })
+	if err := ioutil.WriteFile(*logFlag, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var incs []*browsedIncident
+	for i, target := range []string{"alpha.example", "beta.example", "alpha.example", "gamma.example"} {
+		inc := incident{target: target, kind: "Outage", start: start.Add(time.Duration(i) * time.Hour)}
+		inc.end = inc.start.Add(5 * time.Minute)
+		incs = append(incs, &browsedIncident{incident: inc})
+	}
+	incs[1].acked = true
+	kb := &keyBrowser{browser: &browser{incs: incs}, out: ioutil.Discard, rows: 5, cols: 80}
+	kb.refresh()
+	press := func(keys ...rune) {
+		for _, k := range keys {
+			if kb.key(k) {
+				t.Fatalf("quit on key %v", k)
+			}
+		}
+	}
+
+	// The list has room for 2 incidents, newest first
+	press(keyDown, keyDown, keyDown, keyDown)
+	if kb.cursor != 3 || kb.top != 2 {
+		t.Errorf("cursor %d and top %d after moving past the end, want 3 and 2", kb.cursor, kb.top)
+	}
+	press(keyHome)
+	if kb.selected() != incs[3] {
+		t.Errorf("selected %s after Home, want the newest", kb.selected().target)
+	}
+
+	press('/', 'a', 'l')
+	if kb.screen != screenFilter || len(kb.shown) != 2 {
+		t.Errorf("%d incidents listed while typing alpha, want 2", len(kb.shown))
+	}
+	press(keyBackspace, 'x', keyEnter)
+	if kb.screen != screenList || kb.filter != "ax" || len(kb.shown) != 0 || kb.selected() != nil {
+		t.Errorf("filter %q lists %d incidents, want none", kb.filter, len(kb.shown))
+	}
+	press(keyEsc, 'u')
+	if len(kb.shown) != 3 {
+		t.Errorf("%d unacknowledged incidents listed with the filter dropped, want 3", len(kb.shown))
+	}
+
+	press(keyDown, keyEnter)
+	if kb.screen != screenDetail || kb.opened != incs[2] || !strings.HasPrefix(kb.lines[0], "Outage of alpha.example") {
+		t.Errorf("opened %v on screen %d", kb.lines, kb.screen)
+	}
+	press('n', 'x', keyEsc, keyEsc)
+	if kb.screen != screenList || len(incs[2].notes) > 0 {
+		t.Errorf("screen %d and notes %v after dropping a note and going back", kb.screen, incs[2].notes)
+	}
+	if !kb.key('q') {
+		t.Error("q didn't quit")
+	}
+
+	// The screen is drawn after each key, with the selection highlighted
+	var out bytes.Buffer
+	b := &browser{incs: incs}
+	if err := browseKeys(b, strings.NewReader("/gam\rq"), &out, 6, 80); err != nil {
+		t.Fatal(err)
+	}
+	if screen := out.String(); !strings.Contains(screen, "\x1b[7m  gamma.example") ||
+		!strings.Contains(screen, `1 of 4, matching "gam"`) || !strings.HasSuffix(screen, "\x1b[?1049l") {
+		t.Errorf("drawn screens don't show the filtered list:\n%q", screen)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	ping "github.com/go-ping/ping"
+	"github.com/kurankat/autoping-go/config"
+)
+
+// Game servers and VoIP gateways often don't answer pings, but do answer
+// their own protocol over UDP. A target given as udp:// and a host and port,
+// e.g. udp://game.example.com:27015, is probed by sending it a datagram and
+// waiting for one back. The datagram is the payload set for the target under
+// overrides, or "autoping", which an echo service sends straight back. With
+// expect set too, only an answer holding it counts as a pong, and any other
+// answer is a missed pong with the failure udp_error, as is a port nothing is
+// listening on. The time to the answer stands in for the RTT
+
+const udpAnswerLimit = 64 << 10 // Bytes of an answer read, the most a datagram can hold
+
+var udpPayload = []byte("autoping") // Datagram sent when no payload is set
+
+type udpProber struct{} // Probes udp:// targets with a datagram
+
+// Method to check that the supplied UDP target has a host and port
+func (udpProber) check(s *settings, addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil || len(u.Hostname()) == 0 {
+		return fmt.Errorf("target %s must be udp:// and a host and port", addr)
+	}
+	if port, err := strconv.Atoi(u.Port()); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("target %s must be udp:// and a host and port", addr)
+	}
+	return nil
+}
+
+// Method to return the host of the supplied UDP target
+func (udpProber) host(s *settings, addr string) (string, error) {
+	return urlHost(addr)
+}
+
+// Method to return the options of UDP targets set in the supplied overrides
+func (udpProber) options(o config.Override) string {
+	if len(o.Payload) > 0 || len(o.Expect) > 0 {
+		return "a payload or an answer to expect"
+	}
+	return ""
+}
+
+// Method to probe the supplied target by sending it a datagram and waiting for
+// one back, in place of a ping
+func (udpProber) probe(tg *target) {
+	t := time.Now() // Keep track of the time the datagram was sent
+	deadline := t.Add(tg.timeout())
+	u, err := url.Parse(tg.addr)
+	if err != nil {
+		tg.pingFailed(t, failSocket, err)
+		return
+	}
+	o := currentSettings().overrides[tg.addr]
+	payload := []byte(o.Payload)
+	if len(payload) == 0 {
+		payload = udpPayload
+	}
+
+	tg.logf(tLog, "Sending %d bytes to %s over UDP", len(payload), u.Host)
+	conn, err := net.DialTimeout("udp", u.Host, time.Until(deadline))
+	if err != nil {
+		tg.probeFailed(t, failUDP, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(payload); err != nil {
+		tg.probeFailed(t, failUDP, err)
+		return
+	}
+	answer := make([]byte, udpAnswerLimit)
+	n, err := conn.Read(answer)
+	rtt := time.Since(t)
+	if err != nil {
+		// A port nothing listens on is refused, once the ICMP error comes back
+		tg.probeFailed(t, failUDP, err)
+		return
+	}
+	if len(o.Expect) > 0 && !bytes.Contains(answer[:n], o.Expect) {
+		tg.pingFailed(t, failUDP, fmt.Errorf("answer of %d bytes from %s isn't the one expected", n, conn.RemoteAddr()))
+		return
+	}
+
+	if tg.samplePong(rtt) {
+		tg.logf(pLog, "%d bytes from %s: time=%v", n, conn.RemoteAddr(), rtt)
+	}
+	tg.cycleFinished(t, &ping.Statistics{PacketsSent: 1, PacketsRecv: 1, Addr: tg.addr,
+		Rtts: []time.Duration{rtt}, MinRtt: rtt, MaxRtt: rtt, AvgRtt: rtt})
+}